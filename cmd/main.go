@@ -2,28 +2,84 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
 	"github.com/container-registry/harbor-satellite/internal/logger"
 	"github.com/container-registry/harbor-satellite/internal/satellite"
+	"github.com/container-registry/harbor-satellite/internal/server"
 	"github.com/container-registry/harbor-satellite/internal/state"
+	"github.com/container-registry/harbor-satellite/internal/storage"
 	"github.com/container-registry/harbor-satellite/internal/utils"
 	"github.com/container-registry/harbor-satellite/registry"
 
 	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 )
 
 func main() {
-	err := run()
-	if err != nil {
+	rootCmd := &cobra.Command{
+		Use:          "harbor-satellite",
+		Short:        "Replicates the images of the Ground Control states to the local registry",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run()
+		},
+	}
+	rootCmd.AddCommand(newPlanCommand())
+	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// newPlanCommand prints the changes the next reconciliation would apply to the local registry, without applying them
+func newPlanCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "plan",
+		Short: "Shows the images the satellite would delete and replicate on its next reconciliation",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := utils.SetupContext(context.Background())
+			defer cancel()
+
+			ctx, _, scheduler, err := utils.Init(ctx)
+			if err != nil {
+				return err
+			}
+			log := logger.FromContext(ctx)
+			if !config.GetOwnRegistry() {
+				var defaultZotConfig registry.ZotConfig
+				if err := registry.ReadZotConfig(config.GetZotConfigPath(), &defaultZotConfig); err != nil {
+					return fmt.Errorf("error reading config: %w", err)
+				}
+				if defaultZotConfig.Storage.RootDirectory != "" {
+					config.SetDefaultStateStorePath(filepath.Join(defaultZotConfig.Storage.RootDirectory, config.StateStoreFileName))
+				}
+				if defaultZotConfig.Log.Output != "" {
+					config.SetDefaultRegistryLogPath(defaultZotConfig.Log.Output)
+				}
+			}
+
+			localRegistryConfig := state.NewRegistryConfig(config.GetRemoteRegistryURL(), config.GetRemoteRegistryUsername(), config.GetRemoteRegistryPassword())
+			sourceRegistryConfig := state.NewRegistryConfig(config.GetSourceRegistryURL(), config.GetSourceRegistryUsername(), config.GetSourceRegistryPassword())
+			// The request log of the registry is left to the running satellite, which reads and truncates it
+			satelliteService := satellite.NewSatellite(ctx, scheduler.GetSchedulerKey(), localRegistryConfig, sourceRegistryConfig, config.UseUnsecure(), config.GetState(), nil)
+			plan, err := satelliteService.Plan(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Error computing the reconciliation plan")
+				return err
+			}
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(plan)
+		},
+	}
+}
+
 func run() error {
 	ctx, cancel := utils.SetupContext(context.Background())
 	defer cancel()
@@ -51,15 +107,55 @@ func run() error {
 
 	localRegistryConfig := state.NewRegistryConfig(config.GetRemoteRegistryURL(), config.GetRemoteRegistryUsername(), config.GetRemoteRegistryPassword())
 	sourceRegistryConfig := state.NewRegistryConfig(config.GetSourceRegistryURL(), config.GetSourceRegistryUsername(), config.GetSourceRegistryPassword())
-	satelliteService := satellite.NewSatellite(ctx, scheduler.GetSchedulerKey(), localRegistryConfig, sourceRegistryConfig, config.UseUnsecure(), config.GetState())
+	// The request log of the registry is read periodically, so that it is truncated even if the pulls are not needed
+	var registryLog *storage.RegistryLog
+	var maintenance *storage.Maintenance
+	if path := config.GetRegistryLogPath(); path != "" {
+		registryLog = storage.NewRegistryLog(path, config.GetRegistryLogOffsetPath())
+		// zot collects the garbage and scrubs the images of the bundled registry, the satellite reports them
+		if !config.GetOwnRegistry() {
+			maintenance = storage.NewMaintenance(registryLog)
+		}
+		wg.Go(func() error {
+			registryLog.Watch(ctx, storage.RegistryLogReadInterval, log)
+			return nil
+		})
+	}
+	satelliteService := satellite.NewSatellite(ctx, scheduler.GetSchedulerKey(), localRegistryConfig, sourceRegistryConfig, config.UseUnsecure(), config.GetState(), registryLog)
 
 	wg.Go(func() error {
 		return satelliteService.Run(ctx)
 	})
 
+	// The satellite is alive while its scheduler runs, and ready once it is registered and replicating to an
+	// answering local registry with enough disk space left. An unreachable registry does not warrant a restart.
+	health := server.NewHealthRegistrar()
+	health.AddLivenessCheck("scheduler", scheduler.HealthCheck)
+	health.AddReadinessCheck("registry", satelliteService.RegistryCheck)
+	health.AddReadinessCheck("ztr", satellite.ZTRCheck)
+	health.AddReadinessCheck("replication", satelliteService.ReplicationCheck)
+	health.AddReadinessCheck("disk", storage.DiskHeadroomCheck(filepath.Dir(config.GetStateStorePath()), config.GetMinFreeDisk()))
+
+	// Serve the local API of the satellite
+	registrars := []server.RouteRegistrar{
+		health,
+		satellite.NewPlanRegistrar(ctx, satelliteService),
+		satellite.NewStatusRegistrar(ctx, satelliteService, scheduler),
+		&server.MetricsRegistrar{},
+	}
+	if maintenance != nil {
+		registrars = append(registrars, satellite.NewGarbageCollectionRegistrar(ctx, maintenance))
+	}
+	router := server.NewDefaultRouter("")
+	router.Use(server.TokenAuthMiddleware(config.GetAPIToken()))
+	app := server.NewApp(router, ctx, log, config.GetAPIAddress(), registrars...)
+	app.SetupRoutes()
+	app.SetupServer(wg)
+
 	return wg.Wait()
 }
 
+// handleRegistrySetup launches the bundled zot registry unless the satellite brings its own
 func handleRegistrySetup(g *errgroup.Group, log *zerolog.Logger, cancel context.CancelFunc) error {
 	log.Debug().Msg("Setting up local registry")
 	if config.GetOwnRegistry() {
@@ -69,28 +165,53 @@ func handleRegistrySetup(g *errgroup.Group, log *zerolog.Logger, cancel context.
 			cancel()
 			return err
 		}
-	} else {
-		log.Info().Msg("Launching default registry")
-		var defaultZotConfig registry.ZotConfig
-		if err := registry.ReadZotConfig(config.GetZotConfigPath(), &defaultZotConfig); err != nil {
-			log.Error().Err(err).Msg("Error launching default zot registry")
-			return fmt.Errorf("error reading config: %w", err)
-		}
+		return nil
+	}
 
-		err := config.SetRemoteRegistryURL(defaultZotConfig.GetRegistryURL())
-		if err != nil {
-			return fmt.Errorf("error setting RemoteRegistryURL")
-		}
+	log.Info().Msg("Launching default registry")
+	var defaultZotConfig registry.ZotConfig
+	if err := registry.ReadZotConfig(config.GetZotConfigPath(), &defaultZotConfig); err != nil {
+		log.Error().Err(err).Msg("Error launching default zot registry")
+		return fmt.Errorf("error reading config: %w", err)
+	}
 
-		g.Go(func() error {
-			if err := registry.LaunchRegistry(config.GetZotConfigPath()); err != nil {
-				log.Error().Err(err).Msg("Error launching default zot registry")
-				cancel()
-				return fmt.Errorf("error launching default zot registry: %w", err)
-			}
+	err := config.SetRemoteRegistryURL(defaultZotConfig.GetRegistryURL())
+	if err != nil {
+		return fmt.Errorf("error setting RemoteRegistryURL")
+	}
+	// Keep the reconciliation state next to the images it describes
+	if defaultZotConfig.Storage.RootDirectory != "" {
+		config.SetDefaultStateStorePath(filepath.Join(defaultZotConfig.Storage.RootDirectory, config.StateStoreFileName))
+	}
+	// Read the pulls from the request log of zot, written to the file it is configured with
+	if defaultZotConfig.Log.Output != "" {
+		config.SetDefaultRegistryLogPath(defaultZotConfig.Log.Output)
+	}
+
+	// Generate the garbage collection, scrub and log settings of zot from the satellite config
+	zotConfigPath, err := registry.WriteMaintenanceConfig(config.GetZotConfigPath(), registry.MaintenanceConfig{
+		GC:            config.IsGarbageCollectionEnabled(),
+		GCDelay:       config.GetGCDelay().String(),
+		GCInterval:    config.GetGCInterval(),
+		ScrubInterval: config.GetScrubInterval(),
+		LogOutput:     config.GetRegistryLogPath(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating the zot config")
+		return fmt.Errorf("error generating the zot config: %w", err)
+	}
+
+	g.Go(func() error {
+		if err := registry.LaunchRegistry(zotConfigPath); err != nil {
+			log.Error().Err(err).Msg("Error launching default zot registry")
 			cancel()
-			return nil
-		})
+			return fmt.Errorf("error launching default zot registry: %w", err)
+		}
+		cancel()
+		return nil
+	})
+	if err := storage.RegisterDiskUsage(defaultZotConfig.Storage.RootDirectory); err != nil {
+		log.Warn().Err(err).Msg("Error registering the disk usage metric of the local registry")
 	}
 	return nil
 }
@@ -3,6 +3,7 @@ module github.com/container-registry/harbor-satellite/ground-control
 go 1.24.1
 
 require (
+	github.com/Masterminds/semver/v3 v3.3.1
 	github.com/goharbor/go-client v0.210.0
 	github.com/google/go-containerregistry v0.20.3
 	github.com/gorilla/mux v1.8.1
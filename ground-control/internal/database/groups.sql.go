@@ -151,3 +151,23 @@ func (q *Queries) ListGroups(ctx context.Context) ([]Group, error) {
 	}
 	return items, nil
 }
+
+const lockGroup = `-- name: LockGroup :one
+SELECT id, group_name, registry_url, projects, created_at, updated_at FROM groups
+WHERE id = $1
+FOR UPDATE
+`
+
+func (q *Queries) LockGroup(ctx context.Context, id int32) (Group, error) {
+	row := q.db.QueryRowContext(ctx, lockGroup, id)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.GroupName,
+		&i.RegistryUrl,
+		pq.Array(&i.Projects),
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
@@ -5,9 +5,19 @@
 package database
 
 import (
+	"database/sql"
 	"time"
 )
 
+type ApiToken struct {
+	ID        int32
+	Name      string
+	TokenHash string
+	Role      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 type Group struct {
 	ID          int32
 	GroupName   string
@@ -18,13 +28,16 @@ type Group struct {
 }
 
 type RobotAccount struct {
-	ID          int32
-	RobotName   string
-	RobotSecret string
-	RobotID     string
-	SatelliteID int32
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID              int32
+	RobotName       string
+	RobotSecret     string
+	RobotID         string
+	SatelliteID     int32
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	PreviousSecret  string
+	SecretCutoverAt sql.NullTime
+	SecretRotatedAt time.Time
 }
 
 type Satellite struct {
@@ -34,11 +47,42 @@ type Satellite struct {
 	UpdatedAt time.Time
 }
 
+type SatelliteCertificate struct {
+	Serial      string
+	SatelliteID int32
+	NotAfter    time.Time
+	RevokedAt   sql.NullTime
+	CreatedAt   time.Time
+}
+
 type SatelliteGroup struct {
 	SatelliteID int32
 	GroupID     int32
 }
 
+type SatelliteStateStatus struct {
+	SatelliteID    int32
+	StateUrl       string
+	Version        int64
+	Digest         string
+	LastSync       sql.NullTime
+	SyncSuccess    bool
+	SyncError      string
+	FailedEntities int32
+}
+
+type SatelliteStatus struct {
+	SatelliteID    int32
+	Version        string
+	GitCommit      string
+	System         string
+	UptimeSeconds  int64
+	LastError      string
+	DiskTotalBytes int64
+	DiskFreeBytes  int64
+	LastHeartbeat  time.Time
+}
+
 type SatelliteToken struct {
 	ID          int32
 	SatelliteID int32
@@ -46,3 +90,9 @@ type SatelliteToken struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+type StateVersion struct {
+	Subject   string
+	Version   int64
+	UpdatedAt time.Time
+}
@@ -7,6 +7,8 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
 const addRobotAccount = `-- name: AddRobotAccount :one
@@ -16,8 +18,11 @@ VALUES ($1, $2, $3, $4, NOW(), NOW())
   DO UPDATE SET
   robot_name = EXCLUDED.robot_name,
   robot_secret = EXCLUDED.robot_secret,
+  previous_secret = '',
+  secret_cutover_at = NULL,
+  secret_rotated_at = NOW(),
   updated_at = NOW()
-RETURNING id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at
+RETURNING id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at, previous_secret, secret_cutover_at, secret_rotated_at
 `
 
 type AddRobotAccountParams struct {
@@ -43,10 +48,26 @@ func (q *Queries) AddRobotAccount(ctx context.Context, arg AddRobotAccountParams
 		&i.SatelliteID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PreviousSecret,
+		&i.SecretCutoverAt,
+		&i.SecretRotatedAt,
 	)
 	return i, err
 }
 
+const applyRobotSecret = `-- name: ApplyRobotSecret :exec
+UPDATE robot_accounts
+SET previous_secret = '',
+    secret_cutover_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) ApplyRobotSecret(ctx context.Context, id int32) error {
+	_, err := q.db.ExecContext(ctx, applyRobotSecret, id)
+	return err
+}
+
 const deleteRobotAccount = `-- name: DeleteRobotAccount :exec
 DELETE FROM robot_accounts
 WHERE id = $1
@@ -57,8 +78,31 @@ func (q *Queries) DeleteRobotAccount(ctx context.Context, id int32) error {
 	return err
 }
 
+const getRobotAccByName = `-- name: GetRobotAccByName :one
+SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at, previous_secret, secret_cutover_at, secret_rotated_at FROM robot_accounts
+WHERE robot_name = $1
+`
+
+func (q *Queries) GetRobotAccByName(ctx context.Context, robotName string) (RobotAccount, error) {
+	row := q.db.QueryRowContext(ctx, getRobotAccByName, robotName)
+	var i RobotAccount
+	err := row.Scan(
+		&i.ID,
+		&i.RobotName,
+		&i.RobotSecret,
+		&i.RobotID,
+		&i.SatelliteID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.PreviousSecret,
+		&i.SecretCutoverAt,
+		&i.SecretRotatedAt,
+	)
+	return i, err
+}
+
 const getRobotAccBySatelliteID = `-- name: GetRobotAccBySatelliteID :one
-SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at FROM robot_accounts
+SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at, previous_secret, secret_cutover_at, secret_rotated_at FROM robot_accounts
 WHERE satellite_id = $1
 `
 
@@ -73,12 +117,15 @@ func (q *Queries) GetRobotAccBySatelliteID(ctx context.Context, satelliteID int3
 		&i.SatelliteID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PreviousSecret,
+		&i.SecretCutoverAt,
+		&i.SecretRotatedAt,
 	)
 	return i, err
 }
 
 const getRobotAccount = `-- name: GetRobotAccount :one
-SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at FROM robot_accounts
+SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at, previous_secret, secret_cutover_at, secret_rotated_at FROM robot_accounts
 WHERE id = $1
 `
 
@@ -93,12 +140,15 @@ func (q *Queries) GetRobotAccount(ctx context.Context, id int32) (RobotAccount,
 		&i.SatelliteID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PreviousSecret,
+		&i.SecretCutoverAt,
+		&i.SecretRotatedAt,
 	)
 	return i, err
 }
 
 const listRobotAccounts = `-- name: ListRobotAccounts :many
-SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at FROM robot_accounts
+SELECT id, robot_name, robot_secret, robot_id, satellite_id, created_at, updated_at, previous_secret, secret_cutover_at, secret_rotated_at FROM robot_accounts
 `
 
 func (q *Queries) ListRobotAccounts(ctx context.Context) ([]RobotAccount, error) {
@@ -118,6 +168,9 @@ func (q *Queries) ListRobotAccounts(ctx context.Context) ([]RobotAccount, error)
 			&i.SatelliteID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.PreviousSecret,
+			&i.SecretCutoverAt,
+			&i.SecretRotatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -157,3 +210,33 @@ func (q *Queries) UpdateRobotAccount(ctx context.Context, arg UpdateRobotAccount
 	)
 	return err
 }
+
+const stageRobotSecret = `-- name: StageRobotSecret :execrows
+UPDATE robot_accounts
+SET previous_secret = robot_secret,
+    robot_secret = $2,
+    secret_cutover_at = $3,
+    secret_rotated_at = $4,
+    updated_at = NOW()
+WHERE id = $1 AND secret_cutover_at IS NULL
+`
+
+type StageRobotSecretParams struct {
+	ID              int32
+	RobotSecret     string
+	SecretCutoverAt sql.NullTime
+	SecretRotatedAt time.Time
+}
+
+func (q *Queries) StageRobotSecret(ctx context.Context, arg StageRobotSecretParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, stageRobotSecret,
+		arg.ID,
+		arg.RobotSecret,
+		arg.SecretCutoverAt,
+		arg.SecretRotatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,26 +1,58 @@
 package models
 
+import "time"
+
 type SatelliteStateArtifact struct {
-	States []string `json:"states,omitempty"`
+	States  []string `json:"states,omitempty"`
+	Version int64    `json:"version,omitempty"`
+	Subject string   `json:"subject,omitempty"`
 }
 
 type StateArtifact struct {
 	Group     string     `json:"group,omitempty"`
 	Registry  string     `json:"registry,omitempty"`
 	Artifacts []Artifact `json:"artifacts,omitempty"`
+	Version   int64      `json:"version,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	// Rules are the artifacts as submitted, kept when some of them use tag filters so that
+	// Ground Control can expand the filters again as new tags are pushed to Harbor
+	Rules []Artifact `json:"rules,omitempty"`
 }
 type Artifact struct {
-	Repository string   `json:"repository,omitempty"`
-	Tag        []string `json:"tag,omitempty"`
-	Labels     any      `json:"labels,omitempty"`
-	Type       string   `json:"type,omitempty"`
-	Digest     string   `json:"digest,omitempty"`
-	Deleted    bool     `json:"deleted,omitempty"`
+	Repository string     `json:"repository,omitempty"`
+	Tag        []string   `json:"tag,omitempty"`
+	Labels     any        `json:"labels,omitempty"`
+	Type       string     `json:"type,omitempty"`
+	Digest     string     `json:"digest,omitempty"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	Filter     *TagFilter `json:"filter,omitempty"`
+}
+
+// TagFilter selects the tags of a repository in Harbor instead of listing them. The regex and the
+// semver constraint must both match when set, Latest then keeps the N most recently pushed tags.
+type TagFilter struct {
+	Regex  string `json:"regex,omitempty"`
+	Semver string `json:"semver,omitempty"`
+	Latest int    `json:"latest,omitempty"`
 }
 
 type ZtrResult struct {
 	State string  `json:"state"`
 	Auth  Account `json:"auth"`
+	// Certificate is the client certificate issued for the certificate request sent at registration, the satellite
+	// authenticates with it to Ground Control from then on
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// SatelliteCertificate is a client certificate issued to a satellite
+type SatelliteCertificate struct {
+	Certificate string    `json:"certificate"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+type SyncResult struct {
+	States []string `json:"states"`
+	Auth   Account  `json:"auth"`
 }
 
 type Account struct {
@@ -28,3 +60,96 @@ type Account struct {
 	Secret   string `json:"secret"`
 	Registry string `json:"registry"`
 }
+
+// Heartbeat is the status periodically reported by a satellite
+type Heartbeat struct {
+	Version        string           `json:"version"`
+	GitCommit      string           `json:"git_commit,omitempty"`
+	System         string           `json:"system,omitempty"`
+	UptimeSeconds  int64            `json:"uptime_seconds"`
+	States         []HeartbeatState `json:"states"`
+	LastError      string           `json:"last_error,omitempty"`
+	DiskTotalBytes int64            `json:"disk_total_bytes,omitempty"`
+	DiskFreeBytes  int64            `json:"disk_free_bytes,omitempty"`
+}
+
+// HeartbeatState is a group state as applied by the satellite
+type HeartbeatState struct {
+	URL      string         `json:"url"`
+	Version  int64          `json:"version"`
+	Digest   string         `json:"digest,omitempty"`
+	LastSync *HeartbeatSync `json:"last_sync,omitempty"`
+}
+
+// HeartbeatSync is the outcome of the last reconciliation of a group state by the satellite
+type HeartbeatSync struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Failed  int       `json:"failed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// SatelliteInfo is a satellite along with the status reported by its last heartbeat. Status is online, stale
+// or offline depending on the time since the last heartbeat.
+type SatelliteInfo struct {
+	ID             int32            `json:"id"`
+	Name           string           `json:"name"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Status         string           `json:"status"`
+	LastHeartbeat  *time.Time       `json:"last_heartbeat,omitempty"`
+	Version        string           `json:"version,omitempty"`
+	GitCommit      string           `json:"git_commit,omitempty"`
+	System         string           `json:"system,omitempty"`
+	UptimeSeconds  int64            `json:"uptime_seconds,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	DiskTotalBytes int64            `json:"disk_total_bytes,omitempty"`
+	DiskFreeBytes  int64            `json:"disk_free_bytes,omitempty"`
+	States         []HeartbeatState `json:"states,omitempty"`
+}
+
+// GroupRollout is the progress of the latest state of a group across its member satellites
+type GroupRollout struct {
+	Group string `json:"group"`
+	// Version, Digest and Artifacts describe the latest state artifact of the group
+	Version    int64              `json:"version"`
+	Digest     string             `json:"digest"`
+	Artifacts  int                `json:"artifacts"`
+	Summary    RolloutSummary     `json:"summary"`
+	Page       int                `json:"page"`
+	PageSize   int                `json:"page_size"`
+	Total      int                `json:"total"`
+	Satellites []SatelliteRollout `json:"satellites"`
+}
+
+// RolloutSummary counts the member satellites of a group by rollout status, regardless of the filters
+type RolloutSummary struct {
+	Total    int `json:"total"`
+	UpToDate int `json:"up_to_date"`
+	Behind   int `json:"behind"`
+	Failed   int `json:"failed"`
+	Pending  int `json:"pending"`
+}
+
+// SatelliteRollout is the state of a group as applied by one of its member satellites. Status is up_to_date,
+// behind, failed or pending if the satellite never reported the state.
+type SatelliteRollout struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Connectivity  string     `json:"connectivity"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	Version       int64      `json:"version,omitempty"`
+	Digest        string     `json:"digest,omitempty"`
+	// ArtifactsBehind is the number of artifacts of the latest state the satellite did not apply yet, unset if
+	// the state applied by the satellite could not be read
+	ArtifactsBehind *int           `json:"artifacts_behind,omitempty"`
+	LastSync        *HeartbeatSync `json:"last_sync,omitempty"`
+}
+
+// APIToken grants a role on the Ground Control API, the token itself is only returned when it is created
+type APIToken struct {
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
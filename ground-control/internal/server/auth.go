@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/models"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+// Roles of the API tokens, each role is granted the permissions of the roles below it
+const (
+	RoleAdmin       = "admin"
+	RoleGroupEditor = "group-editor"
+	RoleReadOnly    = "read-only"
+)
+
+var roleRank = map[string]int{
+	RoleReadOnly:    1,
+	RoleGroupEditor: 2,
+	RoleAdmin:       3,
+}
+
+// apiTokenLength is the number of hex characters of the generated API tokens
+const apiTokenLength = 64
+
+// Caller is the identity behind the API token of a request
+type Caller struct {
+	Name string
+	Role string
+}
+
+type callerKey struct{}
+
+// CallerFromContext returns the caller authenticated by the requireRole middleware
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(Caller)
+	return caller, ok
+}
+
+// hashAPIToken returns the hex encoded SHA-256 of the token, only the hash is stored
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireRole returns a middleware rejecting the requests whose bearer token does not grant at least the role
+func (s *Server) requireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, err := s.authenticateCaller(r)
+			if err != nil {
+				log.Printf("rejected %s %s: %v", r.Method, r.URL.Path, err)
+				HandleAppError(w, err)
+				return
+			}
+			if roleRank[caller.Role] < roleRank[role] {
+				log.Printf("rejected %s %s: %s has role %s, %s required", r.Method, r.URL.Path, caller.Name, caller.Role, role)
+				HandleAppError(w, &AppError{
+					Message: "Error: Forbidden",
+					Code:    http.StatusForbidden,
+				})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+		})
+	}
+}
+
+// authenticateCaller resolves the bearer token of the request, either the admin token from the environment
+// or an API token stored in the database
+func (s *Server) authenticateCaller(r *http.Request) (Caller, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return Caller{}, &AppError{
+			Message: "Authorization header missing",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+	if s.adminToken != "" && subtle.ConstantTimeCompare([]byte(s.adminToken), []byte(token)) == 1 {
+		return Caller{Name: "admin", Role: RoleAdmin}, nil
+	}
+
+	apiToken, err := s.dbQueries.GetAPITokenByHash(r.Context(), hashAPIToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Caller{}, &AppError{
+			Message: "Error: Invalid Credentials",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+	if err != nil {
+		log.Printf("failed to look up API token: %v", err)
+		return Caller{}, &AppError{
+			Message: "Error: Failed to Authenticate",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	return Caller{Name: apiToken.Name, Role: apiToken.Role}, nil
+}
+
+type CreateAPITokenParams struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// createAPITokenHandler creates a token with the role, the token is returned once and only its hash is kept
+func (s *Server) createAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPITokenParams
+	if err := DecodeRequestBody(r, &req); err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+	if !utils.IsValidName(req.Name) {
+		HandleAppError(w, &AppError{
+			Message: fmt.Sprintf(invalidNameMessage, "token"),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		HandleAppError(w, &AppError{
+			Message: fmt.Sprintf("Invalid role %q: must be one of %s, %s, %s", req.Role, RoleAdmin, RoleGroupEditor, RoleReadOnly),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	token, err := GenerateRandomToken(apiTokenLength)
+	if err != nil {
+		log.Printf("failed to generate API token: %v", err)
+		HandleAppError(w, err)
+		return
+	}
+	created, err := s.dbQueries.CreateAPIToken(r.Context(), database.CreateAPITokenParams{
+		Name:      req.Name,
+		TokenHash: hashAPIToken(token),
+		Role:      req.Role,
+	})
+	if err != nil {
+		log.Printf("failed to create API token %s: %v", req.Name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Create Token, the name may already be used",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	caller, _ := CallerFromContext(r.Context())
+	log.Printf("API token %s with role %s created by %s", created.Name, created.Role, caller.Name)
+	WriteJSONResponse(w, http.StatusCreated, models.APIToken{
+		Name:      created.Name,
+		Role:      created.Role,
+		Token:     token,
+		CreatedAt: created.CreatedAt,
+	})
+}
+
+func (s *Server) listAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.dbQueries.ListAPITokens(r.Context())
+	if err != nil {
+		log.Printf("failed to list API tokens: %v", err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to List Tokens",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	result := make([]models.APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, models.APIToken{
+			Name:      token.Name,
+			Role:      token.Role,
+			CreatedAt: token.CreatedAt,
+		})
+	}
+	WriteJSONResponse(w, http.StatusOK, result)
+}
+
+func (s *Server) deleteAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["token"]
+	if err := s.dbQueries.DeleteAPITokenByName(r.Context(), name); err != nil {
+		log.Printf("failed to delete API token %s: %v", name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Delete Token",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	caller, _ := CallerFromContext(r.Context())
+	log.Printf("API token %s deleted by %s", name, caller.Name)
+	WriteJSONResponse(w, http.StatusOK, map[string]string{})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+)
+
+func TestRequireRole(t *testing.T) {
+	db := sql.OpenDB(tokenConnector{
+		hashAPIToken("ci-token"):     {ID: 1, Name: "ci", Role: RoleAdmin},
+		hashAPIToken("editor-token"): {ID: 2, Name: "editor", Role: RoleGroupEditor},
+	})
+	t.Cleanup(func() { _ = db.Close() })
+	s := &Server{adminToken: "bootstrap", dbQueries: database.New(db)}
+	var caller Caller
+	handler := s.requireRole(RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ = CallerFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+		wantCaller    Caller
+	}{
+		{name: "missing token", authorization: "", want: http.StatusUnauthorized},
+		{name: "basic auth", authorization: "Basic Ym9vdHN0cmFwOg==", want: http.StatusUnauthorized},
+		{name: "admin token", authorization: "Bearer bootstrap", want: http.StatusOK, wantCaller: Caller{Name: "admin", Role: RoleAdmin}},
+		{name: "api token", authorization: "Bearer ci-token", want: http.StatusOK, wantCaller: Caller{Name: "ci", Role: RoleAdmin}},
+		{name: "lower role", authorization: "Bearer editor-token", want: http.StatusForbidden},
+		{name: "unknown token", authorization: "Bearer unknown", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller = Caller{}
+			req := httptest.NewRequest(http.MethodPost, "/satellites/register", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if caller != tt.wantCaller {
+				t.Errorf("caller = %+v, want %+v", caller, tt.wantCaller)
+			}
+		})
+	}
+}
+
+func TestRoleRank(t *testing.T) {
+	if roleRank[RoleReadOnly] >= roleRank[RoleGroupEditor] || roleRank[RoleGroupEditor] >= roleRank[RoleAdmin] {
+		t.Error("expected read-only < group-editor < admin")
+	}
+	if roleRank["unknown"] >= roleRank[RoleReadOnly] {
+		t.Error("expected unknown roles to be granted nothing")
+	}
+}
+
+// tokenConnector is a database answering the API token lookups from a map of token hash to token
+type tokenConnector map[string]database.ApiToken
+
+func (c tokenConnector) Connect(context.Context) (driver.Conn, error) { return tokenConn(c), nil }
+func (c tokenConnector) Driver() driver.Driver                        { return nil }
+
+type tokenConn map[string]database.ApiToken
+
+func (c tokenConn) Prepare(string) (driver.Stmt, error) { return tokenStmt(c), nil }
+func (c tokenConn) Close() error                        { return nil }
+func (c tokenConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type tokenStmt map[string]database.ApiToken
+
+func (s tokenStmt) Close() error  { return nil }
+func (s tokenStmt) NumInput() int { return 1 }
+func (s tokenStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("statements are not supported")
+}
+
+func (s tokenStmt) Query(args []driver.Value) (driver.Rows, error) {
+	token, ok := s[args[0].(string)]
+	if !ok {
+		return &tokenRows{}, nil
+	}
+	token.TokenHash = args[0].(string)
+	return &tokenRows{tokens: []database.ApiToken{token}}, nil
+}
+
+type tokenRows struct {
+	tokens []database.ApiToken
+}
+
+func (r *tokenRows) Columns() []string {
+	return []string{"id", "name", "token_hash", "role", "created_at", "updated_at"}
+}
+
+func (r *tokenRows) Close() error { return nil }
+
+func (r *tokenRows) Next(dest []driver.Value) error {
+	if len(r.tokens) == 0 {
+		return io.EOF
+	}
+	token := r.tokens[0]
+	r.tokens = r.tokens[1:]
+	dest[0], dest[1], dest[2], dest[3] = int64(token.ID), token.Name, token.TokenHash, token.Role
+	dest[4], dest[5] = time.Now(), time.Now()
+	return nil
+}
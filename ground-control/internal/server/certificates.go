@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/models"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+const defaultSatelliteCertValidity = 90 * 24 * time.Hour
+
+type ZtrParams struct {
+	Token string `json:"token"`
+	// CSR is the PEM encoded certificate request of the key generated by the satellite
+	CSR string `json:"csr,omitempty"`
+}
+
+type CertificateParams struct {
+	CSR string `json:"csr"`
+}
+
+// ztrCertificateHandler registers the satellite with the token in the body and issues a client certificate for
+// its certificate request, the private key never leaves the satellite
+func (s *Server) ztrCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	var req ZtrParams
+	if err := DecodeRequestBody(r, &req); err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+	if req.Token == "" {
+		HandleAppError(w, &AppError{
+			Message: "Error: Invalid Token",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	s.zeroTouchRegistration(w, r, req.Token, req.CSR)
+}
+
+// renewCertificateHandler issues a new client certificate to the authenticated satellite. Satellites registered
+// before the satellite CA was configured get their first certificate with their robot account.
+func (s *Server) renewCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	if s.ca == nil {
+		HandleAppError(w, &AppError{
+			Message: "Error: Satellite CA Not Configured",
+			Code:    http.StatusNotImplemented,
+		})
+		return
+	}
+	robot, err := s.authenticateSatellite(r)
+	if err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+
+	var req CertificateParams
+	if err := DecodeRequestBody(r, &req); err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+	satellite, err := s.dbQueries.GetSatellite(r.Context(), robot.SatelliteID)
+	if err != nil {
+		log.Printf("failed to get satellite: %v, %v", robot.SatelliteID, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Satellite Not Found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	certificate, err := s.issueSatelliteCertificate(r.Context(), s.dbQueries, satellite, req.CSR)
+	if err != nil {
+		HandleAppError(w, err)
+		return
+	}
+	log.Printf("certificate of satellite %s renewed, valid until %s", satellite.Name, certificate.NotAfter)
+	WriteJSONResponse(w, http.StatusOK, certificate)
+}
+
+// revokeCertificatesHandler revokes all the certificates of the satellite. The satellite can no longer
+// authenticate to Ground Control and has to be registered again.
+func (s *Server) revokeCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["satellite"]
+	satellite, err := s.dbQueries.GetSatelliteByName(r.Context(), name)
+	if err != nil {
+		log.Printf("error: failed to get satellite %s: %v", name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Satellite Not Found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	revoked, err := s.dbQueries.RevokeSatelliteCertificates(r.Context(), satellite.ID)
+	if err != nil {
+		log.Printf("failed to revoke certificates of satellite %s: %v", name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Revoke Certificates",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	caller, _ := CallerFromContext(r.Context())
+	log.Printf("%d certificates of satellite %s revoked by %s", revoked, name, caller.Name)
+	WriteJSONResponse(w, http.StatusOK, map[string]int64{"revoked": revoked})
+}
+
+// issueSatelliteCertificate signs the certificate request for the satellite and records the serial of the
+// certificate so that it can be revoked
+func (s *Server) issueSatelliteCertificate(ctx context.Context, q *database.Queries, satellite database.Satellite, csr string) (models.SatelliteCertificate, error) {
+	cert, certPEM, err := s.ca.IssueCertificate(csr, satellite.Name, s.satelliteCertValidity)
+	if err != nil {
+		log.Printf("failed to issue certificate for satellite %s: %v", satellite.Name, err)
+		return models.SatelliteCertificate{}, &AppError{
+			Message: fmt.Sprintf("Error: Invalid Certificate Request: %v", err),
+			Code:    http.StatusBadRequest,
+		}
+	}
+	err = q.AddSatelliteCertificate(ctx, database.AddSatelliteCertificateParams{
+		Serial:      utils.CertificateSerial(cert),
+		SatelliteID: satellite.ID,
+		// Stored in UTC as the column has no time zone
+		NotAfter: cert.NotAfter.UTC(),
+	})
+	if err != nil {
+		log.Printf("failed to store certificate of satellite %s: %v", satellite.Name, err)
+		return models.SatelliteCertificate{}, &AppError{
+			Message: "Error: Failed to Issue Certificate",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	return models.SatelliteCertificate{
+		Certificate: certPEM,
+		NotAfter:    cert.NotAfter,
+	}, nil
+}
+
+// authenticateSatelliteCertificate returns the robot account of the satellite the verified client certificate
+// was issued to, unless the certificate was revoked
+func (s *Server) authenticateSatelliteCertificate(r *http.Request) (database.RobotAccount, error) {
+	serial := utils.CertificateSerial(r.TLS.PeerCertificates[0])
+	certificate, err := s.dbQueries.GetSatelliteCertificate(r.Context(), serial)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Unknown Certificate",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+	if err != nil {
+		log.Printf("failed to look up certificate %s: %v", serial, err)
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Failed to Authenticate",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	if certificate.RevokedAt.Valid {
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Certificate Revoked",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+
+	robot, err := s.dbQueries.GetRobotAccBySatelliteID(r.Context(), certificate.SatelliteID)
+	if err != nil {
+		log.Printf("robot account not found for satellite %v: %v", certificate.SatelliteID, err)
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Invalid Credentials",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+	return robot, nil
+}
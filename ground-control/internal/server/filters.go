@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
+)
+
+const defaultTagFilterRefreshInterval = 10 * time.Minute
+
+// tagFilterRefreshInterval reads TAG_FILTER_REFRESH_INTERVAL, a zero interval disables the refresh
+func tagFilterRefreshInterval() time.Duration {
+	value := os.Getenv("TAG_FILTER_REFRESH_INTERVAL")
+	if value == "" {
+		return defaultTagFilterRefreshInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid TAG_FILTER_REFRESH_INTERVAL %q, using %s: %v", value, defaultTagFilterRefreshInterval, err)
+		return defaultTagFilterRefreshInterval
+	}
+	return interval
+}
+
+// refreshTagFilters periodically expands the tag filters of the group states again, so that the states
+// follow the tags pushed to Harbor without the groups being synced again
+func (s *Server) refreshTagFilters(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			groups, err := s.dbQueries.ListGroups(ctx)
+			if err != nil {
+				log.Printf("Error listing groups to refresh tag filters: %v", err)
+				continue
+			}
+			for _, group := range groups {
+				if ctx.Err() != nil {
+					return
+				}
+				updated, err := s.refreshGroupTagFilters(ctx, group.ID)
+				if err != nil {
+					log.Printf("Error refreshing tag filters of group %s: %v", group.GroupName, err)
+					continue
+				}
+				if updated {
+					log.Printf("Updated the state of group %s with the tags matching its filters", group.GroupName)
+				}
+			}
+		}
+	}
+}
+
+// refreshGroupTagFilters refreshes the state of the group while holding a lock on its row, the sync of the
+// group waits for the refresh to complete so that a state pushed by the sync is never overwritten by a
+// refresh of the previous state
+func (s *Server) refreshGroupTagFilters(ctx context.Context, groupID int32) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	group, err := s.dbQueries.WithTx(tx).LockGroup(ctx, groupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The group was deleted in the meantime
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	updated, err := utils.RefreshTagFilters(ctx, s.dbQueries, group.GroupName)
+	if err != nil {
+		return false, err
+	}
+	return updated, tx.Commit()
+}
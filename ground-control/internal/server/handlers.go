@@ -2,14 +2,17 @@ package server
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
 	"github.com/container-registry/harbor-satellite/ground-control/internal/models"
@@ -74,6 +77,31 @@ func (s *Server) groupsSyncHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, artifact := range req.Artifacts {
+		if artifact.Filter == nil {
+			continue
+		}
+		if err := utils.ValidateTagFilter(artifact.Filter); err != nil {
+			err := &AppError{
+				Message: fmt.Sprintf("Invalid filter for %s: %v", artifact.Repository, err),
+				Code:    http.StatusBadRequest,
+			}
+			HandleAppError(w, err)
+			return
+		}
+	}
+
+	// Expand the tag filters against Harbor so that the state lists the matching tags explicitly
+	if err := utils.ExpandTagFilters(r.Context(), &req); err != nil {
+		log.Println(err)
+		err := &AppError{
+			Message: fmt.Sprintf("Error: expanding tag filters: %v", err),
+			Code:    http.StatusBadGateway,
+		}
+		HandleAppError(w, err)
+		return
+	}
+
 	// Start a new transaction
 	tx, err := s.db.BeginTx(r.Context(), nil)
 	if err != nil {
@@ -98,6 +126,7 @@ func (s *Server) groupsSyncHandler(w http.ResponseWriter, r *http.Request) {
 		RegistryUrl: os.Getenv("HARBOR_URL"),
 		Projects:    projects,
 	}
+	// The upsert locks the row of the group until the state is pushed, a refresh of its tag filters waits for the sync
 	result, err := q.CreateGroup(r.Context(), params)
 	if err != nil {
 		log.Println(err)
@@ -154,7 +183,7 @@ func (s *Server) groupsSyncHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create State Artifact for the group
-	err = utils.CreateStateArtifact(r.Context(), &req)
+	err = utils.CreateStateArtifact(r.Context(), s.dbQueries, &req)
 	if err != nil {
 		log.Println(err)
 		HandleAppError(w, err)
@@ -373,7 +402,7 @@ func (s *Server) registerSatelliteHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Create the satellite's state artifact
-	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), req.Name, groupStates)
+	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), s.dbQueries, req.Name, groupStates)
 	if err != nil {
 		log.Println(err)
 		tx.Rollback()
@@ -405,10 +434,16 @@ func (s *Server) registerSatelliteHandler(w http.ResponseWriter, r *http.Request
 	WriteJSONResponse(w, http.StatusOK, tk)
 }
 
+// ztrHandler registers the satellite with the token in the path. Deprecated: the token ends up in access logs and
+// no client certificate is issued, satellites post their token and certificate request to /satellites/ztr instead.
 func (s *Server) ztrHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	token := vars["token"]
+	s.zeroTouchRegistration(w, r, vars["token"], "")
+}
 
+// zeroTouchRegistration exchanges the one time token for the state and robot account of the satellite, and for a
+// client certificate if the satellite sent a certificate request and a satellite CA is configured
+func (s *Server) zeroTouchRegistration(w http.ResponseWriter, r *http.Request, token, csr string) {
 	// Start a new transaction
 	tx, err := s.db.BeginTx(r.Context(), nil)
 	if err != nil {
@@ -501,9 +536,25 @@ func (s *Server) ztrHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	satellite, err := q.GetSatellite(r.Context(), satelliteID)
+	if err != nil {
+		log.Printf("failed to get satellite: %v, %v", satelliteID, err)
+		HandleAppError(w, err)
+		tx.Rollback()
+		return
+	}
+
+	var certificate models.SatelliteCertificate
+	if csr != "" && s.ca != nil {
+		certificate, err = s.issueSatelliteCertificate(r.Context(), q, satellite, csr)
+		if err != nil {
+			HandleAppError(w, err)
+			tx.Rollback()
+			return
+		}
+	}
 
 	// For sanity, create (update) the state artifact during the registration process as well.
-	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), satellite.Name, states)
+	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), s.dbQueries, satellite.Name, states)
 	if err != nil {
 		log.Println(err)
 		tx.Rollback()
@@ -517,47 +568,135 @@ func (s *Server) ztrHandler(w http.ResponseWriter, r *http.Request) {
 	result := models.ZtrResult{
 		State: satelliteState,
 		Auth: models.Account{
-			Name:     robot.RobotName,
-			Secret:   robot.RobotSecret,
+			Name: robot.RobotName,
+			// A staged secret is only delivered by the sync endpoint, the satellite starts with the one Harbor accepts
+			Secret:   harborSecret(robot),
 			Registry: os.Getenv("HARBOR_URL"),
 		},
+		Certificate: certificate.Certificate,
 	}
 
 	tx.Commit()
 	WriteJSONResponse(w, http.StatusOK, result)
 }
 
-func (s *Server) listSatelliteHandler(w http.ResponseWriter, r *http.Request) {
-	result, err := s.dbQueries.ListSatellites(r.Context())
+// syncHandler returns the current group states and robot credentials of the satellite
+// authenticating with its robot account, so that satellites can pick up group membership
+// changes after the zero touch registration is done.
+func (s *Server) syncHandler(w http.ResponseWriter, r *http.Request) {
+	robot, err := s.authenticateSatellite(r)
+	if err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+
+	groups, err := s.dbQueries.SatelliteGroupList(r.Context(), robot.SatelliteID)
 	if err != nil {
-		log.Printf("Error: Failed to List Satellites: %v", err)
+		log.Printf("failed to list groups for satellite: %v, %v", robot.SatelliteID, err)
 		err := &AppError{
-			Message: "Error: Failed to List Satellites",
+			Message: "Error: Satellite Groups List Failed",
 			Code:    http.StatusInternalServerError,
 		}
 		HandleAppError(w, err)
 		return
 	}
 
+	states := []string{}
+	for _, group := range groups {
+		grp, err := s.dbQueries.GetGroupByID(r.Context(), group.GroupID)
+		if err != nil {
+			log.Printf("failed to get group by ID: %v, %v", group.GroupID, err)
+			err := &AppError{
+				Message: "Error: Get Group By ID Failed",
+				Code:    http.StatusInternalServerError,
+			}
+			HandleAppError(w, err)
+			return
+		}
+		states = append(states, utils.AssembleGroupState(grp.GroupName))
+	}
+
+	result := models.SyncResult{
+		States: states,
+		Auth: models.Account{
+			Name:     robot.RobotName,
+			Secret:   robot.RobotSecret,
+			Registry: os.Getenv("HARBOR_URL"),
+		},
+	}
+
 	WriteJSONResponse(w, http.StatusOK, result)
 }
 
-func (s *Server) GetSatelliteByName(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	satellite := vars["satellite"]
+// authenticateSatellite validates the client certificate or, for the satellites never issued one, the robot
+// account credentials sent by the satellite using basic auth and returns the robot account on success.
+func (s *Server) authenticateSatellite(r *http.Request) (database.RobotAccount, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return s.authenticateSatelliteCertificate(r)
+	}
 
-	result, err := s.dbQueries.GetSatelliteByName(r.Context(), satellite)
+	name, secret, ok := r.BasicAuth()
+	if !ok {
+		return database.RobotAccount{}, &AppError{
+			Message: "Authorization header missing",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+
+	robot, err := s.dbQueries.GetRobotAccByName(r.Context(), name)
 	if err != nil {
-		log.Printf("error: failed to get satellite: %v", err)
-		err := &AppError{
-			Message: "Error: Failed to Get Satellite",
+		log.Printf("robot account not found: %v", err)
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Invalid Credentials",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+
+	if !robotSecretMatches(robot, secret) {
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Invalid Credentials",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+
+	// Once issued a certificate the satellite must present it while it is valid. Past its expiry the robot account
+	// is accepted again so that a satellite offline for longer than the validity can get a new certificate.
+	// Revoking the certificates locks the satellite out until it is registered again.
+	latest, err := s.dbQueries.GetLatestSatelliteCertificate(r.Context(), robot.SatelliteID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("failed to look up certificates of satellite %v: %v", robot.SatelliteID, err)
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Failed to Authenticate",
 			Code:    http.StatusInternalServerError,
 		}
-		HandleAppError(w, err)
-		return
+	}
+	if err == nil && latest.RevokedAt.Valid {
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Certificate Revoked",
+			Code:    http.StatusUnauthorized,
+		}
+	}
+	certificates, err := s.dbQueries.CountSatelliteCertificates(r.Context(), database.CountSatelliteCertificatesParams{
+		SatelliteID: robot.SatelliteID,
+		// Stored in UTC as the column has no time zone
+		NotAfter: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("failed to count certificates of satellite %v: %v", robot.SatelliteID, err)
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Failed to Authenticate",
+			Code:    http.StatusInternalServerError,
+		}
+	}
+	if certificates > 0 {
+		return database.RobotAccount{}, &AppError{
+			Message: "Error: Client Certificate Required",
+			Code:    http.StatusUnauthorized,
+		}
 	}
 
-	WriteJSONResponse(w, http.StatusOK, result)
+	return robot, nil
 }
 
 // The state artifact corresponding to the satellite must be deleted.
@@ -726,7 +865,7 @@ func (s *Server) addSatelliteToGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the state artifact to also track the new group state artifact
-	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), sat.Name, groupStates)
+	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), s.dbQueries, sat.Name, groupStates)
 	if err != nil {
 		log.Println(err)
 		HandleAppError(w, err)
@@ -836,7 +975,7 @@ func (s *Server) removeSatelliteFromGroup(w http.ResponseWriter, r *http.Request
 	}
 
 	// Update the state artifact to also track the new group state artifact
-	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), sat.Name, groupStates)
+	err = utils.CreateOrUpdateSatStateArtifact(r.Context(), s.dbQueries, sat.Name, groupStates)
 	if err != nil {
 		log.Println(err)
 		HandleAppError(w, err)
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultRobotSecretGracePeriod = time.Hour
+	// robotSecretCheckInterval is how often the robot accounts are checked for secrets to rotate or apply
+	robotSecretCheckInterval = time.Minute
+)
+
+var errRotationInProgress = errors.New("a rotated secret is already staged")
+
+// robotSecretMatches returns true if the secret is the current secret of the robot account or, while a rotated
+// secret is staged, the previous one, so that the satellites not synced yet can fetch the new secret
+func robotSecretMatches(robot database.RobotAccount, secret string) bool {
+	if subtle.ConstantTimeCompare([]byte(robot.RobotSecret), []byte(secret)) == 1 {
+		return true
+	}
+	return robot.SecretCutoverAt.Valid && robot.PreviousSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(robot.PreviousSecret), []byte(secret)) == 1
+}
+
+// harborSecret returns the secret Harbor accepts for the robot account, the previous one until the staged secret
+// is applied
+func harborSecret(robot database.RobotAccount) string {
+	if robot.SecretCutoverAt.Valid && robot.PreviousSecret != "" {
+		return robot.PreviousSecret
+	}
+	return robot.RobotSecret
+}
+
+// robotRotationDue returns true if the secret of the robot account is older than the rotation interval, a zero
+// interval disables the scheduled rotation
+func robotRotationDue(robot database.RobotAccount, now time.Time, interval time.Duration) bool {
+	return interval > 0 && !robot.SecretCutoverAt.Valid && now.Sub(robot.SecretRotatedAt) >= interval
+}
+
+// robotCutoverDue returns true once the grace period of the staged secret is over
+func robotCutoverDue(robot database.RobotAccount, now time.Time) bool {
+	return robot.SecretCutoverAt.Valid && !now.Before(robot.SecretCutoverAt.Time)
+}
+
+// rotateRobotSecrets periodically stages new secrets for the robot accounts due for rotation and applies the
+// staged secrets to Harbor once their grace period is over. The satellites fetch the staged secret from the
+// sync endpoint and keep using the previous one until Harbor accepts the new one.
+func (s *Server) rotateRobotSecrets(ctx context.Context) {
+	ticker := time.NewTicker(robotSecretCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			robots, err := s.dbQueries.ListRobotAccounts(ctx)
+			if err != nil {
+				log.Printf("Error listing robot accounts to rotate their secrets: %v", err)
+				continue
+			}
+			// Stored in UTC as the columns have no time zone
+			now := time.Now().UTC()
+			for _, robot := range robots {
+				switch {
+				case robotCutoverDue(robot, now):
+					if err := s.applyRobotSecret(ctx, robot); err != nil {
+						log.Printf("Error applying the rotated secret of robot account %s: %v", robot.RobotName, err)
+					}
+				case robotRotationDue(robot, now, s.robotSecretRotationInterval):
+					if err := s.stageRobotSecret(ctx, robot, now); err != nil {
+						log.Printf("Error rotating the secret of robot account %s: %v", robot.RobotName, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// stageRobotSecret generates a new secret for the robot account, delivered to the satellite right away and
+// applied to Harbor after the grace period
+func (s *Server) stageRobotSecret(ctx context.Context, robot database.RobotAccount, now time.Time) error {
+	secret, err := utils.GenerateRobotSecret()
+	if err != nil {
+		return err
+	}
+	cutoverAt := now.Add(s.robotSecretGracePeriod)
+	staged, err := s.dbQueries.StageRobotSecret(ctx, database.StageRobotSecretParams{
+		ID:              robot.ID,
+		RobotSecret:     secret,
+		SecretCutoverAt: sql.NullTime{Time: cutoverAt, Valid: true},
+		SecretRotatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	if staged == 0 {
+		return errRotationInProgress
+	}
+	log.Printf("Rotated the secret of robot account %s, the previous secret is accepted until %s", robot.RobotName, cutoverAt)
+	return nil
+}
+
+// applyRobotSecret sets the staged secret in Harbor, the previous secret is rejected from then on
+func (s *Server) applyRobotSecret(ctx context.Context, robot database.RobotAccount) error {
+	if err := utils.RefreshRobotSecret(ctx, robot.RobotID, robot.RobotSecret); err != nil {
+		return err
+	}
+	if err := s.dbQueries.ApplyRobotSecret(ctx, robot.ID); err != nil {
+		return err
+	}
+	log.Printf("Applied the rotated secret of robot account %s to Harbor", robot.RobotName)
+	return nil
+}
+
+// rotateRobotSecretHandler rotates the robot secret of the satellite on demand
+func (s *Server) rotateRobotSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["satellite"]
+	satellite, err := s.dbQueries.GetSatelliteByName(r.Context(), name)
+	if err != nil {
+		log.Printf("error: failed to get satellite %s: %v", name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Satellite Not Found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	robot, err := s.dbQueries.GetRobotAccBySatelliteID(r.Context(), satellite.ID)
+	if err != nil {
+		log.Printf("error: robot account not found for satellite %s: %v", name, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Robot Account Not Found for Satellite",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	err = s.stageRobotSecret(r.Context(), robot, now)
+	if errors.Is(err, errRotationInProgress) {
+		HandleAppError(w, &AppError{
+			Message: "Error: Rotation Already in Progress",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("failed to rotate the secret of robot account %s: %v", robot.RobotName, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Rotate Robot Secret",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	caller, _ := CallerFromContext(r.Context())
+	log.Printf("robot secret of satellite %s rotated by %s", name, caller.Name)
+	WriteJSONResponse(w, http.StatusOK, map[string]time.Time{"cutover_at": now.Add(s.robotSecretGracePeriod)})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+)
+
+func TestRobotSecretRotation(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	applied := database.RobotAccount{RobotSecret: "current", SecretRotatedAt: now.Add(-48 * time.Hour)}
+	staged := database.RobotAccount{
+		RobotSecret:     "next",
+		PreviousSecret:  "current",
+		SecretCutoverAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+		SecretRotatedAt: now,
+	}
+
+	if !robotSecretMatches(applied, "current") || robotSecretMatches(applied, "next") {
+		t.Error("only the current secret should match once applied")
+	}
+	if !robotSecretMatches(staged, "current") || !robotSecretMatches(staged, "next") {
+		t.Error("both secrets should match while the new one is staged")
+	}
+	if got := harborSecret(staged); got != "current" {
+		t.Errorf("harbor secret = %q, want the previous secret while staged", got)
+	}
+	if got := harborSecret(applied); got != "current" {
+		t.Errorf("harbor secret = %q, want %q", got, "current")
+	}
+
+	if !robotRotationDue(applied, now, 24*time.Hour) {
+		t.Error("a secret older than the interval should be rotated")
+	}
+	if robotRotationDue(applied, now, 0) {
+		t.Error("a zero interval should disable the rotation")
+	}
+	if robotRotationDue(staged, now.Add(48*time.Hour), 24*time.Hour) {
+		t.Error("a staged secret should not be rotated again")
+	}
+	if robotCutoverDue(staged, now) || !robotCutoverDue(staged, now.Add(time.Hour)) {
+		t.Error("the staged secret should be applied once the grace period is over")
+	}
+	if robotCutoverDue(applied, now) {
+		t.Error("an applied secret has no cutover")
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/models"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultRolloutPageSize = 50
+	maxRolloutPageSize     = 500
+)
+
+// Rollout status of the latest state of a group on a satellite
+const (
+	RolloutUpToDate = "up_to_date"
+	RolloutBehind   = "behind"
+	RolloutFailed   = "failed"
+	RolloutPending  = "pending"
+)
+
+// rolloutFilter selects the satellites returned by the rollout endpoint, an empty set matches everything
+type rolloutFilter struct {
+	statuses       map[string]bool
+	connectivities map[string]bool
+	page           int
+	pageSize       int
+}
+
+// parseRolloutFilter reads the comma separated status and connectivity filters and the page from the query
+func parseRolloutFilter(r *http.Request) (rolloutFilter, error) {
+	query := r.URL.Query()
+	filter := rolloutFilter{page: 1, pageSize: defaultRolloutPageSize}
+	var err error
+	filter.statuses, err = parseSet(query.Get("status"), RolloutUpToDate, RolloutBehind, RolloutFailed, RolloutPending)
+	if err != nil {
+		return filter, fmt.Errorf("invalid status filter: %w", err)
+	}
+	filter.connectivities, err = parseSet(query.Get("connectivity"), SatelliteOnline, SatelliteStale, SatelliteOffline)
+	if err != nil {
+		return filter, fmt.Errorf("invalid connectivity filter: %w", err)
+	}
+	if value := query.Get("page"); value != "" {
+		if filter.page, err = strconv.Atoi(value); err != nil || filter.page < 1 {
+			return filter, fmt.Errorf("invalid page %q", value)
+		}
+	}
+	if value := query.Get("page_size"); value != "" {
+		if filter.pageSize, err = strconv.Atoi(value); err != nil || filter.pageSize < 1 || filter.pageSize > maxRolloutPageSize {
+			return filter, fmt.Errorf("invalid page_size %q, must be between 1 and %d", value, maxRolloutPageSize)
+		}
+	}
+	return filter, nil
+}
+
+func parseSet(value string, allowed ...string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	if value == "" {
+		return set, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		valid := false
+		for _, a := range allowed {
+			if item == a {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%q is not one of %s", item, strings.Join(allowed, ", "))
+		}
+		set[item] = true
+	}
+	return set, nil
+}
+
+func (f rolloutFilter) matches(satellite models.SatelliteRollout) bool {
+	return (len(f.statuses) == 0 || f.statuses[satellite.Status]) &&
+		(len(f.connectivities) == 0 || f.connectivities[satellite.Connectivity])
+}
+
+// rolloutStatus compares the state applied by the satellite to the latest state of the group, state is nil if
+// the satellite never reported applying the state of the group
+func rolloutStatus(state *database.SatelliteStateStatus, latestVersion int64, latestDigest string) string {
+	switch {
+	case state == nil:
+		return RolloutPending
+	case state.LastSync.Valid && !state.SyncSuccess:
+		return RolloutFailed
+	case state.Digest != "" && state.Digest == latestDigest:
+		return RolloutUpToDate
+	case state.Digest == "" && state.Version >= latestVersion:
+		return RolloutUpToDate
+	default:
+		return RolloutBehind
+	}
+}
+
+// artifactKey identifies an artifact of a state by its repository, digest and tags
+func artifactKey(artifact models.Artifact) string {
+	tags := append([]string(nil), artifact.Tag...)
+	sort.Strings(tags)
+	return fmt.Sprintf("%s@%s:%s", artifact.Repository, artifact.Digest, strings.Join(tags, ","))
+}
+
+// artifactsBehind returns the number of artifacts of the latest state missing from the applied state
+func artifactsBehind(latest, applied []models.Artifact) int {
+	appliedKeys := make(map[string]bool, len(applied))
+	for _, artifact := range applied {
+		appliedKeys[artifactKey(artifact)] = true
+	}
+	behind := 0
+	for _, artifact := range latest {
+		if !appliedKeys[artifactKey(artifact)] {
+			behind++
+		}
+	}
+	return behind
+}
+
+// groupRolloutHandler returns which member satellites of the group applied its latest state, which are behind
+// and by how many artifacts, and which failed to apply it
+func (s *Server) groupRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	filter, err := parseRolloutFilter(r)
+	if err != nil {
+		HandleAppError(w, &AppError{
+			Message: fmt.Sprintf("Error: %v", err),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	grp, err := s.dbQueries.GetGroupByName(r.Context(), group)
+	if err != nil {
+		log.Printf("error: failed to get group %s: %v", group, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Group Not Found",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	latest, latestDigest, err := utils.PullStateArtifact(r.Context(), utils.AssembleGroupState(group))
+	if err != nil {
+		log.Printf("error: failed to fetch the state of group %s: %v", group, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Fetch Group State",
+			Code:    http.StatusBadGateway,
+		})
+		return
+	}
+
+	members, err := s.dbQueries.GroupSatelliteList(r.Context(), grp.ID)
+	if err != nil {
+		log.Printf("error: failed to list satellites of group %s: %v", group, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to List Group Satellites",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	satellites, err := s.dbQueries.ListSatellites(r.Context())
+	if err != nil {
+		log.Printf("error: failed to list satellites: %v", err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to List Group Satellites",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	statuses, err := s.dbQueries.ListSatelliteStatuses(r.Context())
+	if err != nil {
+		log.Printf("error: failed to list satellite statuses: %v", err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to List Group Satellites",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	states, err := s.dbQueries.ListSatelliteStateStatusesByURL(r.Context(), utils.AssembleGroupState(group))
+	if err != nil {
+		log.Printf("error: failed to list state statuses of group %s: %v", group, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to List Group Satellites",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	names := make(map[int32]string, len(satellites))
+	for _, satellite := range satellites {
+		names[satellite.ID] = satellite.Name
+	}
+	heartbeats := make(map[int32]time.Time, len(statuses))
+	for _, status := range statuses {
+		heartbeats[status.SatelliteID] = status.LastHeartbeat
+	}
+	applied := make(map[int32]database.SatelliteStateStatus, len(states))
+	for _, state := range states {
+		applied[state.SatelliteID] = state
+	}
+
+	result := models.GroupRollout{
+		Group:      group,
+		Version:    latest.Version,
+		Digest:     latestDigest,
+		Artifacts:  len(latest.Artifacts),
+		Page:       filter.page,
+		PageSize:   filter.pageSize,
+		Satellites: []models.SatelliteRollout{},
+	}
+	now := time.Now()
+	var matched []models.SatelliteRollout
+	for _, member := range members {
+		rollout := models.SatelliteRollout{Name: names[member.SatelliteID]}
+		if lastHeartbeat, ok := heartbeats[member.SatelliteID]; ok {
+			rollout.LastHeartbeat = &lastHeartbeat
+		}
+		rollout.Connectivity = satelliteStatus(rollout.LastHeartbeat, now, s.heartbeatStaleAfter, s.heartbeatOfflineAfter)
+		var state *database.SatelliteStateStatus
+		if st, ok := applied[member.SatelliteID]; ok {
+			state = &st
+			rollout.Version = st.Version
+			rollout.Digest = st.Digest
+			if st.LastSync.Valid {
+				rollout.LastSync = &models.HeartbeatSync{
+					Time:    st.LastSync.Time,
+					Success: st.SyncSuccess,
+					Failed:  int(st.FailedEntities),
+					Error:   st.SyncError,
+				}
+			}
+		}
+		rollout.Status = rolloutStatus(state, latest.Version, latestDigest)
+
+		result.Summary.Total++
+		switch rollout.Status {
+		case RolloutUpToDate:
+			result.Summary.UpToDate++
+		case RolloutBehind:
+			result.Summary.Behind++
+		case RolloutFailed:
+			result.Summary.Failed++
+		case RolloutPending:
+			result.Summary.Pending++
+		}
+		if filter.matches(rollout) {
+			matched = append(matched, rollout)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	result.Total = len(matched)
+
+	start := min((filter.page-1)*filter.pageSize, len(matched))
+	end := min(start+filter.pageSize, len(matched))
+	// The states applied by the satellites of the page are pulled once per digest to count the missing artifacts
+	appliedStates := make(map[string]*models.StateArtifact)
+	for _, rollout := range matched[start:end] {
+		switch {
+		case rollout.Digest == latestDigest:
+			behind := 0
+			rollout.ArtifactsBehind = &behind
+		case rollout.Digest != "":
+			state, ok := appliedStates[rollout.Digest]
+			if !ok {
+				state, _, err = utils.PullStateArtifact(r.Context(), utils.GroupStateAtDigest(group, rollout.Digest))
+				if err != nil {
+					log.Printf("failed to fetch the state %s applied by satellite %s: %v", rollout.Digest, rollout.Name, err)
+				}
+				appliedStates[rollout.Digest] = state
+			}
+			if state != nil {
+				behind := artifactsBehind(latest.Artifacts, state.Artifacts)
+				rollout.ArtifactsBehind = &behind
+			}
+		}
+		result.Satellites = append(result.Satellites, rollout)
+	}
+
+	WriteJSONResponse(w, http.StatusOK, result)
+}
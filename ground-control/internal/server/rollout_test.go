@@ -0,0 +1,70 @@
+package server
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	m "github.com/container-registry/harbor-satellite/ground-control/internal/models"
+)
+
+func TestRolloutStatus(t *testing.T) {
+	synced := sql.NullTime{Time: time.Now(), Valid: true}
+	tests := []struct {
+		name  string
+		state *database.SatelliteStateStatus
+		want  string
+	}{
+		{name: "never reported", state: nil, want: RolloutPending},
+		{name: "latest digest", state: &database.SatelliteStateStatus{Digest: "sha256:b", Version: 2, LastSync: synced, SyncSuccess: true}, want: RolloutUpToDate},
+		{name: "older digest", state: &database.SatelliteStateStatus{Digest: "sha256:a", Version: 1, LastSync: synced, SyncSuccess: true}, want: RolloutBehind},
+		{name: "no digest", state: &database.SatelliteStateStatus{Version: 2}, want: RolloutUpToDate},
+		{name: "failed sync", state: &database.SatelliteStateStatus{Digest: "sha256:b", Version: 2, LastSync: synced, SyncError: "pull failed"}, want: RolloutFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rolloutStatus(tt.state, 2, "sha256:b"); got != tt.want {
+				t.Errorf("rolloutStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArtifactsBehind(t *testing.T) {
+	applied := []m.Artifact{
+		{Repository: "library/alpine", Tag: []string{"3.19"}, Digest: "sha256:a"},
+		{Repository: "library/nginx", Tag: []string{"1.25", "latest"}, Digest: "sha256:b"},
+	}
+	latest := []m.Artifact{
+		{Repository: "library/alpine", Tag: []string{"3.20"}, Digest: "sha256:c"},
+		{Repository: "library/nginx", Tag: []string{"latest", "1.25"}, Digest: "sha256:b"},
+		{Repository: "library/redis", Tag: []string{"7"}, Digest: "sha256:d"},
+	}
+	if got := artifactsBehind(latest, applied); got != 2 {
+		t.Errorf("artifactsBehind() = %d, want 2", got)
+	}
+}
+
+func TestParseRolloutFilter(t *testing.T) {
+	filter, err := parseRolloutFilter(httptest.NewRequest("GET", "/groups/edge/rollout?status=behind,failed&connectivity=online&page=2&page_size=10", nil))
+	if err != nil {
+		t.Fatalf("parseRolloutFilter() error = %v", err)
+	}
+	if filter.page != 2 || filter.pageSize != 10 {
+		t.Errorf("page = %d, page_size = %d, want 2 and 10", filter.page, filter.pageSize)
+	}
+	if !filter.matches(m.SatelliteRollout{Status: RolloutFailed, Connectivity: SatelliteOnline}) {
+		t.Error("expected a failed online satellite to match")
+	}
+	if filter.matches(m.SatelliteRollout{Status: RolloutUpToDate, Connectivity: SatelliteOnline}) {
+		t.Error("expected an up to date satellite not to match")
+	}
+
+	for _, query := range []string{"status=done", "page=0", "page_size=1000"} {
+		if _, err := parseRolloutFilter(httptest.NewRequest("GET", "/groups/edge/rollout?"+query, nil)); err == nil {
+			t.Errorf("expected %s to be rejected", query)
+		}
+	}
+}
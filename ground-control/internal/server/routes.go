@@ -8,28 +8,46 @@ import (
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := mux.NewRouter()
+	// authorized requires an API token granting at least the role, the satellite endpoints authenticate the
+	// satellites with their ZTR token or robot account instead
+	authorized := func(role string, handler http.HandlerFunc) http.Handler {
+		return s.requireRole(role)(handler)
+	}
 
 	r.HandleFunc("/ping", s.Ping).Methods("GET")
 	r.HandleFunc("/health", s.healthHandler).Methods("GET")
 
 	// Ground Control interface
-	r.HandleFunc("/groups/sync", s.groupsSyncHandler).Methods("POST")
-	r.HandleFunc("/groups/list", s.listGroupHandler).Methods("GET")
-	r.HandleFunc("/groups/{group}", s.getGroupHandler).Methods("GET")
-	r.HandleFunc("/groups/satellite", s.addSatelliteToGroup).Methods("POST")
-	r.HandleFunc("/groups/satellite", s.removeSatelliteFromGroup).Methods("DELETE")
+	r.Handle("/groups/sync", authorized(RoleGroupEditor, s.groupsSyncHandler)).Methods("POST")
+	r.Handle("/groups/list", authorized(RoleReadOnly, s.listGroupHandler)).Methods("GET")
+	r.Handle("/groups/{group}", authorized(RoleReadOnly, s.getGroupHandler)).Methods("GET")
+	r.Handle("/groups/{group}/rollout", authorized(RoleReadOnly, s.groupRolloutHandler)).Methods("GET")
+	r.Handle("/groups/satellite", authorized(RoleGroupEditor, s.addSatelliteToGroup)).Methods("POST")
+	r.Handle("/groups/satellite", authorized(RoleGroupEditor, s.removeSatelliteFromGroup)).Methods("DELETE")
 
 	// to-do: listing functionality to list satellites attached to group
 	// for ground control admins
 	// r.HandleFunc("/groups/{group}/list", s.groupSatelliteHandler).Methods("GET")
 
 	// Ground Control interface
-	r.HandleFunc("/satellites/register", s.registerSatelliteHandler).Methods("POST")
+	r.Handle("/satellites/register", authorized(RoleAdmin, s.registerSatelliteHandler)).Methods("POST")
+	r.HandleFunc("/satellites/ztr", s.ztrCertificateHandler).Methods("POST")
+	// Deprecated: the token is sent in the path and no client certificate is issued
 	r.HandleFunc("/satellites/ztr/{token}", s.ztrHandler).Methods("GET")
-	r.HandleFunc("/satellites/list", s.listSatelliteHandler).Methods("GET")
-	r.HandleFunc("/satellites/{satellite}", s.GetSatelliteByName).Methods("GET")
-	r.HandleFunc("/satellites/{satellite}", s.DeleteSatelliteByName).Methods("DELETE")
+	r.HandleFunc("/satellites/certificate", s.renewCertificateHandler).Methods("POST")
+	r.HandleFunc("/satellites/sync", s.syncHandler).Methods("GET")
+	r.HandleFunc("/satellites/heartbeat", s.heartbeatHandler).Methods("POST")
+	r.Handle("/satellites/list", authorized(RoleReadOnly, s.listSatelliteHandler)).Methods("GET")
+	r.Handle("/satellites/{satellite}", authorized(RoleReadOnly, s.GetSatelliteByName)).Methods("GET")
+	r.Handle("/satellites/{satellite}", authorized(RoleAdmin, s.DeleteSatelliteByName)).Methods("DELETE")
+	r.Handle("/satellites/{satellite}/certificate/revoke", authorized(RoleAdmin, s.revokeCertificatesHandler)).Methods("POST")
+	r.Handle("/satellites/{satellite}/robot/rotate", authorized(RoleAdmin, s.rotateRobotSecretHandler)).Methods("POST")
 	// r.HandleFunc("/satellites/{satellite}/images", s.GetImagesForSatellite).Methods("GET")
 
+	// API tokens
+	r.Handle("/tokens", authorized(RoleAdmin, s.createAPITokenHandler)).Methods("POST")
+	r.Handle("/tokens/list", authorized(RoleAdmin, s.listAPITokensHandler)).Methods("GET")
+	r.Handle("/tokens/{token}", authorized(RoleAdmin, s.deleteAPITokenHandler)).Methods("DELETE")
+
 	return r
 }
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
 	"log"
@@ -13,12 +15,25 @@ import (
 	_ "github.com/lib/pq"
 
 	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/utils"
 )
 
 type Server struct {
 	port      int
 	db        *sql.DB
 	dbQueries *database.Queries
+	// A satellite is stale once its last heartbeat is older than heartbeatStaleAfter and offline past heartbeatOfflineAfter
+	heartbeatStaleAfter   time.Duration
+	heartbeatOfflineAfter time.Duration
+	// adminToken is the bootstrap token from ADMIN_TOKEN granting the admin role, used to create the API tokens
+	adminToken string
+	// ca issues the client certificates of the satellites, nil if SATELLITE_CA_CERT and SATELLITE_CA_KEY are unset
+	ca                    *utils.SatelliteCA
+	satelliteCertValidity time.Duration
+	// The robot secrets are rotated every robotSecretRotationInterval, zero disables it, and the previous secret
+	// stays valid for robotSecretGracePeriod
+	robotSecretRotationInterval time.Duration
+	robotSecretGracePeriod      time.Duration
 }
 
 var (
@@ -29,7 +44,8 @@ var (
 	HOST     = os.Getenv("DB_HOST")
 )
 
-func NewServer() *http.Server {
+// NewServer creates the Ground Control server, the background tasks it starts stop once ctx is done
+func NewServer(ctx context.Context) *http.Server {
 	port, err := strconv.Atoi(os.Getenv("PORT"))
 	if err != nil {
 		log.Fatalf("PORT is not valid: %v", err)
@@ -51,10 +67,38 @@ func NewServer() *http.Server {
 
 	dbQueries := database.New(db)
 
+	ca, err := utils.LoadSatelliteCA()
+	if err != nil {
+		log.Fatalf("Error loading satellite CA: %v", err)
+	}
+	// Satellites issued a certificate can only authenticate with it, which requires Ground Control to serve TLS
+	if ca != nil && (os.Getenv("TLS_CERT_FILE") == "" || os.Getenv("TLS_KEY_FILE") == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set to issue certificates to the satellites")
+	}
+
 	NewServer := &Server{
 		port:      port,
 		db:        db,
 		dbQueries: dbQueries,
+
+		heartbeatStaleAfter:   durationFromEnv("HEARTBEAT_STALE_AFTER", defaultHeartbeatStaleAfter),
+		heartbeatOfflineAfter: durationFromEnv("HEARTBEAT_OFFLINE_AFTER", defaultHeartbeatOfflineAfter),
+		adminToken:            os.Getenv("ADMIN_TOKEN"),
+		ca:                    ca,
+		satelliteCertValidity: durationFromEnv("SATELLITE_CERT_VALIDITY", defaultSatelliteCertValidity),
+
+		robotSecretRotationInterval: durationFromEnv("ROBOT_SECRET_ROTATION_INTERVAL", 0),
+		robotSecretGracePeriod:      durationFromEnv("ROBOT_SECRET_GRACE_PERIOD", defaultRobotSecretGracePeriod),
+	}
+
+	if NewServer.adminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, only the API tokens already created can access the API")
+	}
+
+	go NewServer.rotateRobotSecrets(ctx)
+
+	if interval := tagFilterRefreshInterval(); interval > 0 {
+		go NewServer.refreshTagFilters(ctx, interval)
 	}
 
 	// Declare Server config
@@ -65,6 +109,15 @@ func NewServer() *http.Server {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	// The satellites issued a certificate authenticate with it, the other clients are not asked for one
+	if ca != nil {
+		server.TLSConfig = &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  ca.Pool(),
+		}
+	} else {
+		log.Println("SATELLITE_CA_CERT and SATELLITE_CA_KEY are not set, satellites authenticate with their robot account")
+	}
 
 	return server
 }
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+	"github.com/container-registry/harbor-satellite/ground-control/internal/models"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHeartbeatStaleAfter   = 2 * time.Minute
+	defaultHeartbeatOfflineAfter = 10 * time.Minute
+)
+
+// Status of a satellite, derived from the time since its last heartbeat
+const (
+	SatelliteOnline  = "online"
+	SatelliteStale   = "stale"
+	SatelliteOffline = "offline"
+)
+
+// durationFromEnv reads a duration from the environment variable, falling back to the default if unset or invalid
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// satelliteStatus returns online while the satellite sent a heartbeat within staleAfter, stale until
+// offlineAfter and offline past it or if it never sent any
+func satelliteStatus(lastHeartbeat *time.Time, now time.Time, staleAfter, offlineAfter time.Duration) string {
+	if lastHeartbeat == nil {
+		return SatelliteOffline
+	}
+	switch since := now.Sub(*lastHeartbeat); {
+	case since <= staleAfter:
+		return SatelliteOnline
+	case since <= offlineAfter:
+		return SatelliteStale
+	default:
+		return SatelliteOffline
+	}
+}
+
+// heartbeatHandler stores the status reported by the satellite authenticating with its robot account
+func (s *Server) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	robot, err := s.authenticateSatellite(r)
+	if err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+
+	var heartbeat models.Heartbeat
+	if err := DecodeRequestBody(r, &heartbeat); err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+
+	tx, err := s.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Println(err)
+		HandleAppError(w, err)
+		return
+	}
+	q := s.dbQueries.WithTx(tx)
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+		} else if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = q.UpsertSatelliteStatus(r.Context(), database.UpsertSatelliteStatusParams{
+		SatelliteID:    robot.SatelliteID,
+		Version:        heartbeat.Version,
+		GitCommit:      heartbeat.GitCommit,
+		System:         heartbeat.System,
+		UptimeSeconds:  heartbeat.UptimeSeconds,
+		LastError:      heartbeat.LastError,
+		DiskTotalBytes: heartbeat.DiskTotalBytes,
+		DiskFreeBytes:  heartbeat.DiskFreeBytes,
+		// Stored in UTC as the column has no time zone
+		LastHeartbeat: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("failed to store heartbeat of satellite %v: %v", robot.SatelliteID, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Store Heartbeat",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	// The heartbeat lists all the states applied, the states the satellite no longer applies are dropped
+	if err = q.DeleteSatelliteStateStatuses(r.Context(), robot.SatelliteID); err != nil {
+		log.Printf("failed to clear state statuses of satellite %v: %v", robot.SatelliteID, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Store Heartbeat",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	for _, state := range heartbeat.States {
+		params := database.AddSatelliteStateStatusParams{
+			SatelliteID: robot.SatelliteID,
+			StateUrl:    state.URL,
+			Version:     state.Version,
+			Digest:      state.Digest,
+		}
+		if state.LastSync != nil {
+			params.LastSync = sql.NullTime{Time: state.LastSync.Time, Valid: true}
+			params.SyncSuccess = state.LastSync.Success
+			params.SyncError = state.LastSync.Error
+			params.FailedEntities = int32(state.LastSync.Failed)
+		}
+		if err = q.AddSatelliteStateStatus(r.Context(), params); err != nil {
+			log.Printf("failed to store state status of satellite %v: %v", robot.SatelliteID, err)
+			HandleAppError(w, &AppError{
+				Message: "Error: Failed to Store Heartbeat",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("failed to commit heartbeat of satellite %v: %v", robot.SatelliteID, err)
+		HandleAppError(w, &AppError{
+			Message: "Error: Failed to Store Heartbeat",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSatelliteHandler(w http.ResponseWriter, r *http.Request) {
+	satellites, err := s.dbQueries.ListSatellites(r.Context())
+	if err != nil {
+		log.Printf("Error: Failed to List Satellites: %v", err)
+		err := &AppError{
+			Message: "Error: Failed to List Satellites",
+			Code:    http.StatusInternalServerError,
+		}
+		HandleAppError(w, err)
+		return
+	}
+	statuses, err := s.dbQueries.ListSatelliteStatuses(r.Context())
+	if err != nil {
+		log.Printf("Error: Failed to List Satellite Statuses: %v", err)
+		err := &AppError{
+			Message: "Error: Failed to List Satellites",
+			Code:    http.StatusInternalServerError,
+		}
+		HandleAppError(w, err)
+		return
+	}
+	byID := make(map[int32]database.SatelliteStatus, len(statuses))
+	for _, status := range statuses {
+		byID[status.SatelliteID] = status
+	}
+
+	now := time.Now()
+	result := make([]models.SatelliteInfo, 0, len(satellites))
+	for _, satellite := range satellites {
+		var status *database.SatelliteStatus
+		if st, ok := byID[satellite.ID]; ok {
+			status = &st
+		}
+		result = append(result, s.satelliteInfo(satellite, status, nil, now))
+	}
+
+	WriteJSONResponse(w, http.StatusOK, result)
+}
+
+func (s *Server) GetSatelliteByName(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["satellite"]
+
+	satellite, err := s.dbQueries.GetSatelliteByName(r.Context(), name)
+	if err != nil {
+		log.Printf("error: failed to get satellite: %v", err)
+		err := &AppError{
+			Message: "Error: Failed to Get Satellite",
+			Code:    http.StatusInternalServerError,
+		}
+		HandleAppError(w, err)
+		return
+	}
+
+	var status *database.SatelliteStatus
+	st, err := s.dbQueries.GetSatelliteStatus(r.Context(), satellite.ID)
+	switch {
+	case err == nil:
+		status = &st
+	case !errors.Is(err, sql.ErrNoRows):
+		log.Printf("error: failed to get satellite status: %v", err)
+		err := &AppError{
+			Message: "Error: Failed to Get Satellite",
+			Code:    http.StatusInternalServerError,
+		}
+		HandleAppError(w, err)
+		return
+	}
+	states, err := s.dbQueries.ListSatelliteStateStatuses(r.Context(), satellite.ID)
+	if err != nil {
+		log.Printf("error: failed to list satellite state statuses: %v", err)
+		err := &AppError{
+			Message: "Error: Failed to Get Satellite",
+			Code:    http.StatusInternalServerError,
+		}
+		HandleAppError(w, err)
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusOK, s.satelliteInfo(satellite, status, states, time.Now()))
+}
+
+// satelliteInfo merges the satellite with its last reported status, status is nil if it never sent a heartbeat
+func (s *Server) satelliteInfo(satellite database.Satellite, status *database.SatelliteStatus, states []database.SatelliteStateStatus, now time.Time) models.SatelliteInfo {
+	info := models.SatelliteInfo{
+		ID:        satellite.ID,
+		Name:      satellite.Name,
+		CreatedAt: satellite.CreatedAt,
+		UpdatedAt: satellite.UpdatedAt,
+	}
+	if status != nil {
+		lastHeartbeat := status.LastHeartbeat
+		info.LastHeartbeat = &lastHeartbeat
+		info.Version = status.Version
+		info.GitCommit = status.GitCommit
+		info.System = status.System
+		info.UptimeSeconds = status.UptimeSeconds
+		info.LastError = status.LastError
+		info.DiskTotalBytes = status.DiskTotalBytes
+		info.DiskFreeBytes = status.DiskFreeBytes
+	}
+	info.Status = satelliteStatus(info.LastHeartbeat, now, s.heartbeatStaleAfter, s.heartbeatOfflineAfter)
+	for _, state := range states {
+		heartbeatState := models.HeartbeatState{
+			URL:     state.StateUrl,
+			Version: state.Version,
+			Digest:  state.Digest,
+		}
+		if state.LastSync.Valid {
+			heartbeatState.LastSync = &models.HeartbeatSync{
+				Time:    state.LastSync.Time,
+				Success: state.SyncSuccess,
+				Failed:  int(state.FailedEntities),
+				Error:   state.SyncError,
+			}
+		}
+		info.States = append(info.States, heartbeatState)
+	}
+	return info
+}
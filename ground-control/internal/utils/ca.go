@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// SatelliteCA issues the client certificates the satellites present to Ground Control once registered
+type SatelliteCA struct {
+	cert   *x509.Certificate
+	signer crypto.Signer
+}
+
+// LoadSatelliteCA reads the CA certificate from SATELLITE_CA_CERT and its PKCS#8 private key from SATELLITE_CA_KEY,
+// each either PEM data or a path to a PEM file. It returns nil if they are not set.
+func LoadSatelliteCA() (*SatelliteCA, error) {
+	certValue, keyValue := os.Getenv("SATELLITE_CA_CERT"), os.Getenv("SATELLITE_CA_KEY")
+	if certValue == "" && keyValue == "" {
+		return nil, nil
+	}
+	if certValue == "" || keyValue == "" {
+		return nil, fmt.Errorf("both SATELLITE_CA_CERT and SATELLITE_CA_KEY must be set")
+	}
+	block, err := readPEM(certValue, "satellite CA certificate")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse satellite CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("satellite CA certificate %s is not a CA", cert.Subject)
+	}
+	signer, err := parsePrivateKey(keyValue, "satellite CA key")
+	if err != nil {
+		return nil, err
+	}
+	return &SatelliteCA{
+		cert:   cert,
+		signer: signer,
+	}, nil
+}
+
+// Pool returns a pool holding the CA certificate, to verify the client certificates of the satellites
+func (c *SatelliteCA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+// IssueCertificate signs the PEM encoded certificate request of the satellite. The subject is always the
+// name of the satellite, whatever the request asks for, and the certificate is only valid for client auth.
+func (c *SatelliteCA) IssueCertificate(csrPEM, satellite string, validity time.Duration) (*x509.Certificate, string, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, "", fmt.Errorf("certificate request is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, "", fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: satellite},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, csr.PublicKey, c.signer)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to issue certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, "", err
+	}
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// CertificateSerial returns the serial number of the certificate as stored by Ground Control
+func CertificateSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
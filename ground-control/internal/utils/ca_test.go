@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSatelliteCAIssueCertificate(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ground-control"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SATELLITE_CA_CERT", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})))
+	t.Setenv("SATELLITE_CA_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+
+	ca, err := LoadSatelliteCA()
+	if err != nil {
+		t.Fatalf("LoadSatelliteCA() error = %v", err)
+	}
+
+	satelliteKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "impersonated"}}, satelliteKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+
+	cert, certPEM, err := ca.IssueCertificate(csrPEM, "edge-1", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueCertificate() error = %v", err)
+	}
+	if cert.Subject.CommonName != "edge-1" {
+		t.Errorf("common name = %q, want the satellite name", cert.Subject.CommonName)
+	}
+	if certPEM == "" || CertificateSerial(cert) == "" {
+		t.Error("expected a PEM encoded certificate with a serial")
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("issued certificate does not verify for client auth: %v", err)
+	}
+
+	if _, _, err := ca.IssueCertificate("not a request", "edge-1", time.Hour); err == nil {
+		t.Error("expected an invalid request to be rejected")
+	}
+}
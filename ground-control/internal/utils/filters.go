@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	m "github.com/container-registry/harbor-satellite/ground-control/internal/models"
+	"github.com/container-registry/harbor-satellite/ground-control/reg/harbor"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// taggedArtifact is a tag of a repository in Harbor along with the artifact it points to
+type taggedArtifact struct {
+	tag      string
+	digest   string
+	kind     string
+	pushTime time.Time
+}
+
+// HasTagFilters returns true if any of the artifacts selects its tags with a filter
+func HasTagFilters(artifacts []m.Artifact) bool {
+	for _, artifact := range artifacts {
+		if artifact.Filter != nil && !artifact.Deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTagFilter checks that the regex and the semver constraint of the filter can be compiled
+func ValidateTagFilter(filter *m.TagFilter) error {
+	if filter.Regex == "" && filter.Semver == "" && filter.Latest == 0 {
+		return fmt.Errorf("the filter must set at least one of regex, semver or latest")
+	}
+	if filter.Regex != "" {
+		if _, err := regexp.Compile(filter.Regex); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", filter.Regex, err)
+		}
+	}
+	if filter.Semver != "" {
+		if _, err := semver.NewConstraint(filter.Semver); err != nil {
+			return fmt.Errorf("invalid semver constraint %q: %w", filter.Semver, err)
+		}
+	}
+	if filter.Latest < 0 {
+		return fmt.Errorf("latest must not be negative")
+	}
+	return nil
+}
+
+// ExpandTagFilters replaces the artifacts using a tag filter with the artifacts of the matching tags in
+// Harbor. The artifacts as submitted are kept in the rules of the state so that they can be expanded again.
+func ExpandTagFilters(ctx context.Context, stateArtifact *m.StateArtifact) error {
+	stateArtifact.Rules = nil
+	if !HasTagFilters(stateArtifact.Artifacts) {
+		return nil
+	}
+	stateArtifact.Rules = stateArtifact.Artifacts
+	artifacts, err := expandRules(ctx, stateArtifact.Rules)
+	if err != nil {
+		return err
+	}
+	stateArtifact.Artifacts = artifacts
+	return nil
+}
+
+// expandRules returns the explicit artifacts of the rules followed by the expanded filters, sorted by
+// repository and digest so that the expansion of the same tags is always identical
+func expandRules(ctx context.Context, rules []m.Artifact) ([]m.Artifact, error) {
+	var artifacts []m.Artifact
+	var expanded []m.Artifact
+	for _, rule := range rules {
+		if rule.Filter == nil || rule.Deleted {
+			artifacts = append(artifacts, rule)
+			continue
+		}
+		if err := ValidateTagFilter(rule.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter for %s: %w", rule.Repository, err)
+		}
+		project, repository, found := strings.Cut(rule.Repository, "/")
+		if !found || project == "" || repository == "" {
+			return nil, fmt.Errorf("invalid repository %q: expected <project>/<repository>", rule.Repository)
+		}
+		harborArtifacts, err := harbor.ListArtifacts(ctx, project, repository)
+		if err != nil {
+			return nil, err
+		}
+		var tags []taggedArtifact
+		for _, harborArtifact := range harborArtifacts {
+			for _, tag := range harborArtifact.Tags {
+				tags = append(tags, taggedArtifact{
+					tag:      tag.Name,
+					digest:   harborArtifact.Digest,
+					kind:     harborArtifact.Type,
+					pushTime: time.Time(tag.PushTime),
+				})
+			}
+		}
+		expanded = append(expanded, groupTagsByDigest(rule, filterTags(rule.Filter, tags))...)
+	}
+	sort.SliceStable(expanded, func(i, j int) bool {
+		if expanded[i].Repository != expanded[j].Repository {
+			return expanded[i].Repository < expanded[j].Repository
+		}
+		return expanded[i].Digest < expanded[j].Digest
+	})
+	return append(artifacts, expanded...), nil
+}
+
+// filterTags returns the tags matching the regex and the semver constraint of the filter, limited to the
+// latest pushed ones if set. Tags which are not valid semantic versions never match a semver constraint.
+func filterTags(filter *m.TagFilter, tags []taggedArtifact) []taggedArtifact {
+	var regex *regexp.Regexp
+	if filter.Regex != "" {
+		regex = regexp.MustCompile(filter.Regex)
+	}
+	var constraint *semver.Constraints
+	if filter.Semver != "" {
+		constraint, _ = semver.NewConstraint(filter.Semver)
+	}
+
+	var matched []taggedArtifact
+	for _, tag := range tags {
+		if regex != nil && !regex.MatchString(tag.tag) {
+			continue
+		}
+		if constraint != nil {
+			version, err := semver.NewVersion(tag.tag)
+			if err != nil || !constraint.Check(version) {
+				continue
+			}
+		}
+		matched = append(matched, tag)
+	}
+
+	if filter.Latest > 0 && len(matched) > filter.Latest {
+		sort.SliceStable(matched, func(i, j int) bool {
+			if !matched[i].pushTime.Equal(matched[j].pushTime) {
+				return matched[i].pushTime.After(matched[j].pushTime)
+			}
+			return matched[i].tag < matched[j].tag
+		})
+		matched = matched[:filter.Latest]
+	}
+	return matched
+}
+
+// groupTagsByDigest builds one artifact per digest holding all its matching tags
+func groupTagsByDigest(rule m.Artifact, tags []taggedArtifact) []m.Artifact {
+	byDigest := make(map[string]*m.Artifact)
+	var digests []string
+	for _, tag := range tags {
+		artifact, exists := byDigest[tag.digest]
+		if !exists {
+			kind := rule.Type
+			if kind == "" {
+				kind = tag.kind
+			}
+			artifact = &m.Artifact{
+				Repository: rule.Repository,
+				Labels:     rule.Labels,
+				Type:       kind,
+				Digest:     tag.digest,
+			}
+			byDigest[tag.digest] = artifact
+			digests = append(digests, tag.digest)
+		}
+		artifact.Tag = append(artifact.Tag, tag.tag)
+	}
+	artifacts := make([]m.Artifact, 0, len(digests))
+	for _, digest := range digests {
+		sort.Strings(byDigest[digest].Tag)
+		artifacts = append(artifacts, *byDigest[digest])
+	}
+	return artifacts
+}
+
+// FetchStateArtifact pulls the current state artifact of a group from Harbor
+func FetchStateArtifact(ctx context.Context, group string) (*m.StateArtifact, error) {
+	stateArtifact, _, err := PullStateArtifact(ctx, AssembleGroupState(group))
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %w", group, err)
+	}
+	return stateArtifact, nil
+}
+
+// GroupStateAtDigest returns the reference of the state artifact of a group with the given digest, the
+// previous states of the group stay in Harbor under their timestamp tags
+func GroupStateAtDigest(group, digest string) string {
+	return fmt.Sprintf("%s/satellite/group-state/%s/state@%s", os.Getenv("HARBOR_URL"), group, digest)
+}
+
+// PullStateArtifact pulls the state artifact at the reference from Harbor and returns it along with its digest
+func PullStateArtifact(ctx context.Context, reference string) (*m.StateArtifact, string, error) {
+	if err := envSanityCheck(); err != nil {
+		return nil, "", err
+	}
+	auth := authn.FromConfig(authn.AuthConfig{Username: username, Password: password})
+	img, err := crane.Pull(stripProtocol(reference), crane.WithAuth(auth), crane.WithContext(ctx))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to pull the state artifact %s: %v", reference, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to compute the digest of the state artifact %s: %v", reference, err)
+	}
+
+	reader := mutate.Extract(img)
+	defer reader.Close()
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("the state artifact %s has no %s", reference, stateArtifactFile)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read the state artifact %s: %v", reference, err)
+		}
+		if header.Name != stateArtifactFile {
+			continue
+		}
+		var stateArtifact m.StateArtifact
+		if err := json.NewDecoder(tarReader).Decode(&stateArtifact); err != nil {
+			return nil, "", fmt.Errorf("failed to decode the state artifact %s: %v", reference, err)
+		}
+		return &stateArtifact, digest.String(), nil
+	}
+}
+
+// RefreshTagFilters expands the tag filters of the state of a group again and pushes a new state if the
+// matching tags changed in Harbor. It returns true if a new state was pushed.
+func RefreshTagFilters(ctx context.Context, versions StateVersions, group string) (bool, error) {
+	stateArtifact, err := FetchStateArtifact(ctx, group)
+	if err != nil {
+		return false, err
+	}
+	if len(stateArtifact.Rules) == 0 {
+		return false, nil
+	}
+	artifacts, err := expandRules(ctx, stateArtifact.Rules)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(artifacts, stateArtifact.Artifacts) {
+		return false, nil
+	}
+	stateArtifact.Group = group
+	stateArtifact.Artifacts = artifacts
+	if err := CreateStateArtifact(ctx, versions, stateArtifact); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	m "github.com/container-registry/harbor-satellite/ground-control/internal/models"
+)
+
+func TestFilterTags(t *testing.T) {
+	now := time.Now()
+	tags := []taggedArtifact{
+		{tag: "v1.0.0", digest: "sha256:a", pushTime: now.Add(-4 * time.Hour)},
+		{tag: "v1.1.0", digest: "sha256:b", pushTime: now.Add(-3 * time.Hour)},
+		{tag: "v2.0.0", digest: "sha256:c", pushTime: now.Add(-2 * time.Hour)},
+		{tag: "latest", digest: "sha256:c", pushTime: now.Add(-2 * time.Hour)},
+		{tag: "nightly-42", digest: "sha256:d", pushTime: now.Add(-1 * time.Hour)},
+	}
+
+	tests := []struct {
+		name   string
+		filter m.TagFilter
+		want   []string
+	}{
+		{name: "regex", filter: m.TagFilter{Regex: "^nightly-"}, want: []string{"nightly-42"}},
+		{name: "semver", filter: m.TagFilter{Semver: ">=1.1, <2"}, want: []string{"v1.1.0"}},
+		{name: "latest", filter: m.TagFilter{Latest: 2}, want: []string{"nightly-42", "latest"}},
+		{name: "semver and latest", filter: m.TagFilter{Semver: ">=1", Latest: 2}, want: []string{"v2.0.0", "v1.1.0"}},
+		{name: "regex and semver", filter: m.TagFilter{Regex: "^v1", Semver: "<1.1"}, want: []string{"v1.0.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tag := range filterTags(&tt.filter, tags) {
+				got = append(got, tag.tag)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupTagsByDigest(t *testing.T) {
+	rule := m.Artifact{Repository: "library/nginx", Filter: &m.TagFilter{Latest: 3}}
+	tags := []taggedArtifact{
+		{tag: "v2", digest: "sha256:b", kind: "IMAGE"},
+		{tag: "latest", digest: "sha256:b", kind: "IMAGE"},
+		{tag: "v1", digest: "sha256:a", kind: "IMAGE"},
+	}
+	want := []m.Artifact{
+		{Repository: "library/nginx", Tag: []string{"latest", "v2"}, Digest: "sha256:b", Type: "IMAGE"},
+		{Repository: "library/nginx", Tag: []string{"v1"}, Digest: "sha256:a", Type: "IMAGE"},
+	}
+	if got := groupTagsByDigest(rule, tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupTagsByDigest() = %+v, want %+v", got, want)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
 	m "github.com/container-registry/harbor-satellite/ground-control/internal/models"
 	"github.com/container-registry/harbor-satellite/ground-control/reg/harbor"
 	"github.com/goharbor/go-client/pkg/sdk/v2.0/client/robot"
@@ -85,33 +86,62 @@ func UpdateRobotProjects(ctx context.Context, projects []string, id string) (*ro
 	return updated, nil
 }
 
+// StateVersions hands out the versions of the state artifacts, increased for each state pushed to a repository so
+// that the satellites can reject older states whatever the clock of Ground Control says
+type StateVersions interface {
+	NextStateVersion(ctx context.Context, arg database.NextStateVersionParams) (int64, error)
+}
+
+// nextStateVersion returns the version of the next state of the subject. The first version is taken from the
+// clock so that it follows the versions issued before they were counted.
+func nextStateVersion(ctx context.Context, versions StateVersions, subject string) (int64, error) {
+	version, err := versions.NextStateVersion(ctx, database.NextStateVersionParams{Subject: subject, Version: time.Now().UnixNano()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the version of state %s: %w", subject, err)
+	}
+	return version, nil
+}
+
 func AssembleGroupState(groupName string) string {
 	state := fmt.Sprintf("%s/satellite/group-state/%s/state:latest", os.Getenv("HARBOR_URL"), groupName)
 	return state
 }
 
 // Create State Artifact for group
-func CreateStateArtifact(ctx context.Context, stateArtifact *m.StateArtifact) error {
+func CreateStateArtifact(ctx context.Context, versions StateVersions, stateArtifact *m.StateArtifact) error {
 	// Set the registry URL from environment variable
 	stateArtifact.Registry = os.Getenv("HARBOR_URL")
 	if stateArtifact.Registry == "" {
 		return fmt.Errorf("HARBOR_URL environment variable is not set")
 	}
 
+	// Configure repository and credentials
+	repo := fmt.Sprintf("satellite/group-state/%s", stateArtifact.Group)
+
+	// The version lets the satellites reject older states and the subject binds the state to its repository
+	stateArtifact.Subject = fmt.Sprintf("%s/state", repo)
+	version, err := nextStateVersion(ctx, versions, stateArtifact.Subject)
+	if err != nil {
+		return err
+	}
+	stateArtifact.Version = version
+
 	// Marshal the state artifact to JSON format
 	data, err := json.Marshal(stateArtifact)
 	if err != nil {
 		return fmt.Errorf("failed to marshal state artifact to JSON: %v", err)
 	}
 
-	// Create the image with the state artifact JSON
-	img, err := crane.Image(map[string][]byte{"artifacts.json": data})
+	// Create the image with the state artifact JSON and its signature
+	files, err := stateArtifactFiles(data)
+	if err != nil {
+		return err
+	}
+	img, err := crane.Image(files)
 	if err != nil {
 		return fmt.Errorf("failed to create image: %v", err)
 	}
 
-	// Configure repository and credentials
-	repo := fmt.Sprintf("satellite/group-state/%s", stateArtifact.Group)
 	username := os.Getenv("HARBOR_USERNAME")
 	password := os.Getenv("HARBOR_PASSWORD")
 	if username == "" || password == "" {
@@ -150,7 +180,7 @@ func AssembleSatelliteState(satelliteName string) string {
 	return fmt.Sprintf("%s/satellite/satellite-state/%s/state:latest", os.Getenv("HARBOR_URL"), satelliteName)
 }
 
-func CreateOrUpdateSatStateArtifact(ctx context.Context, satelliteName string, states []string) error {
+func CreateOrUpdateSatStateArtifact(ctx context.Context, versions StateVersions, satelliteName string, states []string) error {
 	if satelliteName == "" {
 		return fmt.Errorf("the satellite name must be atleast one character long")
 	}
@@ -163,18 +193,31 @@ func CreateOrUpdateSatStateArtifact(ctx context.Context, satelliteName string, s
 		return err
 	}
 
-	satelliteState := &m.SatelliteStateArtifact{States: states}
+	repo := fmt.Sprintf("satellite/satellite-state/%s", satelliteName)
+	subject := fmt.Sprintf("%s/state", repo)
+	version, err := nextStateVersion(ctx, versions, subject)
+	if err != nil {
+		return err
+	}
+	satelliteState := &m.SatelliteStateArtifact{
+		States:  states,
+		Version: version,
+		Subject: subject,
+	}
 	data, err := json.Marshal(satelliteState)
 	if err != nil {
 		return fmt.Errorf("failed to marshal satellite state artifact to JSON: %v", err)
 	}
 
-	img, err := crane.Image(map[string][]byte{"artifacts.json": data})
+	files, err := stateArtifactFiles(data)
+	if err != nil {
+		return err
+	}
+	img, err := crane.Image(files)
 	if err != nil {
 		return fmt.Errorf("failed to create image: %v", err)
 	}
 
-	repo := fmt.Sprintf("satellite/satellite-state/%s", satelliteName)
 	auth := authn.FromConfig(authn.AuthConfig{Username: username, Password: password})
 	options := []crane.Option{crane.WithAuth(auth), crane.WithContext(ctx)}
 
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/container-registry/harbor-satellite/ground-control/reg/harbor"
+)
+
+const (
+	robotSecretLength   = 32
+	robotSecretAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// GenerateRobotSecret returns a random robot account secret meeting the requirements of Harbor, at least one
+// lowercase letter, one uppercase letter and one digit
+func GenerateRobotSecret() (string, error) {
+	size := big.NewInt(int64(len(robotSecretAlphabet)))
+	for {
+		var secret strings.Builder
+		for range robotSecretLength {
+			n, err := rand.Int(rand.Reader, size)
+			if err != nil {
+				return "", fmt.Errorf("failed to generate robot secret: %w", err)
+			}
+			secret.WriteByte(robotSecretAlphabet[n.Int64()])
+		}
+		value := secret.String()
+		if strings.ContainsAny(value, "abcdefghijklmnopqrstuvwxyz") &&
+			strings.ContainsAny(value, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+			strings.ContainsAny(value, "0123456789") {
+			return value, nil
+		}
+	}
+}
+
+// RefreshRobotSecret sets the secret of the robot account in Harbor, the previous secret is rejected from then on
+func RefreshRobotSecret(ctx context.Context, id, secret string) error {
+	ID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("error invalid ID: %w", err)
+	}
+	if _, err := harbor.RefreshRobotAccount(ctx, secret, ID); err != nil {
+		return fmt.Errorf("error refreshing robot secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRobotSecret(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 100 {
+		secret, err := GenerateRobotSecret()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(secret) != robotSecretLength {
+			t.Fatalf("secret %q has length %d, want %d", secret, len(secret), robotSecretLength)
+		}
+		if !strings.ContainsAny(secret, "abcdefghijklmnopqrstuvwxyz") ||
+			!strings.ContainsAny(secret, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") ||
+			!strings.ContainsAny(secret, "0123456789") {
+			t.Fatalf("secret %q does not meet the requirements of Harbor", secret)
+		}
+		if seen[secret] {
+			t.Fatalf("secret %q generated twice", secret)
+		}
+		seen[secret] = true
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// stateArtifactFile is the file of the state artifact holding the state
+	stateArtifactFile = "artifacts.json"
+	// stateSignatureFile is the file of the state artifact holding the base64 encoded signature of the state
+	stateSignatureFile = "artifacts.json.sig"
+)
+
+// stateArtifactFiles returns the files of the state artifact image. If STATE_SIGNING_KEY is set the
+// state is signed with it and the signature is added next to the state.
+func stateArtifactFiles(data []byte) (map[string][]byte, error) {
+	files := map[string][]byte{stateArtifactFile: data}
+	signer, err := loadStateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return files, nil
+	}
+	signature, err := signState(signer, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign state artifact: %w", err)
+	}
+	files[stateSignatureFile] = []byte(base64.StdEncoding.EncodeToString(signature))
+	return files, nil
+}
+
+// loadStateSigningKey reads the PKCS#8 private key from STATE_SIGNING_KEY, which is either PEM data or a
+// path to a PEM file. It returns nil if the variable is not set.
+func loadStateSigningKey() (crypto.Signer, error) {
+	value := os.Getenv("STATE_SIGNING_KEY")
+	if value == "" {
+		return nil, nil
+	}
+	return parsePrivateKey(value, "state signing key")
+}
+
+// parsePrivateKey parses the PKCS#8 private key given as PEM data or as a path to a PEM file
+func parsePrivateKey(value, name string) (crypto.Signer, error) {
+	block, err := readPEM(value, name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported %s type %T", name, key)
+	}
+	return signer, nil
+}
+
+// readPEM returns the first PEM block of value, which is either PEM data or a path to a PEM file
+func readPEM(value, name string) (*pem.Block, error) {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", name)
+	}
+	return block, nil
+}
+
+// signState signs the state with ECDSA or RSA PKCS#1 v1.5 over its SHA-256 digest, or with ed25519 over the state itself
+func signState(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/container-registry/harbor-satellite/ground-control/internal/database"
+)
+
+func TestStateArtifactFilesSignature(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"group":"edge","version":7,"subject":"satellite/group-state/edge/state"}`)
+
+	for _, tc := range []struct {
+		name string
+		key  crypto.Signer
+	}{
+		{name: "ecdsa", key: ecdsaKey},
+		{name: "ed25519", key: ed25519Key},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("STATE_SIGNING_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+
+			files, err := stateArtifactFiles(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(files[stateArtifactFile]) != string(data) {
+				t.Fatalf("state file is %q, want %q", files[stateArtifactFile], data)
+			}
+			signature, err := base64.StdEncoding.DecodeString(string(files[stateSignatureFile]))
+			if err != nil {
+				t.Fatalf("invalid signature encoding: %v", err)
+			}
+			if !verifyState(tc.key.Public(), data, signature) {
+				t.Fatal("signature does not verify with the public key")
+			}
+
+			tampered := []byte(`{"group":"edge","version":8,"subject":"satellite/group-state/edge/state"}`)
+			if verifyState(tc.key.Public(), tampered, signature) {
+				t.Fatal("signature verifies a tampered state")
+			}
+		})
+	}
+}
+
+func TestStateArtifactFilesUnsigned(t *testing.T) {
+	t.Setenv("STATE_SIGNING_KEY", "")
+	files, err := stateArtifactFiles([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files[stateSignatureFile]; ok {
+		t.Fatal("state signed without a signing key")
+	}
+}
+
+// fakeStateVersions counts the versions of each subject as the state_versions table does
+type fakeStateVersions map[string]int64
+
+func (f fakeStateVersions) NextStateVersion(ctx context.Context, arg database.NextStateVersionParams) (int64, error) {
+	if version, ok := f[arg.Subject]; ok {
+		f[arg.Subject] = version + 1
+	} else {
+		f[arg.Subject] = arg.Version
+	}
+	return f[arg.Subject], nil
+}
+
+func TestNextStateVersionIncreases(t *testing.T) {
+	versions := fakeStateVersions{"satellite/group-state/edge/state": 100}
+	first, err := nextStateVersion(context.Background(), versions, "satellite/group-state/edge/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := nextStateVersion(context.Background(), versions, "satellite/group-state/edge/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 101 || second != 102 {
+		t.Fatalf("versions are %d and %d, want 101 and 102", first, second)
+	}
+	if created, err := nextStateVersion(context.Background(), versions, "satellite/group-state/new/state"); err != nil || created <= second {
+		t.Fatalf("first version of a new state is %d (%v), want one taken from the clock", created, err)
+	}
+}
+
+// verifyState checks the signature as the satellite does
+func verifyState(key crypto.PublicKey, data, signature []byte) bool {
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, signature)
+	default:
+		return false
+	}
+}
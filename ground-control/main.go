@@ -1,19 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/ground-control/internal/server"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func main() {
-	server := server.NewServer()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := server.NewServer(ctx)
+	// The background tasks stop along with the server, the requests in flight are given some time to complete
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down the server: %v", err)
+		}
+	}()
 
 	fmt.Printf("Ground Control running on port %s\n", server.Addr)
-	err := server.ListenAndServe()
-	if err != nil {
+	var err error
+	// Serving TLS is required for the satellites to authenticate with their client certificate
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("cannot start server: %s", err)
 	}
 }
@@ -0,0 +1,41 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/goharbor/go-client/pkg/sdk/v2.0/client/artifact"
+	"github.com/goharbor/go-client/pkg/sdk/v2.0/models"
+)
+
+const artifactPageSize int64 = 100
+
+// ListArtifacts lists all the artifacts of a repository along with their tags
+func ListArtifacts(ctx context.Context, project, repository string) ([]*models.Artifact, error) {
+	client := GetClient()
+	var (
+		artifacts []*models.Artifact
+		page      int64 = 1
+		pageSize        = artifactPageSize
+		withTag         = true
+	)
+	for {
+		// Nested repository names must be escaped, the client escapes the path parameter again
+		response, err := client.Artifact.ListArtifacts(ctx, &artifact.ListArtifactsParams{
+			ProjectName:    project,
+			RepositoryName: url.PathEscape(repository),
+			Page:           &page,
+			PageSize:       &pageSize,
+			WithTag:        &withTag,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error: listing artifacts of %s/%s: %v", project, repository, err)
+		}
+		artifacts = append(artifacts, response.Payload...)
+		if int64(len(response.Payload)) < pageSize {
+			return artifacts, nil
+		}
+		page++
+	}
+}
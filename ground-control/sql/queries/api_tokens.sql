@@ -0,0 +1,16 @@
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (name, token_hash, role, created_at, updated_at)
+VALUES ($1, $2, $3, NOW(), NOW())
+RETURNING *;
+
+-- name: GetAPITokenByHash :one
+SELECT * FROM api_tokens
+WHERE token_hash = $1 LIMIT 1;
+
+-- name: ListAPITokens :many
+SELECT * FROM api_tokens
+ORDER BY name;
+
+-- name: DeleteAPITokenByName :exec
+DELETE FROM api_tokens
+WHERE name = $1;
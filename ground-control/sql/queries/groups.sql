@@ -26,3 +26,8 @@ WHERE id = $1;
 -- name: GetProjectsOfGroup :many
 SELECT projects FROM groups
 WHERE group_name = $1;
+
+-- name: LockGroup :one
+SELECT * FROM groups
+WHERE id = $1
+FOR UPDATE;
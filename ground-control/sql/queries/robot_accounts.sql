@@ -5,6 +5,9 @@ VALUES ($1, $2, $3, $4, NOW(), NOW())
   DO UPDATE SET
   robot_name = EXCLUDED.robot_name,
   robot_secret = EXCLUDED.robot_secret,
+  previous_secret = '',
+  secret_cutover_at = NULL,
+  secret_rotated_at = NOW(),
   updated_at = NOW()
 RETURNING *;
 
@@ -16,6 +19,10 @@ WHERE id = $1;
 SELECT * FROM robot_accounts
 WHERE satellite_id = $1;
 
+-- name: GetRobotAccByName :one
+SELECT * FROM robot_accounts
+WHERE robot_name = $1;
+
 -- name: ListRobotAccounts :many
 SELECT * FROM robot_accounts;
 
@@ -30,3 +37,19 @@ SET robot_name = $2,
     robot_id = $4,
     updated_at = NOW()
 WHERE id = $1;
+
+-- name: StageRobotSecret :execrows
+UPDATE robot_accounts
+SET previous_secret = robot_secret,
+    robot_secret = $2,
+    secret_cutover_at = $3,
+    secret_rotated_at = $4,
+    updated_at = NOW()
+WHERE id = $1 AND secret_cutover_at IS NULL;
+
+-- name: ApplyRobotSecret :exec
+UPDATE robot_accounts
+SET previous_secret = '',
+    secret_cutover_at = NULL,
+    updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,22 @@
+-- name: AddSatelliteCertificate :exec
+INSERT INTO satellite_certificates (serial, satellite_id, not_after, created_at)
+VALUES ($1, $2, $3, NOW());
+
+-- name: GetSatelliteCertificate :one
+SELECT * FROM satellite_certificates
+WHERE serial = $1 LIMIT 1;
+
+-- name: RevokeSatelliteCertificates :execrows
+UPDATE satellite_certificates
+SET revoked_at = NOW()
+WHERE satellite_id = $1 AND revoked_at IS NULL;
+
+-- name: CountSatelliteCertificates :one
+SELECT COUNT(*) FROM satellite_certificates
+WHERE satellite_id = $1 AND revoked_at IS NULL AND not_after > $2;
+
+-- name: GetLatestSatelliteCertificate :one
+SELECT * FROM satellite_certificates
+WHERE satellite_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
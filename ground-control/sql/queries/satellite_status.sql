@@ -0,0 +1,43 @@
+-- name: UpsertSatelliteStatus :exec
+INSERT INTO satellite_status (satellite_id, version, git_commit, system, uptime_seconds, last_error, disk_total_bytes, disk_free_bytes, last_heartbeat)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (satellite_id) DO UPDATE SET
+  version = EXCLUDED.version,
+  git_commit = EXCLUDED.git_commit,
+  system = EXCLUDED.system,
+  uptime_seconds = EXCLUDED.uptime_seconds,
+  last_error = EXCLUDED.last_error,
+  disk_total_bytes = EXCLUDED.disk_total_bytes,
+  disk_free_bytes = EXCLUDED.disk_free_bytes,
+  last_heartbeat = EXCLUDED.last_heartbeat;
+
+-- name: GetSatelliteStatus :one
+SELECT * FROM satellite_status
+WHERE satellite_id = $1 LIMIT 1;
+
+-- name: ListSatelliteStatuses :many
+SELECT * FROM satellite_status;
+
+-- name: DeleteSatelliteStateStatuses :exec
+DELETE FROM satellite_state_status
+WHERE satellite_id = $1;
+
+-- name: AddSatelliteStateStatus :exec
+INSERT INTO satellite_state_status (satellite_id, state_url, version, digest, last_sync, sync_success, sync_error, failed_entities)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (satellite_id, state_url) DO UPDATE SET
+  version = EXCLUDED.version,
+  digest = EXCLUDED.digest,
+  last_sync = EXCLUDED.last_sync,
+  sync_success = EXCLUDED.sync_success,
+  sync_error = EXCLUDED.sync_error,
+  failed_entities = EXCLUDED.failed_entities;
+
+-- name: ListSatelliteStateStatuses :many
+SELECT * FROM satellite_state_status
+WHERE satellite_id = $1
+ORDER BY state_url;
+
+-- name: ListSatelliteStateStatusesByURL :many
+SELECT * FROM satellite_state_status
+WHERE state_url = $1;
@@ -0,0 +1,8 @@
+-- name: NextStateVersion :one
+INSERT INTO state_versions (subject, version, updated_at)
+VALUES ($1, $2, NOW())
+  ON CONFLICT (subject)
+  DO UPDATE SET
+  version = state_versions.version + 1,
+  updated_at = NOW()
+RETURNING version;
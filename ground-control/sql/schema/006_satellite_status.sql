@@ -0,0 +1,29 @@
+-- +goose Up
+
+CREATE TABLE satellite_status (
+  satellite_id INT PRIMARY KEY REFERENCES satellites(id) ON DELETE CASCADE,
+  version VARCHAR(64) NOT NULL,
+  git_commit VARCHAR(64) NOT NULL DEFAULT '',
+  system VARCHAR(64) NOT NULL DEFAULT '',
+  uptime_seconds BIGINT NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  disk_total_bytes BIGINT NOT NULL DEFAULT 0,
+  disk_free_bytes BIGINT NOT NULL DEFAULT 0,
+  last_heartbeat TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE satellite_state_status (
+  satellite_id INT NOT NULL REFERENCES satellites(id) ON DELETE CASCADE,
+  state_url VARCHAR(255) NOT NULL,
+  version BIGINT NOT NULL DEFAULT 0,
+  digest VARCHAR(255) NOT NULL DEFAULT '',
+  last_sync TIMESTAMP,
+  sync_success BOOLEAN NOT NULL DEFAULT FALSE,
+  sync_error TEXT NOT NULL DEFAULT '',
+  failed_entities INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (satellite_id, state_url)
+);
+
+-- +goose Down
+DROP TABLE satellite_state_status;
+DROP TABLE satellite_status;
@@ -0,0 +1,13 @@
+-- +goose Up
+
+CREATE TABLE api_tokens (
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(255) UNIQUE NOT NULL,
+  token_hash VARCHAR(64) UNIQUE NOT NULL,
+  role VARCHAR(32) NOT NULL CHECK (role IN ('admin', 'group-editor', 'read-only')),
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE api_tokens;
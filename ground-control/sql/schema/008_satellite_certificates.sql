@@ -0,0 +1,12 @@
+-- +goose Up
+
+CREATE TABLE satellite_certificates (
+  serial VARCHAR(64) PRIMARY KEY,
+  satellite_id INT NOT NULL REFERENCES satellites(id) ON DELETE CASCADE,
+  not_after TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE satellite_certificates;
@@ -0,0 +1,13 @@
+-- +goose Up
+
+-- While a rotated secret is staged, Harbor still accepts previous_secret until secret_cutover_at
+ALTER TABLE robot_accounts
+  ADD COLUMN previous_secret VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN secret_cutover_at TIMESTAMP,
+  ADD COLUMN secret_rotated_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- +goose Down
+ALTER TABLE robot_accounts
+  DROP COLUMN previous_secret,
+  DROP COLUMN secret_cutover_at,
+  DROP COLUMN secret_rotated_at;
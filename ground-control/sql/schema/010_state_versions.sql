@@ -0,0 +1,12 @@
+-- +goose Up
+
+-- The versions of the state artifacts, increased for each state pushed to a repository. The first version of a
+-- repository is taken from the clock so that it follows the versions issued before the counter existed.
+CREATE TABLE state_versions (
+  subject VARCHAR(255) PRIMARY KEY,
+  version BIGINT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE state_versions;
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/robfig/cron/v3"
 )
@@ -28,22 +29,115 @@ type LocalRegistryConfig struct {
 	BringOwnRegistry bool   `json:"bring_own_registry"`
 }
 
+// ReplicationConfig holds the settings used by the replicator while copying images to the local registry
+type ReplicationConfig struct {
+	// MaxConcurrency is the maximum number of images replicated at the same time
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// MaxConcurrencyPerRegistry is the maximum number of images replicated at the same time from a single registry
+	MaxConcurrencyPerRegistry int `json:"max_concurrency_per_registry,omitempty"`
+	// Platforms is the list of platforms, in the os/arch[/variant] format, kept when replicating multi-arch images
+	Platforms []string `json:"platforms,omitempty"`
+	// SkipReferrers disables the replication of the signatures, SBOMs and attestations attached to the images
+	SkipReferrers bool `json:"skip_referrers,omitempty"`
+	// BandwidthLimit is the bandwidth shared by all the pulls per second, e.g. 10MiB, unlimited if empty
+	BandwidthLimit string `json:"bandwidth_limit,omitempty"`
+	// PerTransferBandwidthLimit is the bandwidth of the pull of a single image per second, unlimited if empty
+	PerTransferBandwidthLimit string `json:"per_transfer_bandwidth_limit,omitempty"`
+	// TransferWindows are the daily windows, in the HH:MM-HH:MM format in local time, in which large images are
+	// pulled. Outside of them only deletions and small updates are applied, images are pulled at any time if empty
+	TransferWindows []string `json:"transfer_windows,omitempty"`
+	// SmallTransferThreshold is the largest pull still done outside of the transfer windows, 1MiB by default
+	SmallTransferThreshold string `json:"small_transfer_threshold,omitempty"`
+	// StagingDir is the directory the layers are downloaded to before being pushed to the local registry, so that
+	// interrupted downloads are resumed, next to the reconciliation state by default
+	StagingDir string `json:"staging_dir,omitempty"`
+	// DownloadRetries is the number of times an interrupted layer download is resumed within a run
+	DownloadRetries int `json:"download_retries,omitempty"`
+}
+
+// VerificationConfig holds the keys used to verify the signatures of the images before they are replicated
+type VerificationConfig struct {
+	// CosignPublicKeys is the list of PEM encoded public keys, or paths to them, trusted for cosign signatures
+	CosignPublicKeys []string `json:"cosign_public_keys,omitempty"`
+	// NotationTrustCertificates is the list of PEM encoded certificates, or paths to them, trusted for notation signatures
+	NotationTrustCertificates []string `json:"notation_trust_certificates,omitempty"`
+	// StatePublicKey is the PEM encoded public key, or path to it, of Ground Control used to verify the state artifacts
+	StatePublicKey string `json:"state_public_key,omitempty"`
+	// Mode is either enforce, where unsigned images are not replicated, or warn, where they are only reported
+	Mode string `json:"mode,omitempty"`
+}
+
+// StorageConfig limits the storage used by the replicated images in the local registry
+type StorageConfig struct {
+	// Quota is the storage budget of the replicated images, e.g. 20GiB, least recently pulled images are evicted
+	// when replicating new ones would exceed it
+	Quota string `json:"quota,omitempty"`
+	// PinnedImages is the list of images, as <repository>[:<tag>] glob patterns, which are never evicted
+	PinnedImages []string `json:"pinned_images,omitempty"`
+	// RegistryLog is the JSON request log of the local registry the image pulls are read from, the log of the
+	// bundled zot registry is written next to the reconciliation state unless its config sets one
+	RegistryLog string `json:"registry_log,omitempty"`
+}
+
+// GarbageCollectionConfig holds the garbage collection and scrub settings generated for the bundled zot registry
+type GarbageCollectionConfig struct {
+	// Disabled turns off the garbage collection, the blobs of the deleted images are then never reclaimed
+	Disabled bool `json:"disabled,omitempty"`
+	// Delay is the minimum age of an unreferenced blob before it is deleted
+	Delay string `json:"delay,omitempty"`
+	// Interval is the interval at which zot collects the unreferenced blobs
+	Interval string `json:"interval,omitempty"`
+	// ScrubInterval is the interval at which zot checks the integrity of the images, the scrub is disabled if empty
+	ScrubInterval string `json:"scrub_interval,omitempty"`
+}
+
+// HealthConfig holds the thresholds of the readiness checks
+type HealthConfig struct {
+	// MinFreeDisk is the free space required on the disk holding the images, e.g. 1GiB
+	MinFreeDisk string `json:"min_free_disk,omitempty"`
+	// MaxMissedSyncs is the number of replication intervals without a completed replication after which the
+	// satellite is no longer ready
+	MaxMissedSyncs int `json:"max_missed_syncs,omitempty"`
+}
+
+// APIConfig holds the settings of the local API of the satellite
+type APIConfig struct {
+	// Address is the host:port the API listens on, only the loopback interface by default
+	Address string `json:"address,omitempty"`
+	// Token is the bearer token required by the POST routes, such as the sync or the garbage collection, which
+	// are refused if it is empty
+	Token string `json:"token,omitempty"`
+}
+
 // LocalJsonConfig is a struct that holds the configs that are passed as environment variables
 type LocalJsonConfig struct {
-	GroundControlURL          string              `json:"ground_control_url"`
-	LogLevel                  string              `json:"log_level"`
-	UseUnsecure               bool                `json:"use_unsecure"`
-	ZotConfigPath             string              `json:"zot_config_path"`
-	Token                     string              `json:"token"`
-	StateReplicationInterval  string              `json:"state_replication_interval"`
-	UpdateConfigInterval      string              `json:"update_config_interval"`
-	RegisterSatelliteInterval string              `json:"register_satellite_interval"`
-	LocalRegistryConfig       LocalRegistryConfig `json:"local_registry"`
+	GroundControlURL          string                  `json:"ground_control_url"`
+	LogLevel                  string                  `json:"log_level"`
+	UseUnsecure               bool                    `json:"use_unsecure"`
+	ZotConfigPath             string                  `json:"zot_config_path"`
+	Token                     string                  `json:"token"`
+	StateReplicationInterval  string                  `json:"state_replication_interval"`
+	UpdateConfigInterval      string                  `json:"update_config_interval"`
+	RegisterSatelliteInterval string                  `json:"register_satellite_interval"`
+	HeartbeatInterval         string                  `json:"heartbeat_interval,omitempty"`
+	LocalRegistryConfig       LocalRegistryConfig     `json:"local_registry"`
+	ReplicationConfig         ReplicationConfig       `json:"replication,omitempty"`
+	VerificationConfig        VerificationConfig      `json:"verification,omitempty"`
+	StateStorePath            string                  `json:"state_store_path,omitempty"`
+	IdentityDir               string                  `json:"identity_dir,omitempty"`
+	FullResyncInterval        string                  `json:"full_resync_interval,omitempty"`
+	StorageConfig             StorageConfig           `json:"storage,omitempty"`
+	GarbageCollectionConfig   GarbageCollectionConfig `json:"garbage_collection,omitempty"`
+	HealthConfig              HealthConfig            `json:"health,omitempty"`
+	APIConfig                 APIConfig               `json:"api,omitempty"`
 }
 
 type StateConfig struct {
-	Auth  Auth   `json:"auth,omitempty"`
-	State string `json:"state,omitempty"`
+	Auth Auth `json:"auth,omitempty"`
+	// PendingAuth holds the robot secret rotated by Ground Control until the registry accepts it, Auth is used
+	// meanwhile
+	PendingAuth *Auth  `json:"pending_auth,omitempty"`
+	State       string `json:"state,omitempty"`
 }
 
 type Config struct {
@@ -125,6 +219,84 @@ func LoadConfig(configPath string) (*Config, []error, []Warning) {
 		config.LocalJsonConfig.UpdateConfigInterval = DefaultSchedule
 	}
 
+	if interval := config.LocalJsonConfig.HeartbeatInterval; interval != "" && !isValidCronExpression(interval) {
+		cronWarning := Warning(fmt.Sprintf("invalid schedule %s for HeartbeatInterval, using default schedule %s", interval, DefaultHeartbeatInterval))
+		warnings = append(warnings, cronWarning)
+		config.LocalJsonConfig.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if interval := config.LocalJsonConfig.FullResyncInterval; interval != "" {
+		if _, err := time.ParseDuration(interval); err != nil {
+			durationWarning := Warning(fmt.Sprintf("invalid duration %s for FullResyncInterval, using default interval %s", interval, DefaultFullResyncInterval))
+			warnings = append(warnings, durationWarning)
+			config.LocalJsonConfig.FullResyncInterval = DefaultFullResyncInterval
+		}
+	}
+
+	if quota := config.LocalJsonConfig.StorageConfig.Quota; quota != "" {
+		if _, err := ParseSize(quota); err != nil {
+			quotaWarning := Warning(fmt.Sprintf("invalid storage quota %s, the storage is not limited: %v", quota, err))
+			warnings = append(warnings, quotaWarning)
+			config.LocalJsonConfig.StorageConfig.Quota = ""
+		}
+	}
+
+	replicationConfig := &config.LocalJsonConfig.ReplicationConfig
+	for _, setting := range []struct {
+		name     string
+		value    *string
+		fallback string
+	}{
+		{"BandwidthLimit", &replicationConfig.BandwidthLimit, ""},
+		{"PerTransferBandwidthLimit", &replicationConfig.PerTransferBandwidthLimit, ""},
+		{"SmallTransferThreshold", &replicationConfig.SmallTransferThreshold, DefaultSmallTransferThreshold},
+	} {
+		if *setting.value == "" {
+			continue
+		}
+		if _, err := ParseSize(*setting.value); err != nil {
+			sizeWarning := Warning(fmt.Sprintf("invalid size %s for %s, using default %q: %v", *setting.value, setting.name, setting.fallback, err))
+			warnings = append(warnings, sizeWarning)
+			*setting.value = setting.fallback
+		}
+	}
+
+	if minFree := config.LocalJsonConfig.HealthConfig.MinFreeDisk; minFree != "" {
+		if _, err := ParseSize(minFree); err != nil {
+			sizeWarning := Warning(fmt.Sprintf("invalid size %s for MinFreeDisk, using default %q: %v", minFree, DefaultMinFreeDisk, err))
+			warnings = append(warnings, sizeWarning)
+			config.LocalJsonConfig.HealthConfig.MinFreeDisk = DefaultMinFreeDisk
+		}
+	}
+
+	gcConfig := &config.LocalJsonConfig.GarbageCollectionConfig
+	for _, setting := range []struct {
+		name     string
+		value    *string
+		fallback string
+	}{
+		{"GarbageCollectionDelay", &gcConfig.Delay, DefaultGCDelay},
+		{"GarbageCollectionInterval", &gcConfig.Interval, DefaultGCInterval},
+		{"ScrubInterval", &gcConfig.ScrubInterval, ""},
+	} {
+		if *setting.value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(*setting.value); err != nil {
+			durationWarning := Warning(fmt.Sprintf("invalid duration %s for %s, using default %q", *setting.value, setting.name, setting.fallback))
+			warnings = append(warnings, durationWarning)
+			*setting.value = setting.fallback
+		}
+	}
+
+	switch config.LocalJsonConfig.VerificationConfig.Mode {
+	case "", VerificationModeEnforce, VerificationModeWarn:
+	default:
+		modeWarning := Warning(fmt.Sprintf("invalid verification mode %s, using default mode %s", config.LocalJsonConfig.VerificationConfig.Mode, VerificationModeEnforce))
+		warnings = append(warnings, modeWarning)
+		config.LocalJsonConfig.VerificationConfig.Mode = VerificationModeEnforce
+	}
+
 	return config, errors, warnings
 }
 
@@ -140,14 +312,22 @@ func InitConfig(configPath string) ([]error, []Warning) {
 	return err, warnings
 }
 
+// UpdateStateAuthConfig persists the credentials in use, replacing the pending ones
 func UpdateStateAuthConfig(name, registry, secret string, state string) error {
 	appConfig.StateConfig.Auth.SourceUsername = name
 	appConfig.StateConfig.Auth.Registry = registry
 	appConfig.StateConfig.Auth.SourcePassword = secret
+	appConfig.StateConfig.PendingAuth = nil
 	appConfig.StateConfig.State = state
 	return WriteConfig(DefaultConfigPath)
 }
 
+// UpdatePendingAuthConfig persists the rotated credentials the registry does not accept yet, nil clears them
+func UpdatePendingAuthConfig(auth *Auth) error {
+	appConfig.StateConfig.PendingAuth = auth
+	return WriteConfig(DefaultConfigPath)
+}
+
 func WriteConfig(configPath string) error {
 	data, err := json.MarshalIndent(appConfig, "", "  ")
 	if err != nil {
@@ -4,6 +4,7 @@ package config
 const ReplicateStateJobName string = "replicate_state"
 const UpdateConfigJobName string = "update_config"
 const ZTRConfigJobName string = "register_satellite"
+const HeartbeatJobName string = "heartbeat"
 
 // The values below contain the default values of the constants used in the satellite. The user is allowed to override them
 // by providing values in the config.json file. These default values will be used if the user does not provide any value or wrong format value
@@ -13,10 +14,67 @@ const ZTRConfigJobName string = "register_satellite"
 const DefaultConfigPath string = "config.json"
 const DefaultZotConfigPath string = "./zot-config.json"
 
+// Default path of the file holding the reconciliation state, used if the satellite brings its own registry.
+// Otherwise the file is kept under the root directory of zot.
+const DefaultStateStorePath string = "./satellite-state.json"
+const StateStoreFileName string = "satellite-state.json"
+
+// Name of the file recording when the images were last replicated or pulled, used to evict the least recently
+// used images when the storage quota is exceeded
+const AccessLogFileName string = "satellite-access.json"
+
+// Name of the request log of the bundled zot registry, read to know when the images were last pulled
+const RegistryLogFileName string = "zot-requests.log"
+
+// Name of the file recording the position up to which the request log of the local registry was read
+const RegistryLogOffsetFileName string = "zot-requests.offset.json"
+
+// Default address of the local API of the satellite, reachable from the host only
+const DefaultAPIAddress string = "127.0.0.1:9090"
+
+// Name of the directory holding the private key of the satellite and the client certificate issued by Ground
+// Control, kept next to the reconciliation state unless configured
+const IdentityDirName string = "identity"
+
 // Below are the default values of the job schedules that would be used if the user does not provide any schedule or
 // if there is any error while parsing the cron expression
 const DefaultFetchConfigFromGroundControlTimePeriod string = "@every 00h00m30s"
 const DefaultZeroTouchRegistrationCronExpr string = "@every 00h00m05s"
 const DefaultFetchAndReplicateStateTimePeriod string = "@every 00h00m10s"
 
+// Default schedule of the heartbeat reporting the status of the satellite to Ground Control
+const DefaultHeartbeatInterval string = "@every 00h00m30s"
+
+// Default interval at which the states are reconciled against the contents of the local registry, a zero
+// duration disables the full resync
+const DefaultFullResyncInterval string = "1h"
+
+// Default garbage collection settings of the bundled zot registry, unreferenced blobs older than the delay are deleted
+// once per interval
+const DefaultGCDelay string = "1h"
+const DefaultGCInterval string = "1h"
+
 const BringOwnRegistry bool = false
+
+// Default number of images that are replicated concurrently, in total and per registry
+const DefaultReplicationMaxConcurrency int = 8
+const DefaultReplicationMaxConcurrencyPerRegistry int = 4
+
+// DefaultSmallTransferThreshold is the largest pull done outside of the transfer windows, enough for manifests
+// and tags moved to images sharing their layers with the ones already replicated
+const DefaultSmallTransferThreshold string = "1MiB"
+
+// Default settings of the layer downloads, the layers are staged in a hidden directory next to the reconciliation
+// state so that it is never mistaken for a repository when the state lives in the registry storage
+const StagingDirName string = ".staging"
+const DefaultDownloadRetries int = 5
+
+// Verification modes, in enforce mode images without a trusted signature are not replicated while in warn mode they are
+// replicated and only reported
+const VerificationModeEnforce string = "enforce"
+const VerificationModeWarn string = "warn"
+
+// Default thresholds of the readiness checks, the satellite is not ready once the disk holding the images has less
+// free space or the states were not replicated for that many intervals
+const DefaultMinFreeDisk string = "1GiB"
+const DefaultMaxMissedSyncs int = 3
@@ -1,5 +1,10 @@
 package config
 
+import (
+	"path/filepath"
+	"time"
+)
+
 func GetLogLevel() string {
 	if appConfig == nil || appConfig.LocalJsonConfig.LogLevel == "" {
 		return "info"
@@ -44,6 +49,15 @@ func GetSourceRegistryURL() string {
 	return appConfig.StateConfig.Auth.Registry
 }
 
+// GetPendingAuth returns a copy of the rotated credentials the registry does not accept yet, nil if there are none
+func GetPendingAuth() *Auth {
+	if appConfig.StateConfig.PendingAuth == nil {
+		return nil
+	}
+	auth := *appConfig.StateConfig.PendingAuth
+	return &auth
+}
+
 func GetState() string {
 	return appConfig.StateConfig.State
 }
@@ -80,6 +94,249 @@ func GetUpdateConfigInterval() string {
 	return appConfig.LocalJsonConfig.UpdateConfigInterval
 }
 
+// GetHeartbeatInterval returns the schedule of the heartbeat sent to Ground Control
+func GetHeartbeatInterval() string {
+	if appConfig.LocalJsonConfig.HeartbeatInterval == "" {
+		return DefaultHeartbeatInterval
+	}
+	return appConfig.LocalJsonConfig.HeartbeatInterval
+}
+
 func GetStateReplicationInterval() string {
 	return appConfig.LocalJsonConfig.StateReplicationInterval
 }
+
+func GetReplicationMaxConcurrency() int {
+	if appConfig.LocalJsonConfig.ReplicationConfig.MaxConcurrency <= 0 {
+		return DefaultReplicationMaxConcurrency
+	}
+	return appConfig.LocalJsonConfig.ReplicationConfig.MaxConcurrency
+}
+
+func GetReplicationMaxConcurrencyPerRegistry() int {
+	if appConfig.LocalJsonConfig.ReplicationConfig.MaxConcurrencyPerRegistry <= 0 {
+		return DefaultReplicationMaxConcurrencyPerRegistry
+	}
+	return appConfig.LocalJsonConfig.ReplicationConfig.MaxConcurrencyPerRegistry
+}
+
+func GetReplicationPlatforms() []string {
+	return appConfig.LocalJsonConfig.ReplicationConfig.Platforms
+}
+
+func ReplicateReferrers() bool {
+	return !appConfig.LocalJsonConfig.ReplicationConfig.SkipReferrers
+}
+
+// GetBandwidthLimit returns the bandwidth shared by all the pulls in bytes per second, 0 if unlimited
+func GetBandwidthLimit() int64 {
+	limit, err := ParseSize(appConfig.LocalJsonConfig.ReplicationConfig.BandwidthLimit)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
+// GetPerTransferBandwidthLimit returns the bandwidth of the pull of a single image in bytes per second, 0 if unlimited
+func GetPerTransferBandwidthLimit() int64 {
+	limit, err := ParseSize(appConfig.LocalJsonConfig.ReplicationConfig.PerTransferBandwidthLimit)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
+func GetTransferWindows() []string {
+	return appConfig.LocalJsonConfig.ReplicationConfig.TransferWindows
+}
+
+// GetSmallTransferThreshold returns the largest pull in bytes still done outside of the transfer windows
+func GetSmallTransferThreshold() int64 {
+	threshold := appConfig.LocalJsonConfig.ReplicationConfig.SmallTransferThreshold
+	if threshold == "" {
+		threshold = DefaultSmallTransferThreshold
+	}
+	size, err := ParseSize(threshold)
+	if err != nil {
+		size, _ = ParseSize(DefaultSmallTransferThreshold)
+	}
+	return size
+}
+
+// GetStagingDir returns the directory the layers are downloaded to before being pushed to the local registry
+func GetStagingDir() string {
+	if appConfig.LocalJsonConfig.ReplicationConfig.StagingDir == "" {
+		return filepath.Join(filepath.Dir(GetStateStorePath()), StagingDirName)
+	}
+	return appConfig.LocalJsonConfig.ReplicationConfig.StagingDir
+}
+
+func GetDownloadRetries() int {
+	if appConfig.LocalJsonConfig.ReplicationConfig.DownloadRetries <= 0 {
+		return DefaultDownloadRetries
+	}
+	return appConfig.LocalJsonConfig.ReplicationConfig.DownloadRetries
+}
+
+func GetCosignPublicKeys() []string {
+	return appConfig.LocalJsonConfig.VerificationConfig.CosignPublicKeys
+}
+
+func GetNotationTrustCertificates() []string {
+	return appConfig.LocalJsonConfig.VerificationConfig.NotationTrustCertificates
+}
+
+func GetStatePublicKey() string {
+	return appConfig.LocalJsonConfig.VerificationConfig.StatePublicKey
+}
+
+// IsVerificationEnabled returns true if any key or certificate is configured to verify the signatures
+func IsVerificationEnabled() bool {
+	return len(GetCosignPublicKeys()) > 0 || len(GetNotationTrustCertificates()) > 0
+}
+
+// EnforceVerification returns true unless the verification mode is warn
+func EnforceVerification() bool {
+	return appConfig.LocalJsonConfig.VerificationConfig.Mode != VerificationModeWarn
+}
+
+func GetStateStorePath() string {
+	if appConfig.LocalJsonConfig.StateStorePath == "" {
+		return DefaultStateStorePath
+	}
+	return appConfig.LocalJsonConfig.StateStorePath
+}
+
+// SetDefaultStateStorePath sets the path of the reconciliation state file unless the user configured one
+func SetDefaultStateStorePath(path string) {
+	if appConfig.LocalJsonConfig.StateStorePath == "" {
+		appConfig.LocalJsonConfig.StateStorePath = path
+	}
+}
+
+// GetFullResyncInterval returns the interval at which the states are reconciled against the local registry
+func GetFullResyncInterval() time.Duration {
+	interval := appConfig.LocalJsonConfig.FullResyncInterval
+	if interval == "" {
+		interval = DefaultFullResyncInterval
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
+
+// GetStorageQuota returns the storage budget of the replicated images in bytes, 0 if the storage is not limited
+func GetStorageQuota() int64 {
+	quota, err := ParseSize(appConfig.LocalJsonConfig.StorageConfig.Quota)
+	if err != nil {
+		return 0
+	}
+	return quota
+}
+
+func GetPinnedImages() []string {
+	return appConfig.LocalJsonConfig.StorageConfig.PinnedImages
+}
+
+// GetIdentityDir returns the directory holding the private key and client certificate of the satellite
+func GetIdentityDir() string {
+	if appConfig.LocalJsonConfig.IdentityDir == "" {
+		return filepath.Join(filepath.Dir(GetStateStorePath()), IdentityDirName)
+	}
+	return appConfig.LocalJsonConfig.IdentityDir
+}
+
+// GetAccessLogPath returns the path of the file recording when the images were last replicated or pulled,
+// kept next to the reconciliation state
+func GetAccessLogPath() string {
+	return filepath.Join(filepath.Dir(GetStateStorePath()), AccessLogFileName)
+}
+
+// GetRegistryLogPath returns the path of the request log of the local registry the pulls are read from, empty
+// for an own registry without a configured log
+func GetRegistryLogPath() string {
+	if appConfig.LocalJsonConfig.StorageConfig.RegistryLog != "" {
+		return appConfig.LocalJsonConfig.StorageConfig.RegistryLog
+	}
+	if GetOwnRegistry() {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(GetStateStorePath()), RegistryLogFileName)
+}
+
+// GetRegistryLogOffsetPath returns the path of the file recording the position up to which the request log of the
+// local registry was read, kept next to the reconciliation state
+func GetRegistryLogOffsetPath() string {
+	return filepath.Join(filepath.Dir(GetStateStorePath()), RegistryLogOffsetFileName)
+}
+
+// SetDefaultRegistryLogPath sets the path of the request log of the local registry unless the user configured one
+func SetDefaultRegistryLogPath(path string) {
+	if appConfig.LocalJsonConfig.StorageConfig.RegistryLog == "" {
+		appConfig.LocalJsonConfig.StorageConfig.RegistryLog = path
+	}
+}
+
+func IsGarbageCollectionEnabled() bool {
+	return !appConfig.LocalJsonConfig.GarbageCollectionConfig.Disabled
+}
+
+// GetGCDelay returns the minimum age of an unreferenced blob before it is deleted
+func GetGCDelay() time.Duration {
+	delay := appConfig.LocalJsonConfig.GarbageCollectionConfig.Delay
+	if delay == "" {
+		delay = DefaultGCDelay
+	}
+	duration, err := time.ParseDuration(delay)
+	if err != nil {
+		duration, _ = time.ParseDuration(DefaultGCDelay)
+	}
+	return duration
+}
+
+func GetGCInterval() string {
+	if appConfig.LocalJsonConfig.GarbageCollectionConfig.Interval == "" {
+		return DefaultGCInterval
+	}
+	return appConfig.LocalJsonConfig.GarbageCollectionConfig.Interval
+}
+
+// GetScrubInterval returns the interval at which the images are scrubbed, empty if the scrub is disabled
+func GetScrubInterval() string {
+	return appConfig.LocalJsonConfig.GarbageCollectionConfig.ScrubInterval
+}
+
+// GetMinFreeDisk returns the free space in bytes required on the disk holding the images
+func GetMinFreeDisk() int64 {
+	minFree := appConfig.LocalJsonConfig.HealthConfig.MinFreeDisk
+	if minFree == "" {
+		minFree = DefaultMinFreeDisk
+	}
+	size, err := ParseSize(minFree)
+	if err != nil {
+		size, _ = ParseSize(DefaultMinFreeDisk)
+	}
+	return size
+}
+
+func GetMaxMissedSyncs() int {
+	if appConfig.LocalJsonConfig.HealthConfig.MaxMissedSyncs <= 0 {
+		return DefaultMaxMissedSyncs
+	}
+	return appConfig.LocalJsonConfig.HealthConfig.MaxMissedSyncs
+}
+
+// GetAPIAddress returns the address the local API of the satellite listens on
+func GetAPIAddress() string {
+	if appConfig.LocalJsonConfig.APIConfig.Address == "" {
+		return DefaultAPIAddress
+	}
+	return appConfig.LocalJsonConfig.APIConfig.Address
+}
+
+// GetAPIToken returns the bearer token required by the POST routes of the local API
+func GetAPIToken() string {
+	return appConfig.LocalJsonConfig.APIConfig.Token
+}
@@ -6,9 +6,29 @@ import (
 	"github.com/container-registry/harbor-satellite/internal/logger"
 )
 
+type Level string
+
+const (
+	InfoLevel    Level = "info"
+	WarningLevel Level = "warning"
+	ErrorLevel   Level = "error"
+)
+
+// Notification is the message sent by the processes through the notifier
+type Notification struct {
+	// Source is the name of the process sending the notification
+	Source string
+	// Level is the severity of the notification
+	Level Level
+	// Message is a short summary of the notification
+	Message string
+	// Details is an optional list of items the notification is about
+	Details []string
+}
+
 type Notifier interface {
 	// Notify sends a notification
-	Notify() error
+	Notify(notification Notification) error
 }
 
 type SimpleNotifier struct {
@@ -21,8 +41,16 @@ func NewSimpleNotifier(ctx context.Context) Notifier {
 	}
 }
 
-func (n *SimpleNotifier) Notify() error {
+// Notify logs the notification with the logger of the context
+func (n *SimpleNotifier) Notify(notification Notification) error {
 	log := logger.FromContext(n.ctx)
-	log.Info().Msg("This is a simple notifier")
+	event := log.Info()
+	switch notification.Level {
+	case WarningLevel:
+		event = log.Warn()
+	case ErrorLevel:
+		event = log.Error()
+	}
+	event.Str("source", notification.Source).Strs("details", notification.Details).Msg(notification.Message)
 	return nil
 }
@@ -0,0 +1,57 @@
+package satellite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// RegistryCheck returns an error if the local registry does not answer on its API endpoint
+func (s *Satellite) RegistryCheck(ctx context.Context) error {
+	var options []name.Option
+	if s.UseUnsecure {
+		options = append(options, name.Insecure)
+	}
+	registry, err := name.NewRegistry(utils.FormatRegistryURL(config.GetRemoteRegistryURL()), options...)
+	if err != nil {
+		return fmt.Errorf("invalid local registry URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", registry.Scheme(), registry.RegistryStr()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("local registry is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	// The registry may require credentials, it is up as long as it answers
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("local registry answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ReplicationCheck returns an error if the states were not replicated within the configured number of intervals
+func (s *Satellite) ReplicationCheck(ctx context.Context) error {
+	s.mu.Lock()
+	process := s.stateProcess
+	s.mu.Unlock()
+	if process == nil {
+		return errors.New("satellite is not running yet")
+	}
+	return process.FreshnessCheck(ctx, config.GetMaxMissedSyncs())
+}
+
+// ZTRCheck returns an error until the zero touch registration with Ground Control is completed
+func ZTRCheck(ctx context.Context) error {
+	if !utils.IsZTRDone() {
+		return errors.New("zero touch registration is not completed")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package satellite
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/scheduler"
+	"github.com/container-registry/harbor-satellite/internal/state"
+	"github.com/container-registry/harbor-satellite/internal/storage"
+	"github.com/container-registry/harbor-satellite/internal/version"
+)
+
+// Heartbeat returns the status reported to Ground Control: the version of the satellite, the states it applied,
+// the error of its most recent failure and the space left on the disk holding the images
+func (s *Satellite) Heartbeat(ctx context.Context) state.Heartbeat {
+	log := logger.FromContext(ctx)
+	heartbeat := state.Heartbeat{
+		Version:       version.Version,
+		GitCommit:     version.GitCommit,
+		System:        version.System,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		States:        []state.HeartbeatState{},
+	}
+
+	var lastErrorAt time.Time
+	recordError := func(at time.Time, err string) {
+		if err != "" && at.After(lastErrorAt) {
+			lastErrorAt = at
+			heartbeat.LastError = err
+		}
+	}
+	if status := s.Status(); status != nil {
+		for _, group := range status.Groups {
+			heartbeat.States = append(heartbeat.States, state.HeartbeatState{
+				URL:      group.URL,
+				Version:  group.Version,
+				Digest:   group.Digest,
+				LastSync: group.LastSync,
+			})
+			if group.LastSync != nil {
+				recordError(group.LastSync.Time, group.LastSync.Error)
+			}
+		}
+	}
+	if scheduler, ok := ctx.Value(s.schedulerKey).(scheduler.Scheduler); ok {
+		for _, process := range scheduler.Processes() {
+			if process.LastRun != nil {
+				recordError(process.LastRun.StartedAt, process.LastRun.Error)
+			}
+		}
+	}
+
+	usage, err := storage.Usage(filepath.Dir(config.GetStateStorePath()))
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to read the disk usage for the heartbeat")
+	} else {
+		heartbeat.DiskTotalBytes = usage.TotalBytes
+		heartbeat.DiskFreeBytes = usage.FreeBytes
+	}
+	return heartbeat
+}
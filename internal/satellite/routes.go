@@ -0,0 +1,252 @@
+package satellite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/scheduler"
+	"github.com/container-registry/harbor-satellite/internal/server"
+	"github.com/container-registry/harbor-satellite/internal/state"
+	"github.com/container-registry/harbor-satellite/internal/storage"
+)
+
+// PlanRegistrar exposes the changes the next reconciliation would apply to the local registry
+type PlanRegistrar struct {
+	satellite *Satellite
+	ctx       context.Context
+}
+
+func NewPlanRegistrar(ctx context.Context, satellite *Satellite) *PlanRegistrar {
+	return &PlanRegistrar{
+		satellite: satellite,
+		ctx:       ctx,
+	}
+}
+
+func (p *PlanRegistrar) RegisterRoutes(router server.Router) {
+	satelliteGroup := router.Group("/satellite")
+	satelliteGroup.HandleFunc("/plan", p.planHandler)
+}
+
+// planHandler computes the plan, the satellite state and the group states are fetched on each request
+func (p *PlanRegistrar) planHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	log := logger.FromContext(p.ctx)
+	ctx := context.WithValue(r.Context(), logger.LoggerKey, log)
+	plan, err := p.satellite.Plan(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Error computing the reconciliation plan")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// GarbageCollectionRegistrar exposes the outcome of the garbage collection and scrub zot runs on the bundled registry
+type GarbageCollectionRegistrar struct {
+	maintenance *storage.Maintenance
+	ctx         context.Context
+}
+
+func NewGarbageCollectionRegistrar(ctx context.Context, maintenance *storage.Maintenance) *GarbageCollectionRegistrar {
+	return &GarbageCollectionRegistrar{
+		maintenance: maintenance,
+		ctx:         ctx,
+	}
+}
+
+func (g *GarbageCollectionRegistrar) RegisterRoutes(router server.Router) {
+	satelliteGroup := router.Group("/satellite")
+	satelliteGroup.HandleFunc("/gc", g.gcHandler)
+	satelliteGroup.HandleFunc("/scrub", g.scrubHandler)
+}
+
+// gcHandler reports the garbage collections zot ran since the satellite started
+func (g *GarbageCollectionRegistrar) gcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	log := logger.FromContext(g.ctx)
+	status, err := g.maintenance.GC()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading the garbage collections of the local registry")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// scrubHandler reports the images zot found missing or corrupted blobs for
+func (g *GarbageCollectionRegistrar) scrubHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	log := logger.FromContext(g.ctx)
+	status, err := g.maintenance.Scrub()
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading the scrubs of the local registry")
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(status.Corrupted) > 0 {
+		log.Warn().Strs("images", status.Corrupted).Msg("Scrub found missing or corrupted blobs")
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// StatusRegistrar exposes the status of the states and of the scheduled processes, and lets an operator
+// trigger a sync or pause and resume the processes
+type StatusRegistrar struct {
+	satellite *Satellite
+	scheduler scheduler.Scheduler
+	ctx       context.Context
+}
+
+// SatelliteStatus is the status of the satellite as reported by the API
+type SatelliteStatus struct {
+	// State is nil until the satellite is running
+	State     *state.StateStatus        `json:"state"`
+	Processes []scheduler.ProcessStatus `json:"processes"`
+}
+
+func NewStatusRegistrar(ctx context.Context, satellite *Satellite, scheduler scheduler.Scheduler) *StatusRegistrar {
+	return &StatusRegistrar{
+		satellite: satellite,
+		scheduler: scheduler,
+		ctx:       ctx,
+	}
+}
+
+func (s *StatusRegistrar) RegisterRoutes(router server.Router) {
+	satelliteGroup := router.Group("/satellite")
+	satelliteGroup.HandleFunc("/status", s.statusHandler)
+	satelliteGroup.HandleFunc("/states", s.statesHandler)
+	satelliteGroup.HandleFunc("/processes", s.processesHandler)
+	satelliteGroup.HandleFunc("/sync", s.syncHandler)
+	satelliteGroup.HandleFunc("/processes/{name}/trigger", s.triggerHandler)
+	satelliteGroup.HandleFunc("/processes/{name}/pause", s.pauseHandler)
+	satelliteGroup.HandleFunc("/processes/{name}/resume", s.resumeHandler)
+}
+
+func (s *StatusRegistrar) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, SatelliteStatus{
+		State:     s.satellite.Status(),
+		Processes: s.scheduler.Processes(),
+	})
+}
+
+// statesHandler returns the states applied and the outcome of the last sync of each group
+func (s *StatusRegistrar) statesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	status := s.satellite.Status()
+	if status == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "satellite is not running yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *StatusRegistrar) processesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.scheduler.Processes())
+}
+
+// syncHandler replicates the states right away instead of waiting for the next scheduled run
+func (s *StatusRegistrar) syncHandler(w http.ResponseWriter, r *http.Request) {
+	s.trigger(w, r, config.ReplicateStateJobName)
+}
+
+func (s *StatusRegistrar) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	s.trigger(w, r, r.PathValue("name"))
+}
+
+// trigger runs the process in the background, the outcome of the run is reported by the processes endpoint
+func (s *StatusRegistrar) trigger(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	log := logger.FromContext(s.ctx)
+	if err := s.scheduler.TriggerProcess(name); err != nil {
+		log.Warn().Err(err).Msgf("Error triggering process %s", name)
+		writeJSONError(w, schedulerErrorCode(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": fmt.Sprintf("process %s triggered", name)})
+}
+
+func (s *StatusRegistrar) pauseHandler(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r, true)
+}
+
+func (s *StatusRegistrar) resumeHandler(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r, false)
+}
+
+func (s *StatusRegistrar) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.PathValue("name")
+	var err error
+	if paused {
+		err = s.scheduler.PauseProcess(name)
+	} else {
+		err = s.scheduler.ResumeProcess(name)
+	}
+	if err != nil {
+		writeJSONError(w, schedulerErrorCode(err), err.Error())
+		return
+	}
+	for _, process := range s.scheduler.Processes() {
+		if process.Name == name {
+			writeJSON(w, http.StatusOK, process)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, fmt.Sprintf("process %s not found", name))
+}
+
+func schedulerErrorCode(err error) int {
+	switch {
+	case errors.Is(err, scheduler.ErrProcessNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, scheduler.ErrProcessRunning):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
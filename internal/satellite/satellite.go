@@ -2,13 +2,17 @@ package satellite
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
 	"github.com/container-registry/harbor-satellite/internal/logger"
 	"github.com/container-registry/harbor-satellite/internal/notifier"
 	"github.com/container-registry/harbor-satellite/internal/scheduler"
 	"github.com/container-registry/harbor-satellite/internal/state"
+	"github.com/container-registry/harbor-satellite/internal/storage"
 	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/container-registry/harbor-satellite/internal/verifier"
 )
 
 type Satellite struct {
@@ -17,33 +21,51 @@ type Satellite struct {
 	SourcesRegistryConfig state.RegistryConfig
 	UseUnsecure           bool
 	state                 string
+	// registryLog is the log of the local registry the pulls are read from, nil if the pulls are not tracked
+	registryLog *storage.RegistryLog
+	// stateProcess is the process replicating the states, set once the satellite is running
+	stateProcess *state.FetchAndReplicateStateProcess
+	// startedAt is the time the satellite was created, reported as its uptime
+	startedAt time.Time
+	mu        sync.Mutex
 }
 
-func NewSatellite(ctx context.Context, schedulerKey scheduler.SchedulerKey, localRegistryConfig, sourceRegistryConfig state.RegistryConfig, useUnsecure bool, state string) *Satellite {
+func NewSatellite(ctx context.Context, schedulerKey scheduler.SchedulerKey, localRegistryConfig, sourceRegistryConfig state.RegistryConfig, useUnsecure bool, state string, registryLog *storage.RegistryLog) *Satellite {
 	return &Satellite{
 		schedulerKey:          schedulerKey,
 		LocalRegistryConfig:   localRegistryConfig,
 		SourcesRegistryConfig: sourceRegistryConfig,
 		UseUnsecure:           useUnsecure,
 		state:                 state,
+		registryLog:           registryLog,
+		startedAt:             time.Now(),
 	}
 }
 
 func (s *Satellite) Run(ctx context.Context) error {
 	log := logger.FromContext(ctx)
 	log.Info().Msg("Starting Satellite")
-	replicateStateCron := config.GetStateReplicationInterval()
 	updateConfigCron := config.GetUpdateConfigInterval()
 	ztrCron := config.GetRegistrationInterval()
 	// Get the scheduler from the context
 	scheduler := ctx.Value(s.schedulerKey).(scheduler.Scheduler)
-	// Create a simple notifier and add it to the process
-	notifier := notifier.NewSimpleNotifier(ctx)
 	// Creating a process to fetch and replicate the state
-	fetchAndReplicateStateProcess := state.NewFetchAndReplicateStateProcess(replicateStateCron, notifier, s.SourcesRegistryConfig, s.LocalRegistryConfig, s.UseUnsecure, config.GetState())
-	configFetchProcess := state.NewFetchConfigFromGroundControlProcess(updateConfigCron, "", "")
+	fetchAndReplicateStateProcess, err := s.newFetchAndReplicateStateProcess(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.stateProcess = fetchAndReplicateStateProcess
+	s.mu.Unlock()
+	configFetchProcess := state.NewFetchConfigFromGroundControlProcess(updateConfigCron, config.GetToken(), config.GetGroundControlURL())
 	ztrProcess := state.NewZtrProcess(ztrCron)
-	err := scheduler.Schedule(configFetchProcess)
+	heartbeatProcess := state.NewHeartbeatProcess(config.GetHeartbeatInterval(), s.Heartbeat)
+	err = scheduler.Schedule(configFetchProcess)
+	if err != nil {
+		log.Error().Err(err).Msg("Error scheduling process")
+		return err
+	}
+	err = scheduler.Schedule(heartbeatProcess)
 	if err != nil {
 		log.Error().Err(err).Msg("Error scheduling process")
 		return err
@@ -69,3 +91,80 @@ func (s *Satellite) Run(ctx context.Context) error {
 
 	return nil
 }
+
+// Plan returns the changes the next reconciliation would apply to the local registry. If the satellite
+// is not running, e.g. when invoked from the CLI, a standalone process is created from the config.
+func (s *Satellite) Plan(ctx context.Context) (*state.Plan, error) {
+	s.mu.Lock()
+	process := s.stateProcess
+	s.mu.Unlock()
+	if process == nil {
+		var err error
+		process, err = s.newFetchAndReplicateStateProcess(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return process.Plan(ctx)
+}
+
+// Status returns the reconciliation status of the states, nil until the satellite is running
+func (s *Satellite) Status() *state.StateStatus {
+	s.mu.Lock()
+	process := s.stateProcess
+	s.mu.Unlock()
+	if process == nil {
+		return nil
+	}
+	status := process.Status()
+	return &status
+}
+
+// RunExclusive runs fn while the local registry is not being reconciled
+func (s *Satellite) RunExclusive(fn func() error) error {
+	s.mu.Lock()
+	process := s.stateProcess
+	s.mu.Unlock()
+	if process == nil {
+		return fn()
+	}
+	return process.RunExclusive(fn)
+}
+
+// newFetchAndReplicateStateProcess creates the process replicating the states from the config and
+// restores the reconciliation state saved by the previous run
+func (s *Satellite) newFetchAndReplicateStateProcess(ctx context.Context) (*state.FetchAndReplicateStateProcess, error) {
+	log := logger.FromContext(ctx)
+	// Create a simple notifier and add it to the process
+	notifier := notifier.NewSimpleNotifier(ctx)
+	replicatorConfig, err := state.NewReplicatorConfig(config.GetReplicationMaxConcurrency(), config.GetReplicationMaxConcurrencyPerRegistry(), config.GetReplicationPlatforms(), config.ReplicateReferrers())
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating replicator config")
+		return nil, err
+	}
+	bandwidthConfig, err := state.NewBandwidthConfig(config.GetBandwidthLimit(), config.GetPerTransferBandwidthLimit(), config.GetTransferWindows(), config.GetSmallTransferThreshold())
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating bandwidth config")
+		return nil, err
+	}
+	replicatorConfig.Bandwidth = bandwidthConfig
+	replicatorConfig.StagingDir = config.GetStagingDir()
+	replicatorConfig.DownloadRetries = config.GetDownloadRetries()
+	verificationConfig := state.NewVerificationConfig(nil, config.EnforceVerification())
+	if config.IsVerificationEnabled() {
+		signatureVerifier, err := verifier.NewSignatureVerifier(config.GetCosignPublicKeys(), config.GetNotationTrustCertificates())
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating signature verifier")
+			return nil, err
+		}
+		verificationConfig.Verifier = signatureVerifier
+	} else {
+		log.Warn().Msg("No signature verification key configured, images are replicated without verifying their signatures")
+	}
+	quotaConfig := state.NewQuotaConfig(config.GetStorageQuota(), config.GetPinnedImages(), state.NewFileAccessLog(config.GetAccessLogPath(), s.registryLog))
+	process := state.NewFetchAndReplicateStateProcess(config.GetStateReplicationInterval(), notifier, s.SourcesRegistryConfig, s.LocalRegistryConfig, s.UseUnsecure, config.GetState(), replicatorConfig, verificationConfig, state.NewFileStateStore(config.GetStateStorePath()), quotaConfig)
+	if err := process.RestoreState(ctx); err != nil {
+		log.Warn().Err(err).Msg("Error restoring the reconciliation state, the complete state would be replicated")
+	}
+	return process, nil
+}
@@ -0,0 +1,29 @@
+package scheduler
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Outcomes of a process run
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+var (
+	processRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "satellite",
+		Subsystem: "process",
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the runs of the scheduled processes",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900, 1800, 3600},
+	}, []string{"process", "outcome"})
+	processRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "process",
+		Name:      "runs_total",
+		Help:      "Number of runs of the scheduled processes",
+	}, []string{"process", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(processRunDuration, processRuns)
+}
@@ -2,8 +2,11 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
@@ -24,6 +27,29 @@ type StopAllProcessesPayload struct {
 	Message string
 }
 
+var (
+	ErrProcessNotFound = errors.New("process not found")
+	ErrProcessRunning  = errors.New("process is already running")
+)
+
+// ProcessStatus is the scheduling status of a process
+type ProcessStatus struct {
+	Name     string `json:"name"`
+	CronExpr string `json:"cron_expr"`
+	Running  bool   `json:"running"`
+	// Paused processes are not run on their schedule, they can still be triggered
+	Paused  bool        `json:"paused"`
+	NextRun *time.Time  `json:"next_run,omitempty"`
+	LastRun *ProcessRun `json:"last_run,omitempty"`
+}
+
+// ProcessRun is the outcome of the last run of a process
+type ProcessRun struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
 type Scheduler interface {
 	// GetSchedulerKey would return the key of the scheduler which is unique and for a particular scheduler
 	// and is used to get the scheduler from the context
@@ -36,6 +62,16 @@ type Scheduler interface {
 	Stop()
 	// Listen for events from the processes
 	ListenForProcessEvent()
+	// Processes returns the status of the scheduled processes
+	Processes() []ProcessStatus
+	// TriggerProcess runs the process right away in the background, unless it is already running
+	TriggerProcess(name string) error
+	// PauseProcess stops running the process on its schedule
+	PauseProcess(name string) error
+	// ResumeProcess runs the process on its schedule again
+	ResumeProcess(name string) error
+	// HealthCheck returns an error if the scheduler no longer runs the processes
+	HealthCheck(ctx context.Context) error
 }
 
 type BasicScheduler struct {
@@ -47,6 +83,12 @@ type BasicScheduler struct {
 	processes map[string]Process
 	// locks is a map of locks for each process which is used to schedule if the process are interdependent
 	locks map[string]*sync.Mutex
+	// running is the set of processes currently running
+	running map[string]bool
+	// paused is the set of processes not run on their schedule
+	paused map[string]bool
+	// lastRuns is a map of process name to the outcome of its last run
+	lastRuns map[string]ProcessRun
 	// stopped is a flag to check if the scheduler is stopped
 	stopped bool
 	// counter is the counter for the unique ID of the process
@@ -67,6 +109,9 @@ func NewBasicScheduler(ctx context.Context, logger *zerolog.Logger) Scheduler {
 		cron:        cron.New(),
 		processes:   make(map[string]Process),
 		locks:       make(map[string]*sync.Mutex),
+		running:     make(map[string]bool),
+		paused:      make(map[string]bool),
+		lastRuns:    make(map[string]ProcessRun),
 		mu:          sync.Mutex{},
 		name:        BasicSchedulerKey,
 		ctx:         ctx,
@@ -91,10 +136,14 @@ func (s *BasicScheduler) Schedule(process Process) error {
 	process.AddEventBroker(s.EventBroker, s.ctx)
 	// Add the process to the scheduler
 	cronEntryId, err := s.cron.AddFunc(process.GetCronExpr(), func() {
-		if process.IsRunning() {
+		if process.IsRunning() || s.isPaused(process.GetName()) {
 			return
 		}
 		err := s.executeProcess(process)
+		if errors.Is(err, ErrProcessRunning) {
+			s.logger.Debug().Msgf("Process %s is still running, skipping this run", process.GetName())
+			return
+		}
 		if err != nil {
 			s.logger.Error().Err(err).Msgf("Error executing process %s", process.GetName())
 		}
@@ -139,8 +188,130 @@ func (s *BasicScheduler) executeProcess(process Process) error {
 	if s.stopped {
 		return fmt.Errorf("scheduler is stopped")
 	}
+	if !s.markRunning(process.GetName()) {
+		return ErrProcessRunning
+	}
+	return s.runProcess(process)
+}
+
+// runProcess executes a process already marked as running and records the outcome of the run
+func (s *BasicScheduler) runProcess(process Process) error {
 	// Execute the process
-	return process.Execute(s.ctx)
+	start := time.Now()
+	err := process.Execute(s.ctx)
+	duration := time.Since(start)
+	outcome := outcomeSuccess
+	run := ProcessRun{StartedAt: start, Duration: duration.String()}
+	if err != nil {
+		outcome = outcomeFailure
+		run.Error = err.Error()
+	}
+	processRunDuration.WithLabelValues(process.GetName(), outcome).Observe(duration.Seconds())
+	processRuns.WithLabelValues(process.GetName(), outcome).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRuns[process.GetName()] = run
+	delete(s.running, process.GetName())
+	return err
+}
+
+// markRunning marks the process as running, it returns false if the process is already running
+func (s *BasicScheduler) markRunning(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[name] {
+		return false
+	}
+	s.running[name] = true
+	return true
+}
+
+func (s *BasicScheduler) isPaused(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused[name]
+}
+
+func (s *BasicScheduler) Processes() []ProcessStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]ProcessStatus, 0, len(s.processes))
+	for name, process := range s.processes {
+		status := ProcessStatus{
+			Name:     name,
+			CronExpr: process.GetCronExpr(),
+			Running:  s.running[name] || process.IsRunning(),
+			Paused:   s.paused[name],
+		}
+		if entry := s.cron.Entry(process.GetID()); entry.Valid() && !entry.Next.IsZero() && !status.Paused {
+			next := entry.Next
+			status.NextRun = &next
+		}
+		if run, ok := s.lastRuns[name]; ok {
+			status.LastRun = &run
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func (s *BasicScheduler) TriggerProcess(name string) error {
+	s.mu.Lock()
+	process, ok := s.processes[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrProcessNotFound, name)
+	}
+	if s.stopped {
+		return fmt.Errorf("scheduler is stopped")
+	}
+	if process.IsRunning() || !s.markRunning(name) {
+		return fmt.Errorf("%w: %s", ErrProcessRunning, name)
+	}
+	s.logger.Info().Msgf("Triggering process %s", name)
+	go func() {
+		if err := s.runProcess(process); err != nil {
+			s.logger.Error().Err(err).Msgf("Error executing triggered process %s", name)
+		}
+	}()
+	return nil
+}
+
+func (s *BasicScheduler) HealthCheck(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return fmt.Errorf("scheduler is stopped")
+	}
+	return nil
+}
+
+func (s *BasicScheduler) PauseProcess(name string) error {
+	return s.setPaused(name, true)
+}
+
+func (s *BasicScheduler) ResumeProcess(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *BasicScheduler) setPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.processes[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrProcessNotFound, name)
+	}
+	if paused {
+		s.paused[name] = true
+		s.logger.Info().Msgf("Process %s paused", name)
+	} else {
+		delete(s.paused, name)
+		s.logger.Info().Msgf("Process %s resumed", name)
+	}
+	return nil
 }
 
 func (s *BasicScheduler) ListenForProcessEvent() {
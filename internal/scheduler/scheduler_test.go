@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingProcess runs until release is closed
+type blockingProcess struct {
+	id      cron.EntryID
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProcess) Execute(ctx context.Context) error {
+	p.started <- struct{}{}
+	<-p.release
+	return errors.New("run failed")
+}
+
+func (p *blockingProcess) GetID() cron.EntryID                                          { return p.id }
+func (p *blockingProcess) SetID(id cron.EntryID)                                        { p.id = id }
+func (p *blockingProcess) GetName() string                                              { return "blocking" }
+func (p *blockingProcess) GetCronExpr() string                                          { return "@every 1h" }
+func (p *blockingProcess) IsRunning() bool                                              { return false }
+func (p *blockingProcess) CanExecute(ctx context.Context) (bool, string)                { return true, "" }
+func (p *blockingProcess) AddEventBroker(eventBroker *EventBroker, ctx context.Context) {}
+
+func TestTriggerAndPauseProcess(t *testing.T) {
+	nop := zerolog.Nop()
+	s := NewBasicScheduler(context.Background(), &nop)
+	process := &blockingProcess{started: make(chan struct{}, 2), release: make(chan struct{})}
+	require.NoError(t, s.Schedule(process))
+
+	// The process runs once at startup, it can not be triggered while running
+	<-process.started
+	assert.ErrorIs(t, s.TriggerProcess("blocking"), ErrProcessRunning)
+	assert.ErrorIs(t, s.TriggerProcess("unknown"), ErrProcessNotFound)
+	statuses := s.Processes()
+	require.Len(t, statuses, 1)
+	assert.True(t, statuses[0].Running)
+	assert.Nil(t, statuses[0].LastRun)
+
+	close(process.release)
+	require.Eventually(t, func() bool {
+		return !s.Processes()[0].Running
+	}, time.Second, 10*time.Millisecond)
+	status := s.Processes()[0]
+	require.NotNil(t, status.LastRun)
+	assert.Equal(t, "run failed", status.LastRun.Error)
+
+	require.NoError(t, s.PauseProcess("blocking"))
+	assert.True(t, s.Processes()[0].Paused)
+	assert.Nil(t, s.Processes()[0].NextRun)
+	// Paused processes can still be triggered
+	require.NoError(t, s.TriggerProcess("blocking"))
+	<-process.started
+	require.NoError(t, s.ResumeProcess("blocking"))
+	assert.False(t, s.Processes()[0].Paused)
+	assert.ErrorIs(t, s.PauseProcess("unknown"), ErrProcessNotFound)
+}
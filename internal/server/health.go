@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout bounds the time a single check may take
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// HealthCheck reports the condition of a subsystem, a nil error means the subsystem is healthy
+type HealthCheck func(ctx context.Context) error
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport is the body returned by the health endpoints
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthRegistrar serves /healthz and /readyz from the checks contributed by the subsystems. The satellite is
+// alive while all the liveness checks pass and ready to serve images while all the readiness checks pass.
+type HealthRegistrar struct {
+	liveness  []namedCheck
+	readiness []namedCheck
+	timeout   time.Duration
+	mu        sync.RWMutex
+}
+
+func NewHealthRegistrar() *HealthRegistrar {
+	return &HealthRegistrar{timeout: DefaultHealthCheckTimeout}
+}
+
+// AddLivenessCheck adds a check to /healthz, a failing liveness check means the satellite should be restarted
+func (h *HealthRegistrar) AddLivenessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds a check to /readyz, a failing readiness check means the satellite should not receive traffic
+func (h *HealthRegistrar) AddReadinessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+func (h *HealthRegistrar) RegisterRoutes(router Router) {
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.checks(false))
+	})
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, h.checks(true))
+	})
+}
+
+func (h *HealthRegistrar) checks(readiness bool) []namedCheck {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if readiness {
+		return append([]namedCheck(nil), h.readiness...)
+	}
+	return append([]namedCheck(nil), h.liveness...)
+}
+
+// serve runs the checks concurrently and answers 503 if any of them fails
+func (h *HealthRegistrar) serve(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for i, c := range checks {
+		if results[i] != nil {
+			report.Status = "failed"
+			report.Checks[c.name] = CheckResult{Status: "failed", Error: results[i].Error()}
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		report.Checks[c.name] = CheckResult{Status: "ok"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthRegistrar(t *testing.T) {
+	router := NewDefaultRouter("")
+	health := NewHealthRegistrar()
+	health.AddLivenessCheck("scheduler", func(ctx context.Context) error { return nil })
+	health.AddReadinessCheck("scheduler", func(ctx context.Context) error { return nil })
+	health.AddReadinessCheck("ztr", func(ctx context.Context) error { return errors.New("not registered") })
+	health.RegisterRoutes(router)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var report HealthReport
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	assert.Equal(t, "failed", report.Status)
+	assert.Equal(t, CheckResult{Status: "ok"}, report.Checks["scheduler"])
+	assert.Equal(t, CheckResult{Status: "failed", Error: "not registered"}, report.Checks["ztr"])
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"crypto/subtle"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -27,3 +29,27 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		)
 	})
 }
+
+// TokenAuthMiddleware requires the bearer token on the requests other than GET and HEAD, as these change the
+// state of the satellite. They are refused if the token is empty.
+func TokenAuthMiddleware(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if token == "" {
+				http.Error(w, "no API token is configured", http.StatusForbidden)
+				return
+			}
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "invalid or missing API token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenAuthMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		token         string
+		method        string
+		authorization string
+		code          int
+	}{
+		{name: "read without token", token: "secret", method: http.MethodGet, code: http.StatusOK},
+		{name: "post with token", token: "secret", method: http.MethodPost, authorization: "Bearer secret", code: http.StatusOK},
+		{name: "post without token", token: "secret", method: http.MethodPost, code: http.StatusUnauthorized},
+		{name: "post with wrong token", token: "secret", method: http.MethodPost, authorization: "Bearer other", code: http.StatusUnauthorized},
+		{name: "post without configured token", method: http.MethodPost, authorization: "Bearer ", code: http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := NewDefaultRouter("")
+			router.Use(TokenAuthMiddleware(tc.token))
+			router.Group("/satellite").HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tc.method, "/satellite/sync", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+		})
+	}
+}
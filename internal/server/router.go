@@ -44,8 +44,13 @@ func (r *DefaultRouter) Handle(pattern string, handler http.Handler) {
 	r.mux.Handle(pattern, handler)
 }
 
+// ServeHTTP dispatches the request to the mux through the middleware of the router
 func (r *DefaultRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.mux.ServeHTTP(w, req)
+	var handler http.Handler = r.mux
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	handler.ServeHTTP(w, req)
 }
 
 func (dr *DefaultRouter) HandleFunc(pattern string, handlerFunc func(http.ResponseWriter, *http.Request)) {
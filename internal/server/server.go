@@ -23,13 +23,14 @@ type App struct {
 	Logger     *zerolog.Logger
 }
 
-func NewApp(router Router, ctx context.Context, logger *zerolog.Logger, registrars ...RouteRegistrar) *App {
+// NewApp returns the app serving the routes of the registrars on the address
+func NewApp(router Router, ctx context.Context, logger *zerolog.Logger, addr string, registrars ...RouteRegistrar) *App {
 	return &App{
 		router:     router,
 		registrars: registrars,
 		ctx:        ctx,
 		Logger:     logger,
-		server:     &http.Server{Addr: ":9090", Handler: router},
+		server:     &http.Server{Addr: addr, Handler: router},
 	}
 }
 
@@ -52,7 +53,7 @@ func (a *App) Shutdown(ctx context.Context) error {
 
 func (a *App) SetupServer(g *errgroup.Group) {
 	g.Go(func() error {
-		a.Logger.Info().Msg("Starting server on :9090")
+		a.Logger.Info().Msgf("Starting server on %s", a.server.Addr)
 		if err := a.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
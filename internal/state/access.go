@@ -0,0 +1,207 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/storage"
+)
+
+// satelliteUserAgent identifies the requests of the satellite to the registries, so that its own reads of the
+// local registry are not taken for pulls
+const satelliteUserAgent = "harbor-satellite"
+
+// AccessTracker records when the entities of the local registry were last used, so that the least recently
+// used entities are evicted first when the storage quota is exceeded
+type AccessTracker interface {
+	// LastAccess returns the last access time of the entities keyed by entityKey, entities never accessed are omitted
+	LastAccess(entities []Entity) (map[string]time.Time, error)
+	// Record marks the entities as accessed now
+	Record(entities []Entity) error
+	// Forget drops the entities removed from the local registry
+	Forget(entities []Entity) error
+}
+
+// FileAccessLog is an AccessTracker keeping the access times in a JSON file. The entities are marked as
+// accessed when they are replicated and whenever the local registry serves their manifest to a client, as read
+// from the request log of the registry.
+type FileAccessLog struct {
+	path string
+	// registryLog is the log of the local registry the pulls are read from, pulls are not tracked if nil
+	registryLog *storage.RegistryLog
+	mu          sync.Mutex
+	accesses    map[string]time.Time
+}
+
+func NewFileAccessLog(path string, registryLog *storage.RegistryLog) *FileAccessLog {
+	l := &FileAccessLog{path: path, registryLog: registryLog}
+	if registryLog != nil {
+		registryLog.Subscribe(l.recordPulls)
+	}
+	return l
+}
+
+func (l *FileAccessLog) LastAccess(entities []Entity) (map[string]time.Time, error) {
+	// The pulls are recorded by recordPulls, which takes the lock
+	if l.registryLog != nil {
+		if err := l.registryLog.Read(); err != nil {
+			return nil, err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	accesses := make(map[string]time.Time)
+	for _, entity := range entities {
+		key := entityKey(entity)
+		// A tagged entity is also pulled by the digest of its manifest
+		for _, accessKey := range accessKeys(entity) {
+			if accessed, ok := l.accesses[accessKey]; ok && accessed.After(accesses[key]) {
+				accesses[key] = accessed
+			}
+		}
+	}
+	return accesses, nil
+}
+
+func (l *FileAccessLog) Record(entities []Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, entity := range entities {
+		l.accesses[entityKey(entity)] = now
+	}
+	return l.save()
+}
+
+func (l *FileAccessLog) Forget(entities []Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	// The pulls by digest are kept, the digest may still be held under another tag
+	for _, entity := range entities {
+		delete(l.accesses, entityKey(entity))
+	}
+	return l.save()
+}
+
+// load reads the access log on first use, a missing file is an empty log
+func (l *FileAccessLog) load() error {
+	if l.accesses != nil {
+		return nil
+	}
+	accesses := make(map[string]time.Time)
+	data, err := os.ReadFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read access log %s: %w", l.path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &accesses); err != nil {
+			return fmt.Errorf("failed to parse access log %s: %w", l.path, err)
+		}
+	}
+	l.accesses = accesses
+	return nil
+}
+
+func (l *FileAccessLog) save() error {
+	data, err := json.MarshalIndent(l.accesses, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(l.path, data); err != nil {
+		return fmt.Errorf("failed to write access log: %w", err)
+	}
+	return nil
+}
+
+// accessKeys returns the keys the accesses of the entity are recorded under, its entityKey and the digests it is
+// held under in the local registry
+func accessKeys(entity Entity) []string {
+	keys := []string{entityKey(entity)}
+	for _, digest := range []string{entity.Digest, entity.LocalDigest} {
+		if digest != "" && !entity.IsUntagged() {
+			keys = append(keys, entity.Repository+"/"+entity.Name+"@"+digest)
+		}
+	}
+	return keys
+}
+
+// registryRequest is a request logged by the local registry
+type registryRequest struct {
+	Message    string              `json:"message"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	StatusCode int                 `json:"statusCode"`
+	Time       time.Time           `json:"time"`
+	Headers    map[string][]string `json:"headers"`
+}
+
+// recordPulls records the manifests served by the local registry in the lines read from its log
+func (l *FileAccessLog) recordPulls(lines [][]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	pulled := false
+	for _, line := range lines {
+		key, accessed, ok := parsePull(line)
+		if ok && accessed.After(l.accesses[key]) {
+			l.accesses[key] = accessed
+			pulled = true
+		}
+	}
+	if !pulled {
+		return nil
+	}
+	return l.save()
+}
+
+// parsePull returns the access key and time of a line of the registry log serving a manifest to a client other
+// than the satellite, ok is false for any other line
+func parsePull(line []byte) (key string, accessed time.Time, ok bool) {
+	var request registryRequest
+	if err := json.Unmarshal(line, &request); err != nil || request.Message != "HTTP API" {
+		return "", time.Time{}, false
+	}
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return "", time.Time{}, false
+	}
+	if request.StatusCode < 200 || request.StatusCode >= 300 {
+		return "", time.Time{}, false
+	}
+	if strings.Contains(http.Header(request.Headers).Get("User-Agent"), satelliteUserAgent) {
+		return "", time.Time{}, false
+	}
+
+	// The path is /v2/<repository>/manifests/<tag or digest>
+	path, _, _ := strings.Cut(request.Path, "?")
+	repository, reference, found := strings.Cut(strings.TrimPrefix(path, "/v2/"), "/manifests/")
+	if !found || !strings.HasPrefix(path, "/v2/") || repository == "" || reference == "" {
+		return "", time.Time{}, false
+	}
+	if strings.Contains(reference, ":") {
+		return repository + "@" + reference, request.Time, true
+	}
+	return repository + "|" + reference, request.Time, true
+}
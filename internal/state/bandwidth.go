@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/rs/zerolog"
+)
+
+// throttledChunkSize is the largest read accounted at once, so that the transfers are throttled smoothly
+const throttledChunkSize = 32 * 1024
+
+// BandwidthConfig limits the bandwidth used to pull the images from the source registry and the time of day
+// at which large images are pulled
+type BandwidthConfig struct {
+	// Limit is the bandwidth in bytes per second shared by all the transfers, unlimited if 0
+	Limit int64
+	// PerTransferLimit is the bandwidth in bytes per second of the transfer of a single entity, unlimited if 0
+	PerTransferLimit int64
+	// Windows are the time windows in which large transfers are allowed, transfers are allowed at any time if empty
+	Windows []TransferWindow
+	// SmallTransferThreshold is the largest transfer in bytes still done outside of the transfer windows
+	SmallTransferThreshold int64
+}
+
+// TransferWindow is a daily time window in local time, the window spans midnight if it ends before it starts
+type TransferWindow struct {
+	// Start and End are minutes since midnight
+	Start int
+	End   int
+}
+
+// NewBandwidthConfig creates the bandwidth config, windows are expected in the HH:MM-HH:MM format
+func NewBandwidthConfig(limit, perTransferLimit int64, windows []string, smallTransferThreshold int64) (BandwidthConfig, error) {
+	bandwidthConfig := BandwidthConfig{
+		Limit:                  limit,
+		PerTransferLimit:       perTransferLimit,
+		SmallTransferThreshold: smallTransferThreshold,
+	}
+	for _, w := range windows {
+		window, err := ParseTransferWindow(w)
+		if err != nil {
+			return BandwidthConfig{}, err
+		}
+		bandwidthConfig.Windows = append(bandwidthConfig.Windows, window)
+	}
+	return bandwidthConfig, nil
+}
+
+// ParseTransferWindow parses a window in the HH:MM-HH:MM format, e.g. 01:00-05:00 or 22:00-06:00
+func ParseTransferWindow(window string) (TransferWindow, error) {
+	start, end, found := strings.Cut(window, "-")
+	if !found {
+		return TransferWindow{}, fmt.Errorf("invalid transfer window %q: expected HH:MM-HH:MM", window)
+	}
+	startMinute, err := parseMinuteOfDay(strings.TrimSpace(start))
+	if err != nil {
+		return TransferWindow{}, fmt.Errorf("invalid transfer window %q: %w", window, err)
+	}
+	endMinute, err := parseMinuteOfDay(strings.TrimSpace(end))
+	if err != nil {
+		return TransferWindow{}, fmt.Errorf("invalid transfer window %q: %w", window, err)
+	}
+	if startMinute == endMinute {
+		return TransferWindow{}, fmt.Errorf("invalid transfer window %q: the window is empty", window)
+	}
+	return TransferWindow{Start: startMinute, End: endMinute}, nil
+}
+
+func parseMinuteOfDay(value string) (int, error) {
+	hours, minutes, found := strings.Cut(value, ":")
+	if !found {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid minute in %q", value)
+	}
+	return h*60 + m, nil
+}
+
+// Contains returns true if the time, in its location, is within the window
+func (w TransferWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+func (w TransferWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// InTransferWindow returns true if large transfers are allowed at the given time
+func (c BandwidthConfig) InTransferWindow(t time.Time) bool {
+	if len(c.Windows) == 0 {
+		return true
+	}
+	for _, window := range c.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeferLargeTransfers returns the entities to replicate and the ones deferred to the next transfer window. Outside
+// of the transfer windows only the entities whose new blobs fit within the small transfer threshold are replicated,
+// such as a tag moved to a manifest sharing its layers with the ones held locally.
+func (f *FetchAndReplicateStateProcess) DeferLargeTransfers(ctx context.Context, i int, newEntities, replicateEntity []Entity, log *zerolog.Logger) ([]Entity, []Entity) {
+	bandwidth := f.replicatorConf.Bandwidth
+	if len(replicateEntity) == 0 || bandwidth.InTransferWindow(time.Now()) {
+		return replicateEntity, nil
+	}
+
+	incoming := make(map[string]bool)
+	for _, entity := range replicateEntity {
+		incoming[entityKey(entity)] = true
+	}
+	usage := newBlobUsage()
+	for _, entity := range f.localEntityBlobs(ctx, i, newEntities, incoming, log) {
+		usage.add(entity.blobs)
+	}
+
+	sourceOptions := registryOptions(ctx, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword)
+	var toReplicate, deferred []Entity
+	for _, entity := range replicateEntity {
+		blobs, err := f.entityBlobs(f.authConfig.SourceRegistry, entity, true, f.replicatorConf.Platforms, sourceOptions)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Failed to estimate the size of %s, deferring it to the next transfer window", entity.String())
+			deferred = append(deferred, entity)
+			continue
+		}
+		size := usage.added(blobs)
+		if size > bandwidth.SmallTransferThreshold {
+			log.Info().Msgf("Deferring %s to the next transfer window, it needs %d bytes", entity.String(), size)
+			deferred = append(deferred, entity)
+			continue
+		}
+		usage.add(blobs)
+		toReplicate = append(toReplicate, entity)
+	}
+
+	if len(deferred) > 0 {
+		var details []string
+		for _, entity := range deferred {
+			details = append(details, entity.String())
+		}
+		f.notify(notifier.InfoLevel, fmt.Sprintf("%d entities are deferred to the next transfer window", len(deferred)), details, log)
+	}
+	return toReplicate, deferred
+}
+
+// bandwidthLimiter is a token bucket holding up to one second of transfer
+type bandwidthLimiter struct {
+	rate   float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter returns a limiter for the rate in bytes per second, nil if the rate is not limited
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred. The bytes are reserved right away, so that concurrent
+// transfers are served in order.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledTransport limits the rate at which the response bodies are read
+type throttledTransport struct {
+	base     http.RoundTripper
+	limiters []*bandwidthLimiter
+}
+
+// newThrottledTransport wraps the transport with the limiters which are set, the transport is returned
+// as is if none is set
+func newThrottledTransport(base http.RoundTripper, limiters ...*bandwidthLimiter) http.RoundTripper {
+	var set []*bandwidthLimiter
+	for _, limiter := range limiters {
+		if limiter != nil {
+			set = append(set, limiter)
+		}
+	}
+	if len(set) == 0 {
+		return base
+	}
+	return &throttledTransport{base: base, limiters: set}
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		return resp, err
+	}
+	resp.Body = &throttledReader{ReadCloser: resp.Body, ctx: req.Context(), limiters: t.limiters}
+	return resp, nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	limiters []*bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttledChunkSize {
+		p = p[:throttledChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	for _, limiter := range r.limiters {
+		if waitErr := limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
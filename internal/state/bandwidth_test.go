@@ -0,0 +1,51 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferWindows(t *testing.T) {
+	bandwidthConfig, err := NewBandwidthConfig(0, 0, []string{"01:00-05:00", "22:30-00:30"}, 0)
+	require.NoError(t, err)
+	require.Len(t, bandwidthConfig.Windows, 2)
+	assert.Equal(t, "22:30-00:30", bandwidthConfig.Windows[1].String())
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, time.January, 1, hour, minute, 0, 0, time.Local)
+	}
+	assert.True(t, bandwidthConfig.InTransferWindow(at(1, 0)))
+	assert.True(t, bandwidthConfig.InTransferWindow(at(4, 59)))
+	assert.False(t, bandwidthConfig.InTransferWindow(at(5, 0)))
+	assert.False(t, bandwidthConfig.InTransferWindow(at(12, 0)))
+	assert.True(t, bandwidthConfig.InTransferWindow(at(23, 15)))
+	assert.True(t, bandwidthConfig.InTransferWindow(at(0, 15)))
+	assert.False(t, bandwidthConfig.InTransferWindow(at(0, 30)))
+
+	// Transfers are allowed at any time without windows
+	assert.True(t, BandwidthConfig{}.InTransferWindow(at(12, 0)))
+
+	for _, window := range []string{"01:00", "25:00-02:00", "01:60-02:00", "02:00-02:00", "1-2"} {
+		_, err := ParseTransferWindow(window)
+		assert.Error(t, err, window)
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	assert.Nil(t, newBandwidthLimiter(0))
+
+	// The bucket starts full, the bytes beyond one second of transfer wait for the tokens to refill
+	limiter := newBandwidthLimiter(1000)
+	start := time.Now()
+	require.NoError(t, limiter.wait(context.Background(), 1000))
+	require.NoError(t, limiter.wait(context.Background(), 100))
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limiter.wait(ctx, 1000), context.Canceled)
+}
@@ -2,11 +2,18 @@ package state
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
 	"github.com/container-registry/harbor-satellite/internal/scheduler"
+	"github.com/container-registry/harbor-satellite/internal/utils"
 	"github.com/robfig/cron/v3"
 )
 
@@ -36,8 +43,10 @@ func NewFetchConfigFromGroundControlProcess(cronExpr string, token string, groun
 	}
 }
 
+// GroundControlPayload is the configuration returned by the ground control sync endpoint
 type GroundControlPayload struct {
-	States []string `json:"states"`
+	States []string    `json:"states"`
+	Auth   config.Auth `json:"auth"`
 }
 
 type GroundControlConfigEvent struct {
@@ -46,21 +55,127 @@ type GroundControlConfigEvent struct {
 	Source  string
 }
 
-func NewGroundControlConfigEvent(states []string) scheduler.Event {
+func NewGroundControlConfigEvent(payload GroundControlPayload) scheduler.Event {
 	return scheduler.Event{
-		Name: FetchConfigFromGroundControlEventName,
-		Payload: GroundControlPayload{
-			States: states,
-		},
-		Source: config.UpdateConfigJobName,
+		Name:    FetchConfigFromGroundControlEventName,
+		Payload: payload,
+		Source:  config.UpdateConfigJobName,
 	}
 }
 
 func (f *FetchConfigFromGroundControlProcess) Execute(ctx context.Context) error {
-	// TODO: Implement the logic to fetch the configuration from Ground Control one the endpoint is available on the Ground Control side
+	log := logger.FromContext(ctx)
+	if !f.start() {
+		log.Warn().Msgf("Process %s is already running", f.name)
+		return nil
+	}
+	defer f.stop()
+	canExecute, reason := f.CanExecute(ctx)
+	if !canExecute {
+		log.Warn().Msgf("Process %s cannot execute: %s", f.name, reason)
+		return nil
+	}
+	log.Info().Msgf("Executing process %s", f.name)
+
+	f.renewCertificate(ctx)
+
+	payload, err := FetchConfigFromGroundControl(ctx, f.groundControlURL, GroundControlSyncPath, config.GetSourceRegistryUsername(), config.GetSourceRegistryPassword())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch config from ground control")
+		return err
+	}
+
+	// Persist the credentials if they were changed by ground control. A rotated secret is persisted as pending,
+	// the replication process applies it once the registry accepts it.
+	if auth := payload.Auth; auth.SourceUsername != "" && auth.SourcePassword != "" && auth.Registry != "" {
+		sameAccount := auth.SourceUsername == config.GetSourceRegistryUsername() && auth.Registry == config.GetSourceRegistryURL()
+		pending := config.GetPendingAuth()
+		switch {
+		case !sameAccount:
+			log.Info().Msg("Received updated credentials from ground control")
+			if err := config.UpdateStateAuthConfig(auth.SourceUsername, auth.Registry, auth.SourcePassword, config.GetState()); err != nil {
+				log.Error().Err(err).Msg("Failed to update state auth config")
+				return fmt.Errorf("failed to update state auth config: %w", err)
+			}
+		case auth.SourcePassword != config.GetSourceRegistryPassword():
+			if pending == nil || *pending != auth {
+				log.Info().Msg("Received a rotated robot secret from ground control")
+				if err := config.UpdatePendingAuthConfig(&auth); err != nil {
+					log.Error().Err(err).Msg("Failed to update pending auth config")
+					return fmt.Errorf("failed to update pending auth config: %w", err)
+				}
+			}
+		case pending != nil:
+			if err := config.UpdatePendingAuthConfig(nil); err != nil {
+				log.Error().Err(err).Msg("Failed to update pending auth config")
+				return fmt.Errorf("failed to update pending auth config: %w", err)
+			}
+		}
+	}
+
+	if err := f.eventBroker.Publish(NewGroundControlConfigEvent(payload), ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to publish config fetched from ground control")
+		return fmt.Errorf("failed to publish config fetched from ground control: %w", err)
+	}
+	log.Info().Msgf("Config fetched from ground control with %d group states", len(payload.States))
 	return nil
 }
 
+// renewCertificate renews the client certificate of the satellite once two thirds of its lifetime passed, a failed
+// renewal is retried on the next run while the current certificate is still valid
+func (f *FetchConfigFromGroundControlProcess) renewCertificate(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	identity := NewIdentity(config.GetIdentityDir())
+	renew, err := identity.NeedsRenewal(time.Now())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load the client certificate, requesting a new one")
+	}
+	if !renew {
+		return
+	}
+	err = identity.Renew(ctx, f.groundControlURL, GroundControlCertificatePath, config.GetSourceRegistryUsername(), config.GetSourceRegistryPassword())
+	switch {
+	case errors.Is(err, ErrCertificatesNotIssued):
+		log.Debug().Msg("Ground control does not issue client certificates")
+	case err != nil:
+		log.Warn().Err(err).Msg("Failed to renew the client certificate")
+	default:
+		log.Info().Msg("Client certificate renewed")
+	}
+}
+
+// FetchConfigFromGroundControl calls the sync endpoint of ground control authenticating with the
+// robot account credentials of the satellite and returns the current group states and credentials
+func FetchConfigFromGroundControl(ctx context.Context, groundControlURL, path, username, password string) (GroundControlPayload, error) {
+	syncURL := fmt.Sprintf("%s%s", strings.TrimSuffix(groundControlURL, "/"), path)
+	client, err := groundControlClient()
+	if err != nil {
+		return GroundControlPayload{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, syncURL, nil)
+	if err != nil {
+		return GroundControlPayload{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(username, password)
+
+	response, err := client.Do(req)
+	if err != nil {
+		return GroundControlPayload{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return GroundControlPayload{}, fmt.Errorf("failed to fetch config from ground control: %s", response.Status)
+	}
+
+	var payload GroundControlPayload
+	if err := json.NewDecoder(response.Body).Decode(&payload); err != nil {
+		return GroundControlPayload{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return payload, nil
+}
+
 func (f *FetchConfigFromGroundControlProcess) GetID() cron.EntryID {
 	return f.id
 }
@@ -82,27 +197,45 @@ func (f *FetchConfigFromGroundControlProcess) IsRunning() bool {
 }
 
 func (f *FetchConfigFromGroundControlProcess) CanExecute(ctx context.Context) (bool, string) {
-	return false, fmt.Sprintf("Process %s can execute all condition fulfilled", f.name)
+	// The ground control URL could be updated by the ZTR process, so we always read it from the config
+	f.groundControlURL = config.GetGroundControlURL()
+	checks := []struct {
+		condition bool
+		message   string
+	}{
+		{!utils.IsZTRDone(), "zero touch registration is not done"},
+		{f.groundControlURL == "", "ground control URL"},
+		{config.GetSourceRegistryUsername() == "", "robot account username"},
+		{config.GetSourceRegistryPassword() == "", "robot account password"},
+	}
+	var missing []string
+	for _, check := range checks {
+		if check.condition {
+			missing = append(missing, check.message)
+		}
+	}
+	if len(missing) > 0 {
+		return false, fmt.Sprintf("missing %s", strings.Join(missing, ", "))
+	}
+	return true, fmt.Sprintf("Process %s can execute all condition fulfilled", f.name)
 }
 
 func (f *FetchConfigFromGroundControlProcess) AddEventBroker(eventBroker *scheduler.EventBroker, ctx context.Context) {
 	f.eventBroker = eventBroker
 }
 
-// comment out unused functions to ignore linter warnings. nolint isn't working for some reason.
-
-//func (f *FetchConfigFromGroundControlProcess) start() bool {
-//	f.mu.Lock()
-//	defer f.mu.Unlock()
-//	if f.isRunning {
-//	return false
-//}
-//f.isRunning = true
-//return true
-//}
+func (f *FetchConfigFromGroundControlProcess) start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.isRunning {
+		return false
+	}
+	f.isRunning = true
+	return true
+}
 
-//func (f *FetchConfigFromGroundControlProcess) stop() {
-//f.mu.Lock()
-//defer f.mu.Unlock()
-//f.isRunning = false
-//}
+func (f *FetchConfigFromGroundControlProcess) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.isRunning = false
+}
@@ -0,0 +1,293 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// blobLocator remembers which local repository holds a blob, so that blobs shared between
+// repositories can be mounted instead of being downloaded from the source registry again
+type blobLocator struct {
+	// blobs is a map of blob digest to the local repository holding the blob
+	blobs map[v1.Hash]name.Repository
+	// mu is the mutex protecting the blobs map
+	mu sync.RWMutex
+}
+
+func newBlobLocator() *blobLocator {
+	return &blobLocator{
+		blobs: make(map[v1.Hash]name.Repository),
+	}
+}
+
+// record marks the blobs as present in the repository
+func (b *blobLocator) record(repo name.Repository, digests ...v1.Hash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, digest := range digests {
+		b.blobs[digest] = repo
+	}
+}
+
+// lookup returns the local repository holding the blob, if any
+func (b *blobLocator) lookup(digest v1.Hash) (name.Repository, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	repo, ok := b.blobs[digest]
+	return repo, ok
+}
+
+// mountableImage wraps an image so that the layers already present in another local repository
+// are mounted by remote.Write instead of being uploaded, and the other layers are staged on disk
+// before being uploaded when a source is set
+type mountableImage struct {
+	v1.Image
+	// mounts is a map of layer digest to the local repository the layer could be mounted from
+	mounts map[v1.Hash]name.Repository
+	ctx    context.Context
+	stager *blobStager
+	// source is the repository the staged layers are downloaded from, the layers are not staged if nil
+	source *blobSource
+}
+
+func (m *mountableImage) Layers() ([]v1.Layer, error) {
+	layers, err := m.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, 0, len(layers))
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		repo, ok := m.mounts[digest]
+		if !ok {
+			if m.source != nil {
+				layer = &stagedLayer{Layer: layer, ctx: m.ctx, stager: m.stager, source: m.source}
+			}
+			wrapped = append(wrapped, layer)
+			continue
+		}
+		wrapped = append(wrapped, &remote.MountableLayer{Layer: layer, Reference: repo.Digest(digest.String())})
+	}
+	return wrapped, nil
+}
+
+// copyDescriptor copies the image or image index described by desc to the destination, keeping the
+// manifests byte for byte identical so that the digest at the destination matches the source.
+// If platforms are configured, the index is reduced to the matching platforms before being copied,
+// which changes the digest of the index but not of the images it references.
+// Indexes pushed by digest are never filtered, as the digest has to match the one requested.
+// It returns the digest of the manifest pushed, which only differs from the source one for a filtered index,
+// and the digests of the source manifests copied, i.e. the manifest and the images of an index.
+func (r *BasicReplicator) copyDescriptor(ctx context.Context, desc *remote.Descriptor, dst name.Reference, source *blobSource, options []remote.Option) (v1.Hash, []v1.Hash, error) {
+	log := logger.FromContext(ctx)
+	switch {
+	case desc.MediaType.IsIndex():
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return v1.Hash{}, nil, err
+		}
+		pushed := desc.Digest
+		if _, byDigest := dst.(name.Digest); len(r.config.Platforms) > 0 && !byDigest {
+			idx, err = filterPlatforms(idx, r.config.Platforms)
+			if err != nil {
+				return v1.Hash{}, nil, err
+			}
+			if pushed, err = idx.Digest(); err != nil {
+				return v1.Hash{}, nil, err
+			}
+			log.Info().Msgf("Index %s filtered to platforms %v", dst.String(), r.config.Platforms)
+		}
+		if err := r.copyIndex(ctx, idx, dst, source, options); err != nil {
+			return v1.Hash{}, nil, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return v1.Hash{}, nil, err
+		}
+		digests := []v1.Hash{desc.Digest}
+		for _, child := range manifest.Manifests {
+			digests = append(digests, child.Digest)
+		}
+		return pushed, digests, nil
+	case desc.MediaType.IsImage():
+		img, err := desc.Image()
+		if err != nil {
+			return v1.Hash{}, nil, err
+		}
+		if err := r.copyImage(ctx, img, dst, source, options); err != nil {
+			return v1.Hash{}, nil, err
+		}
+		return desc.Digest, []v1.Hash{desc.Digest}, nil
+	default:
+		return v1.Hash{}, nil, fmt.Errorf("unsupported media type %s for %s", desc.MediaType, dst.String())
+	}
+}
+
+// reuseManifest checks whether the manifest is already present at the destination:
+//  1. if the reference already points to the digest nothing has to be copied
+//  2. if the repository already holds the digest under another tag, only the tag is moved
+//
+// It returns true if the destination is up to date.
+func (r *BasicReplicator) reuseManifest(ctx context.Context, t remote.Taggable, digest v1.Hash, dst name.Reference, options []remote.Option) (bool, error) {
+	log := logger.FromContext(ctx)
+	if desc, err := remote.Head(dst, options...); err == nil && desc.Digest == digest {
+		log.Info().Msgf("%s is already present with digest %s, skipping", dst.String(), digest.String())
+		return true, nil
+	}
+
+	tag, ok := dst.(name.Tag)
+	if !ok {
+		return false, nil
+	}
+	if _, err := remote.Head(dst.Context().Digest(digest.String()), options...); err != nil {
+		return false, nil
+	}
+	log.Info().Msgf("Digest %s is already present in %s, moving tag %s", digest.String(), dst.Context().String(), tag.TagStr())
+	if err := remote.Tag(tag, t, options...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// copyImage copies the image to the destination moving as little data as possible. Blobs already
+// present in the repository are skipped and blobs present in other local repositories are mounted.
+// The other blobs are staged on disk first when a source is set.
+func (r *BasicReplicator) copyImage(ctx context.Context, img v1.Image, dst name.Reference, source *blobSource, options []remote.Option) error {
+	log := logger.FromContext(ctx)
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	reused, err := r.reuseManifest(ctx, img, digest, dst, options)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return r.recordBlobs(img, dst.Context())
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	mounts := make(map[v1.Hash]name.Repository)
+	for _, layer := range layers {
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if repo, ok := r.blobs.lookup(layerDigest); ok && repo.String() != dst.Context().String() {
+			mounts[layerDigest] = repo
+		}
+	}
+	if len(mounts) > 0 {
+		log.Info().Msgf("Mounting %d of %d layers of %s from other local repositories", len(mounts), len(layers), dst.String())
+	}
+
+	if err := remote.Write(dst, &mountableImage{Image: img, mounts: mounts, ctx: ctx, stager: r.stager, source: source}, options...); err != nil {
+		return err
+	}
+	return r.recordBlobs(img, dst.Context())
+}
+
+// copyIndex copies the index and all the manifests it references to the destination. The referenced
+// manifests are copied by digest before the index itself is written.
+func (r *BasicReplicator) copyIndex(ctx context.Context, idx v1.ImageIndex, dst name.Reference, source *blobSource, options []remote.Option) error {
+	digest, err := idx.Digest()
+	if err != nil {
+		return err
+	}
+
+	reused, err := r.reuseManifest(ctx, idx, digest, dst, options)
+	if err != nil || reused {
+		return err
+	}
+
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, child := range manifest.Manifests {
+		childRef := dst.Context().Digest(child.Digest.String())
+		switch {
+		case child.MediaType.IsIndex():
+			childIdx, err := idx.ImageIndex(child.Digest)
+			if err != nil {
+				return err
+			}
+			if err := r.copyIndex(ctx, childIdx, childRef, source, options); err != nil {
+				return err
+			}
+		case child.MediaType.IsImage():
+			childImg, err := idx.Image(child.Digest)
+			if err != nil {
+				return err
+			}
+			if err := r.copyImage(ctx, childImg, childRef, source, options); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported media type %s for manifest %s", child.MediaType, child.Digest.String())
+		}
+	}
+
+	return remote.Put(dst, idx, options...)
+}
+
+// filterPlatforms removes the manifests of the index which do not satisfy any of the platforms.
+// Manifests without a platform, such as attestations, are kept.
+func filterPlatforms(idx v1.ImageIndex, platforms []v1.Platform) (v1.ImageIndex, error) {
+	filtered := mutate.RemoveManifests(idx, func(desc v1.Descriptor) bool {
+		if desc.Platform == nil {
+			return false
+		}
+		for _, platform := range platforms {
+			if desc.Platform.Satisfies(platform) {
+				return false
+			}
+		}
+		return true
+	})
+	manifest, err := filtered.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return nil, fmt.Errorf("index has no manifest for platforms %v", platforms)
+	}
+	return filtered, nil
+}
+
+// recordBlobs records the config and layers of the image as present in the repository
+func (r *BasicReplicator) recordBlobs(img v1.Image, repo name.Repository) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	digests := make([]v1.Hash, 0, len(layers)+1)
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		digests = append(digests, digest)
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	digests = append(digests, configDigest)
+	r.blobs.record(repo, digests...)
+	return nil
+}
@@ -4,19 +4,30 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
 	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/container-registry/harbor-satellite/internal/verifier"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// stateArtifactFile is the file of the state artifact holding the state
+	stateArtifactFile = "artifacts.json"
+	// stateSignatureFile is the file of the state artifact holding the base64 encoded signature of the state
+	stateSignatureFile = "artifacts.json.sig"
+)
+
 type StateFetcher interface {
 	FetchStateArtifact(ctx context.Context, state interface{}, log *zerolog.Logger) error
 }
@@ -98,7 +109,15 @@ func (f *URLStateFetcher) fetchGroupState(ctx context.Context, state *State, log
 	if err != nil {
 		return err
 	}
-	return f.extractArtifactJSON(f.url, img, state, log)
+	if err := f.extractArtifactJSON(f.url, img, state, log); err != nil {
+		return err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to compute the digest of the state artifact: %w", err)
+	}
+	state.Digest = digest.String()
+	return nil
 }
 
 func (f *URLStateFetcher) pullImage(ctx context.Context, log *zerolog.Logger) (v1.Image, error) {
@@ -107,7 +126,7 @@ func (f *URLStateFetcher) pullImage(ctx context.Context, log *zerolog.Logger) (v
 		Username: f.username,
 		Password: f.password,
 	})
-	options := []crane.Option{crane.WithAuth(auth), crane.WithContext(ctx)}
+	options := []crane.Option{crane.WithAuth(auth), crane.WithContext(ctx), crane.WithUserAgent(satelliteUserAgent)}
 	if config.UseUnsecure() {
 		options = append(options, crane.Insecure)
 	}
@@ -124,6 +143,7 @@ func (f *URLStateFetcher) extractArtifactJSON(url string, img v1.Image, out inte
 	}
 
 	tr := tar.NewReader(tarContent)
+	var artifactsJSON, signature []byte
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -134,17 +154,69 @@ func (f *URLStateFetcher) extractArtifactJSON(url string, img v1.Image, out inte
 			return fmt.Errorf("failed to read the tar archive: %v", err)
 		}
 
-		if hdr.Name == "artifacts.json" {
-			artifactsJSON, err := io.ReadAll(tr)
+		switch hdr.Name {
+		case stateArtifactFile:
+			artifactsJSON, err = io.ReadAll(tr)
 			if err != nil {
 				log.Error().Msgf("Failed to read the artifacts.json of the state artifact: %s", url)
 				return fmt.Errorf("failed to read the artifacts.json file: %v", err)
 			}
-			return json.Unmarshal(artifactsJSON, out)
+		case stateSignatureFile:
+			signature, err = io.ReadAll(tr)
+			if err != nil {
+				log.Error().Msgf("Failed to read the signature of the state artifact: %s", url)
+				return fmt.Errorf("failed to read the artifacts.json.sig file: %v", err)
+			}
 		}
 	}
-	log.Error().Msgf("artifacts.json not present for the state artifact: %s", url)
-	return fmt.Errorf("artifacts.json not found in the state artifact")
+	if artifactsJSON == nil {
+		log.Error().Msgf("artifacts.json not present for the state artifact: %s", url)
+		return fmt.Errorf("artifacts.json not found in the state artifact")
+	}
+	if err := verifyStateArtifact(url, artifactsJSON, signature, log); err != nil {
+		log.Error().Msgf("Failed to verify the state artifact: %s", url)
+		return err
+	}
+	return json.Unmarshal(artifactsJSON, out)
+}
+
+// verifyStateArtifact verifies the signature of the state with the Ground Control public key, if configured,
+// and checks that the state was issued for the repository it was fetched from so that a state cannot be
+// served in place of another one
+func verifyStateArtifact(url string, artifactsJSON, signature []byte, log *zerolog.Logger) error {
+	if config.GetStatePublicKey() == "" {
+		log.Debug().Msgf("No state public key configured, skipping the verification of the state artifact: %s", url)
+		return nil
+	}
+	if signature == nil {
+		return fmt.Errorf("state artifact %s is not signed", url)
+	}
+	key, err := verifier.LoadPublicKey(config.GetStatePublicKey())
+	if err != nil {
+		return fmt.Errorf("failed to load the state public key: %w", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding for state artifact %s: %w", url, err)
+	}
+	if !verifier.VerifySignature(key, artifactsJSON, decoded) {
+		return fmt.Errorf("invalid signature for state artifact %s", url)
+	}
+
+	var envelope struct {
+		Subject string `json:"subject"`
+	}
+	if err := json.Unmarshal(artifactsJSON, &envelope); err != nil {
+		return fmt.Errorf("failed to parse state artifact %s: %w", url, err)
+	}
+	ref, err := name.ParseReference(url)
+	if err != nil {
+		return fmt.Errorf("invalid state artifact reference %s: %w", url, err)
+	}
+	if envelope.Subject != ref.Context().RepositoryStr() {
+		return fmt.Errorf("state artifact %s was issued for %q", url, envelope.Subject)
+	}
+	return nil
 }
 
 func FromJSON(data []byte, reg StateReader) (StateReader, error) {
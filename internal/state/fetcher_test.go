@@ -0,0 +1,92 @@
+package state
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyStateArtifact(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	localConfig, err := json.Marshal(map[string]any{
+		"environment_variables": map[string]any{
+			"verification": map[string]string{
+				"state_public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			},
+		},
+	})
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, localConfig, 0600))
+	errs, _ := config.InitConfig(configPath)
+	require.Empty(t, errs)
+
+	// sign signs the state as Ground Control does
+	sign := func(state []byte) []byte {
+		digest := sha256.Sum256(state)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		return []byte(base64.StdEncoding.EncodeToString(signature))
+	}
+	state := []byte(`{"group":"edge","version":7,"subject":"satellite/group-state/edge/state"}`)
+	url := "registry.example.com/satellite/group-state/edge/state:latest"
+	log := zerolog.Nop()
+
+	tests := []struct {
+		name      string
+		url       string
+		state     []byte
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "signed state", url: url, state: state, signature: sign(state)},
+		{name: "unsigned state", url: url, state: state, wantErr: true},
+		{
+			name:      "tampered state",
+			url:       url,
+			state:     []byte(`{"group":"edge","version":8,"subject":"satellite/group-state/edge/state"}`),
+			signature: sign(state),
+			wantErr:   true,
+		},
+		{
+			name:      "state of another repository",
+			url:       "registry.example.com/satellite/group-state/other/state:latest",
+			state:     state,
+			signature: sign(state),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyStateArtifact(tt.url, tt.state, tt.signature, &log)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckStateVersion(t *testing.T) {
+	url := "registry.example.com/satellite/group-state/edge/state:latest"
+	assert.NoError(t, checkStateVersion(url, 8, 7))
+	assert.NoError(t, checkStateVersion(url, 7, 7), "the applied state is fetched again until a new one is pushed")
+	assert.Error(t, checkStateVersion(url, 6, 7), "an older signed state replayed in place of the latest is rejected")
+}
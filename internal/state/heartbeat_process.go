@@ -0,0 +1,173 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/scheduler"
+	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/robfig/cron/v3"
+)
+
+const GroundControlHeartbeatPath string = "/satellites/heartbeat"
+
+// Heartbeat is the status of the satellite reported to Ground Control
+type Heartbeat struct {
+	Version       string `json:"version"`
+	GitCommit     string `json:"git_commit,omitempty"`
+	System        string `json:"system,omitempty"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	// States are the group states applied to the local registry
+	States []HeartbeatState `json:"states"`
+	// LastError is the error of the most recent failed reconciliation or process run, empty if everything succeeded
+	LastError string `json:"last_error,omitempty"`
+	// DiskTotalBytes and DiskFreeBytes describe the disk holding the images, zero if they could not be read
+	DiskTotalBytes int64 `json:"disk_total_bytes,omitempty"`
+	DiskFreeBytes  int64 `json:"disk_free_bytes,omitempty"`
+}
+
+// HeartbeatState is a group state as applied by the satellite
+type HeartbeatState struct {
+	URL      string      `json:"url"`
+	Version  int64       `json:"version"`
+	Digest   string      `json:"digest,omitempty"`
+	LastSync *SyncResult `json:"last_sync,omitempty"`
+}
+
+// HeartbeatProcess periodically reports the status of the satellite to Ground Control, so that the fleet can be
+// monitored from a single place
+type HeartbeatProcess struct {
+	id        cron.EntryID
+	name      string
+	cronExpr  string
+	isRunning bool
+	// collect returns the status of the satellite at the time of the heartbeat
+	collect func(ctx context.Context) Heartbeat
+	mu      *sync.Mutex
+}
+
+func NewHeartbeatProcess(cronExpr string, collect func(ctx context.Context) Heartbeat) *HeartbeatProcess {
+	return &HeartbeatProcess{
+		name:     config.HeartbeatJobName,
+		cronExpr: cronExpr,
+		collect:  collect,
+		mu:       &sync.Mutex{},
+	}
+}
+
+func (h *HeartbeatProcess) Execute(ctx context.Context) error {
+	log := logger.FromContext(ctx)
+	if !h.start() {
+		log.Warn().Msgf("Process %s is already running", h.name)
+		return nil
+	}
+	defer h.stop()
+	canExecute, reason := h.CanExecute(ctx)
+	if !canExecute {
+		log.Debug().Msgf("Process %s cannot execute: %s", h.name, reason)
+		return nil
+	}
+	heartbeat := h.collect(ctx)
+	if err := SendHeartbeat(ctx, config.GetGroundControlURL(), GroundControlHeartbeatPath, config.GetSourceRegistryUsername(), config.GetSourceRegistryPassword(), heartbeat); err != nil {
+		log.Error().Err(err).Msg("Failed to send heartbeat to ground control")
+		return err
+	}
+	log.Debug().Msg("Heartbeat sent to ground control")
+	return nil
+}
+
+// SendHeartbeat posts the heartbeat to ground control authenticating with the robot account credentials of the satellite
+func SendHeartbeat(ctx context.Context, groundControlURL, path, username, password string, heartbeat Heartbeat) error {
+	heartbeatURL := fmt.Sprintf("%s%s", strings.TrimSuffix(groundControlURL, "/"), path)
+	body, err := json.Marshal(heartbeat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, heartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(username, password)
+
+	client, err := groundControlClient()
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("ground control rejected the heartbeat: %s", response.Status)
+	}
+	return nil
+}
+
+func (h *HeartbeatProcess) GetID() cron.EntryID {
+	return h.id
+}
+
+func (h *HeartbeatProcess) SetID(id cron.EntryID) {
+	h.id = id
+}
+
+func (h *HeartbeatProcess) GetName() string {
+	return h.name
+}
+
+func (h *HeartbeatProcess) GetCronExpr() string {
+	return h.cronExpr
+}
+
+func (h *HeartbeatProcess) IsRunning() bool {
+	return h.isRunning
+}
+
+func (h *HeartbeatProcess) CanExecute(ctx context.Context) (bool, string) {
+	checks := []struct {
+		condition bool
+		message   string
+	}{
+		{!utils.IsZTRDone(), "zero touch registration is not done"},
+		{config.GetGroundControlURL() == "", "ground control URL"},
+		{config.GetSourceRegistryUsername() == "", "robot account username"},
+		{config.GetSourceRegistryPassword() == "", "robot account password"},
+	}
+	var missing []string
+	for _, check := range checks {
+		if check.condition {
+			missing = append(missing, check.message)
+		}
+	}
+	if len(missing) > 0 {
+		return false, fmt.Sprintf("missing %s", strings.Join(missing, ", "))
+	}
+	return true, fmt.Sprintf("Process %s can execute all condition fulfilled", h.name)
+}
+
+func (h *HeartbeatProcess) AddEventBroker(eventBroker *scheduler.EventBroker, ctx context.Context) {}
+
+func (h *HeartbeatProcess) start() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.isRunning {
+		return false
+	}
+	h.isRunning = true
+	return true
+}
+
+func (h *HeartbeatProcess) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.isRunning = false
+}
@@ -0,0 +1,210 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+)
+
+const GroundControlCertificatePath = "/satellites/certificate"
+
+const (
+	identityKeyFile  = "satellite.key"
+	identityCertFile = "satellite.crt"
+)
+
+// ErrCertificatesNotIssued is returned when Ground Control has no CA to issue client certificates
+var ErrCertificatesNotIssued = errors.New("ground control does not issue client certificates")
+
+// ErrCertificateNotSaved is returned when the certificate issued at registration could not be saved. The
+// satellite is registered but can not authenticate to Ground Control, it has to be deleted and registered again.
+var ErrCertificateNotSaved = errors.New("certificate issued at registration could not be saved")
+
+// certificateSaveAttempts is the number of times the certificate issued at registration is saved before giving up,
+// as the token it was issued for can not be used again
+const certificateSaveAttempts = 3
+
+// Identity is the private key generated by the satellite and the client certificate Ground Control issued for it,
+// the key never leaves the directory
+type Identity struct {
+	dir string
+}
+
+func NewIdentity(dir string) *Identity {
+	return &Identity{dir: dir}
+}
+
+// CertificateRequest returns the PEM encoded certificate request for the key of the satellite, the key is
+// generated the first time
+func (i *Identity) CertificateRequest() (string, error) {
+	key, err := i.loadOrCreateKey()
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// SaveCertificate stores the certificate issued by Ground Control once checked against the key of the satellite
+func (i *Identity) SaveCertificate(certPEM string) error {
+	keyPEM, err := os.ReadFile(filepath.Join(i.dir, identityKeyFile))
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	if _, err := tls.X509KeyPair([]byte(certPEM), keyPEM); err != nil {
+		return fmt.Errorf("certificate does not match the key of the satellite: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(i.dir, identityCertFile), []byte(certPEM)); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// Certificate returns the client certificate of the satellite, nil if Ground Control did not issue one yet
+func (i *Identity) Certificate() (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(i.dir, identityCertFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(i.dir, identityKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// NeedsRenewal returns true if the satellite has no certificate or two thirds of its lifetime passed
+func (i *Identity) NeedsRenewal(now time.Time) (bool, error) {
+	cert, err := i.Certificate()
+	if err != nil || cert == nil {
+		return true, err
+	}
+	lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+	return now.After(cert.Leaf.NotAfter.Add(-lifetime / 3)), nil
+}
+
+// HTTPClient returns a client presenting the certificate of the satellite to Ground Control if it has one which
+// has not expired. Past its expiry the satellite authenticates with its robot account to get a new one.
+func (i *Identity) HTTPClient() (*http.Client, error) {
+	cert, err := i.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil || time.Now().After(cert.Leaf.NotAfter) {
+		return &http.Client{}, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	return &http.Client{Transport: transport}, nil
+}
+
+// Renew requests a new certificate for the key of the satellite, authenticating with the current certificate or,
+// if the satellite has none yet, with its robot account
+func (i *Identity) Renew(ctx context.Context, groundControlURL, path, username, password string) error {
+	csr, err := i.CertificateRequest()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"csr": csr})
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificate request: %w", err)
+	}
+	client, err := i.HTTPClient()
+	if err != nil {
+		return err
+	}
+	renewURL := fmt.Sprintf("%s%s", strings.TrimSuffix(groundControlURL, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, renewURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(username, password)
+
+	response, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotImplemented {
+		return ErrCertificatesNotIssued
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to renew certificate: %s", response.Status)
+	}
+
+	var result struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return i.SaveCertificate(result.Certificate)
+}
+
+func (i *Identity) loadOrCreateKey() (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(i.dir, identityKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("key %s is not PEM encoded", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+		}
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an ECDSA key", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	// The temporary file written first is only readable by the satellite, the key keeps its permissions
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("failed to save key: %w", err)
+	}
+	return key, nil
+}
+
+// groundControlClient returns the client the satellite calls Ground Control with
+func groundControlClient() (*http.Client, error) {
+	return NewIdentity(config.GetIdentityDir()).HTTPClient()
+}
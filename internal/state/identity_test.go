@@ -0,0 +1,127 @@
+package state
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signRequest issues a certificate for the request with a throwaway CA, as Ground Control would
+func signRequest(t *testing.T, csrPEM string, notBefore, notAfter time.Time) string {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "satellite-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode([]byte(csrPEM))
+	require.NotNil(t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "edge-1"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, csr.PublicKey, caKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestIdentityCertificate(t *testing.T) {
+	dir := t.TempDir()
+	identity := NewIdentity(dir)
+
+	cert, err := identity.Certificate()
+	require.NoError(t, err)
+	assert.Nil(t, cert)
+	renew, err := identity.NeedsRenewal(time.Now())
+	require.NoError(t, err)
+	assert.True(t, renew, "a satellite without certificate requests one")
+
+	csr, err := identity.CertificateRequest()
+	require.NoError(t, err)
+	info, err := os.Stat(filepath.Join(dir, identityKeyFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	again, err := identity.CertificateRequest()
+	require.NoError(t, err)
+	assert.Equal(t, publicKeyOf(t, csr), publicKeyOf(t, again), "the key is generated once")
+
+	now := time.Now()
+	require.NoError(t, identity.SaveCertificate(signRequest(t, csr, now.Add(-time.Hour), now.Add(2*time.Hour))))
+	cert, err = identity.Certificate()
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	assert.Equal(t, "edge-1", cert.Leaf.Subject.CommonName)
+
+	renew, err = identity.NeedsRenewal(now)
+	require.NoError(t, err)
+	assert.False(t, renew)
+	renew, err = identity.NeedsRenewal(now.Add(90 * time.Minute))
+	require.NoError(t, err)
+	assert.True(t, renew, "the certificate is renewed once two thirds of its lifetime passed")
+}
+
+func TestIdentitySaveCertificateRejectsOtherKey(t *testing.T) {
+	other := NewIdentity(t.TempDir())
+	csr, err := other.CertificateRequest()
+	require.NoError(t, err)
+	certPEM := signRequest(t, csr, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	identity := NewIdentity(t.TempDir())
+	_, err = identity.CertificateRequest()
+	require.NoError(t, err)
+	assert.Error(t, identity.SaveCertificate(certPEM))
+}
+
+func publicKeyOf(t *testing.T, csrPEM string) any {
+	t.Helper()
+	block, _ := pem.Decode([]byte(csrPEM))
+	require.NotNil(t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	return csr.PublicKey
+}
+
+func TestIdentityHTTPClientSkipsExpiredCertificate(t *testing.T) {
+	identity := NewIdentity(t.TempDir())
+	csr, err := identity.CertificateRequest()
+	require.NoError(t, err)
+
+	require.NoError(t, identity.SaveCertificate(signRequest(t, csr, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))))
+	client, err := identity.HTTPClient()
+	require.NoError(t, err)
+	require.NotNil(t, client.Transport)
+	assert.Len(t, client.Transport.(*http.Transport).TLSClientConfig.Certificates, 1)
+
+	// An expired certificate is not presented, the satellite falls back to its robot account
+	require.NoError(t, identity.SaveCertificate(signRequest(t, csr, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))))
+	client, err = identity.HTTPClient()
+	require.NoError(t, err)
+	assert.Nil(t, client.Transport)
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// registryLimiter bounds the number of concurrent transfers against a single registry
+type registryLimiter struct {
+	// limit is the maximum number of concurrent transfers per registry
+	limit int
+	// slots is a map of registry host to the semaphore guarding it
+	slots map[string]chan struct{}
+	// mu is the mutex protecting the slots map
+	mu sync.Mutex
+}
+
+func newRegistryLimiter(limit int) *registryLimiter {
+	return &registryLimiter{
+		limit: limit,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+func (l *registryLimiter) semaphore(registry string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.slots[registry]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.slots[registry] = sem
+	}
+	return sem
+}
+
+// acquire blocks until a slot is available for the registry or the context is done
+func (l *registryLimiter) acquire(ctx context.Context, registry string) error {
+	select {
+	case l.semaphore(registry) <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot previously acquired for the registry
+func (l *registryLimiter) release(registry string) {
+	<-l.semaphore(registry)
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	entitiesReplicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "replication",
+		Name:      "entities_replicated_total",
+		Help:      "Number of entities replicated to the local registry",
+	}, []string{"group"})
+	entitiesDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "replication",
+		Name:      "entities_deleted_total",
+		Help:      "Number of entities deleted from the local registry",
+	}, []string{"group"})
+	entitiesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "replication",
+		Name:      "entities_failed_total",
+		Help:      "Number of entities which started failing to replicate, by reason: replication, verification or deferral",
+	}, []string{"group", "reason"})
+	bytesTransferred = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "replication",
+		Name:      "bytes_transferred_total",
+		Help:      "Number of bytes pulled from the source registry",
+	})
+	stateFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "satellite",
+		Subsystem: "state",
+		Name:      "fetch_duration_seconds",
+		Help:      "Duration of the fetches of the satellite and group states",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"state"})
+	lastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "satellite",
+		Subsystem: "state",
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time at which the state of the group was last applied without any failed entity",
+	}, []string{"group"})
+)
+
+// Reasons of the entities_failed_total metric
+const (
+	failureReplication  = "replication"
+	failureVerification = "verification"
+	failureDeferral     = "deferral"
+)
+
+func init() {
+	prometheus.MustRegister(entitiesReplicated, entitiesDeleted, entitiesFailed, bytesTransferred, stateFetchDuration, lastSuccessfulSync)
+}
+
+// countNewEntities returns the number of entities which are not in the previous ones
+func countNewEntities(entities, previous []Entity) int {
+	var count int
+	for _, entity := range entities {
+		if !containsEntity(previous, entity) {
+			count++
+		}
+	}
+	return count
+}
+
+// meteredTransport counts the bytes of the response bodies read
+type meteredTransport struct {
+	base http.RoundTripper
+}
+
+func newMeteredTransport(base http.RoundTripper) http.RoundTripper {
+	return &meteredTransport{base: base}
+}
+
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		return resp, err
+	}
+	resp.Body = &meteredReader{ReadCloser: resp.Body}
+	return resp, nil
+}
+
+type meteredReader struct {
+	io.ReadCloser
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	bytesTransferred.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,316 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+)
+
+// Plan is the list of changes the next reconciliation would apply to the local registry
+type Plan struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// FullResync is true if the next reconciliation compares the states with the contents of the local registry
+	FullResync bool        `json:"full_resync"`
+	States     []StatePlan `json:"states"`
+	// DeleteOrphaned are the entities of the group states no longer assigned to the satellite
+	DeleteOrphaned []PlannedEntity `json:"delete_orphaned,omitempty"`
+	// DeleteSize is the total size in bytes of the entities to delete or evict
+	DeleteSize int64 `json:"delete_size"`
+	// ReplicateSize is the total size in bytes of the entities to replicate
+	ReplicateSize int64 `json:"replicate_size"`
+}
+
+// StatePlan is the list of changes for a single group state
+type StatePlan struct {
+	URL           string          `json:"url"`
+	Version       int64           `json:"version"`
+	Delete        []PlannedEntity `json:"delete"`
+	Replicate     []PlannedEntity `json:"replicate"`
+	DeleteSize    int64           `json:"delete_size"`
+	ReplicateSize int64           `json:"replicate_size"`
+	// Evict are the entities evicted to make room within the storage quota
+	Evict     []PlannedEntity `json:"evict,omitempty"`
+	EvictSize int64           `json:"evict_size,omitempty"`
+	// Deferred are the entities held back until the next transfer window or until they fit within the storage quota
+	Deferred []Entity `json:"deferred,omitempty"`
+	// Rejected are the entities not replicated because their signature could not be verified
+	Rejected []Entity `json:"rejected,omitempty"`
+	// Error is set if the state could not be planned, e.g. because it could not be fetched
+	Error string `json:"error,omitempty"`
+}
+
+// PlannedEntity is an entity along with its size. The size is the sum of the manifests, configs and layers of
+// the entity, blobs already present locally or shared with other entities are not deducted.
+type PlannedEntity struct {
+	Entity
+	Size int64 `json:"size"`
+	// SizeError is set if the size of the entity could not be computed
+	SizeError string `json:"size_error,omitempty"`
+}
+
+// Plan computes the changes the next run would apply without touching the local registry. The states go
+// through the same pipeline as in a run, on a copy of the process whose deletions are only recorded.
+func (f *FetchAndReplicateStateProcess) Plan(ctx context.Context) (*Plan, error) {
+	log := logger.FromContext(ctx)
+	canExecute, reason := f.CanExecute(ctx)
+	if !canExecute {
+		return nil, fmt.Errorf("cannot plan: %s", reason)
+	}
+
+	planner, deletions := f.planner()
+	satelliteState, err := planner.fetchPlannedSatelliteState(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	planner.updateStateMap(satelliteState.States)
+	fullResync := planner.isFullResyncDue()
+	auth := planner.authConfig
+	plan := &Plan{GeneratedAt: time.Now(), FullResync: fullResync}
+	for i := range planner.stateMap {
+		statePlan := StatePlan{URL: planner.stateMap[i].url}
+		groupStateFetcher, err := getStateFetcherForInput(statePlan.URL, auth.SourceRegistryUserName, auth.SourceRegistryPassword, log)
+		if err != nil {
+			statePlan.Error = err.Error()
+			plan.States = append(plan.States, statePlan)
+			continue
+		}
+		newStateFetched, err := planner.FetchAndProcessState(ctx, groupStateFetcher, log)
+		if err != nil {
+			statePlan.Error = err.Error()
+			plan.States = append(plan.States, statePlan)
+			continue
+		}
+		if err := checkStateVersion(statePlan.URL, (*newStateFetched).GetVersion(), planner.stateMap[i].Version); err != nil {
+			statePlan.Error = err.Error()
+			plan.States = append(plan.States, statePlan)
+			continue
+		}
+		evicted := len(deletions.deleted)
+		changes, err := planner.ComputeChanges(ctx, i, *newStateFetched, fullResync, log)
+		if err != nil {
+			statePlan.Error = err.Error()
+			plan.States = append(plan.States, statePlan)
+			continue
+		}
+		statePlan.Version = changes.NewState.GetVersion()
+		statePlan.Deferred = changes.Deferred
+		statePlan.Rejected = changes.Rejected
+
+		for _, entity := range changes.Delete {
+			planned := f.planEntity(ctx, auth.RemoteRegistryURL, auth.RemoteRegistryUserName, auth.RemoteRegistryPassword, auth.UseUnsecure, entity, false)
+			statePlan.Delete = append(statePlan.Delete, planned)
+			statePlan.DeleteSize += planned.Size
+		}
+		for _, entity := range deletions.deleted[evicted:] {
+			planned := f.planEntity(ctx, auth.RemoteRegistryURL, auth.RemoteRegistryUserName, auth.RemoteRegistryPassword, auth.UseUnsecure, entity, false)
+			statePlan.Evict = append(statePlan.Evict, planned)
+			statePlan.EvictSize += planned.Size
+		}
+		for _, entity := range changes.Replicate {
+			planned := f.planEntity(ctx, auth.SourceRegistry, auth.SourceRegistryUserName, auth.SourceRegistryPassword, auth.UseUnsecure, entity, true)
+			statePlan.Replicate = append(statePlan.Replicate, planned)
+			statePlan.ReplicateSize += planned.Size
+		}
+		plan.DeleteSize += statePlan.DeleteSize + statePlan.EvictSize
+		plan.ReplicateSize += statePlan.ReplicateSize
+		plan.States = append(plan.States, statePlan)
+
+		// The next states are planned against what the run would leave behind, as Execute does
+		planner.stateMap[i].Entities = replaceEntities(localDigests(FetchEntitiesFromState(changes.NewState), nil, planner.stateMap[i].Entities), changes.Kept)
+		planner.stateMap[i].FailedEntities = append(append([]Entity(nil), changes.Rejected...), changes.Deferred...)
+	}
+	for _, entity := range planner.orphansToDelete(log) {
+		planned := f.planEntity(ctx, auth.RemoteRegistryURL, auth.RemoteRegistryUserName, auth.RemoteRegistryPassword, auth.UseUnsecure, entity, false)
+		plan.DeleteOrphaned = append(plan.DeleteOrphaned, planned)
+		plan.DeleteSize += planned.Size
+	}
+	return plan, nil
+}
+
+// planner returns a copy of the process to plan with, the copy never notifies, never updates the access times
+// and only records the deletions, so that the evictions of the storage quota are planned as well
+func (f *FetchAndReplicateStateProcess) planner() (*FetchAndReplicateStateProcess, *planningReplicator) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deletions := &planningReplicator{}
+	quotaConf := f.quotaConf
+	if quotaConf.AccessTracker != nil {
+		quotaConf.AccessTracker = readOnlyAccessTracker{quotaConf.AccessTracker}
+	}
+	planner := &FetchAndReplicateStateProcess{
+		name:                  f.name,
+		satelliteState:        f.satelliteState,
+		notifier:              discardNotifier{},
+		mu:                    &sync.Mutex{},
+		authConfig:            f.authConfig,
+		pendingAuth:           f.pendingAuth,
+		Replicator:            deletions,
+		replicatorConf:        f.replicatorConf,
+		verificationConf:      f.verificationConf,
+		satelliteStateVersion: f.satelliteStateVersion,
+		lastFullResync:        f.lastFullResync,
+		quotaConf:             quotaConf,
+		orphanedEntities:      slices.Clone(f.orphanedEntities),
+	}
+	// The slices are copied as the planner appends to them
+	for _, stateMap := range f.stateMap {
+		stateMap.Entities = slices.Clone(stateMap.Entities)
+		stateMap.FailedEntities = slices.Clone(stateMap.FailedEntities)
+		stateMap.EvictedEntities = slices.Clone(stateMap.EvictedEntities)
+		planner.stateMap = append(planner.stateMap, stateMap)
+	}
+	return planner, deletions
+}
+
+// fetchPlannedSatelliteState fetches the satellite state on the planner. The rotated robot secret is used if the
+// registry accepts it, the planner then switches to it while the process and the config are left untouched.
+func (f *FetchAndReplicateStateProcess) fetchPlannedSatelliteState(ctx context.Context, log *zerolog.Logger) (*SatelliteState, error) {
+	var satelliteState *SatelliteState
+	var err error
+	if pending := f.pendingAuth; pending != nil {
+		satelliteState, err = f.pullSatelliteState(ctx, pending.SourceUsername, pending.SourcePassword, log)
+		switch {
+		case err == nil:
+			f.authConfig.SourceRegistryUserName = pending.SourceUsername
+			f.authConfig.SourceRegistryPassword = pending.SourcePassword
+			f.authConfig.SourceRegistry = utils.FormatRegistryURL(pending.Registry)
+		case isUnauthorized(err):
+			satelliteState, err = nil, nil
+		}
+	}
+	if satelliteState == nil && err == nil {
+		satelliteState, err = f.pullSatelliteState(ctx, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, log)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching state artifact from url %s: %w", f.satelliteState, err)
+	}
+	if err := checkStateVersion(f.satelliteState, satelliteState.Version, f.satelliteStateVersion); err != nil {
+		return nil, err
+	}
+	return satelliteState, nil
+}
+
+// planningReplicator records the entities to delete instead of deleting them
+type planningReplicator struct {
+	deleted []Entity
+}
+
+func (r *planningReplicator) Replicate(ctx context.Context, replicationEntities []Entity) (ReplicationResult, error) {
+	return ReplicationResult{}, fmt.Errorf("entities are not replicated while planning")
+}
+
+func (r *planningReplicator) DeleteReplicationEntity(ctx context.Context, replicationEntity []Entity) error {
+	r.deleted = append(r.deleted, replicationEntity...)
+	return nil
+}
+
+// readOnlyAccessTracker reads the access times without updating them
+type readOnlyAccessTracker struct {
+	AccessTracker
+}
+
+func (readOnlyAccessTracker) Record(entities []Entity) error { return nil }
+
+func (readOnlyAccessTracker) Forget(entities []Entity) error { return nil }
+
+// discardNotifier drops the notifications
+type discardNotifier struct{}
+
+func (discardNotifier) Notify(notification notifier.Notification) error { return nil }
+
+// planEntity computes the size of the entity in the given registry. The platform filter of the replicator
+// is applied to the entities to replicate, as only the matching images would be copied.
+func (f *FetchAndReplicateStateProcess) planEntity(ctx context.Context, registry, username, password string, useUnsecure bool, entity Entity, filter bool) PlannedEntity {
+	planned := PlannedEntity{Entity: entity}
+	var nameOptions []name.Option
+	if useUnsecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+	reference := entity.Reference(registry)
+	if filter && entity.Digest != "" {
+		reference = fmt.Sprintf("%s/%s/%s@%s", registry, entity.GetRepository(), entity.GetName(), entity.Digest)
+	}
+	ref, err := name.ParseReference(reference, nameOptions...)
+	if err != nil {
+		planned.SizeError = err.Error()
+		return planned
+	}
+	options := []remote.Option{
+		remote.WithAuth(authn.FromConfig(authn.AuthConfig{Username: username, Password: password})),
+		remote.WithContext(ctx),
+		remote.WithUserAgent(satelliteUserAgent),
+	}
+	var platforms []v1.Platform
+	if filter {
+		platforms = f.replicatorConf.Platforms
+	}
+	size, err := artifactSize(ref, platforms, options)
+	if err != nil {
+		planned.SizeError = err.Error()
+		return planned
+	}
+	planned.Size = size
+	return planned
+}
+
+// artifactSize returns the size of the manifest, config and layers of an image, or of all the images
+// matching the platforms for an index
+func artifactSize(ref name.Reference, platforms []v1.Platform, options []remote.Option) (int64, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case desc.MediaType.IsIndex():
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return 0, err
+		}
+		if len(platforms) > 0 {
+			idx, err = filterPlatforms(idx, platforms)
+			if err != nil {
+				return 0, err
+			}
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return 0, err
+		}
+		size := desc.Size
+		for _, child := range manifest.Manifests {
+			childSize, err := artifactSize(ref.Context().Digest(child.Digest.String()), nil, options)
+			if err != nil {
+				return 0, err
+			}
+			size += childSize
+		}
+		return size, nil
+	case desc.MediaType.IsImage():
+		img, err := desc.Image()
+		if err != nil {
+			return 0, err
+		}
+		manifest, err := img.Manifest()
+		if err != nil {
+			return 0, err
+		}
+		size := desc.Size + manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		return size, nil
+	default:
+		return desc.Size, nil
+	}
+}
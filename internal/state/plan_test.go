@@ -0,0 +1,115 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRunsThePipelineWithoutSideEffects(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"environment_variables": {}}`), 0600))
+	errs, _ := config.InitConfig(configPath)
+	require.Empty(t, errs)
+
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	// old is held locally and kept by the state, stale is held locally and removed from it, new is added to it
+	entities := map[string]Entity{}
+	var imageSize int64
+	for _, imageName := range []string{"old", "stale", "new"} {
+		img, err := random.Image(1024, 4)
+		require.NoError(t, err)
+		require.NoError(t, crane.Push(img, source+"/team/"+imageName+":v1"))
+		if imageName != "new" {
+			require.NoError(t, crane.Push(img, local+"/team/"+imageName+":v1"))
+		}
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		entities[imageName] = Entity{Repository: "team", Name: imageName, Tag: "v1", Digest: digest.String()}
+		size, err := img.Size()
+		require.NoError(t, err)
+		imageSize = size
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		for _, layer := range layers {
+			layerSize, err := layer.Size()
+			require.NoError(t, err)
+			imageSize += layerSize
+		}
+	}
+	groupState := fmt.Sprintf(`{"registry":%q,"artifacts":[{"repository":"team/old","tag":["v1"],"digest":%q},{"repository":"team/new","tag":["v1"],"digest":%q}],"version":2}`,
+		source, entities["old"].Digest, entities["new"].Digest)
+	pushState(t, source+"/group/state:latest", groupState)
+	pushState(t, source+"/satellite/state:latest", `{"states":["http://`+source+`/group/state:latest"],"version":1}`)
+
+	accessLog := NewFileAccessLog(filepath.Join(t.TempDir(), "access.json"), nil)
+	require.NoError(t, accessLog.Record([]Entity{entities["old"]}))
+	process := &FetchAndReplicateStateProcess{
+		mu:             &sync.Mutex{},
+		satelliteState: "http://" + source + "/satellite/state:latest",
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry:         source,
+			SourceRegistryUserName: "robot",
+			SourceRegistryPassword: "secret",
+			RemoteRegistryURL:      local,
+			UseUnsecure:            true,
+		},
+		Replicator: NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+		// The budget holds a single image, replicating the new one evicts the old one
+		quotaConf: NewQuotaConfig(imageSize+imageSize/2, nil, accessLog),
+		stateMap: []StateMap{{
+			url:      "http://" + source + "/group/state:latest",
+			Entities: []Entity{entities["old"], entities["stale"]},
+			Version:  1,
+		}},
+		lastFullResync: time.Now(),
+	}
+
+	plan, err := process.Plan(ctx)
+	require.NoError(t, err)
+	require.Len(t, plan.States, 1)
+	statePlan := plan.States[0]
+	require.Empty(t, statePlan.Error)
+	assert.Equal(t, int64(2), statePlan.Version)
+	require.Len(t, statePlan.Delete, 1)
+	assert.Equal(t, "stale", statePlan.Delete[0].Name)
+	require.Len(t, statePlan.Evict, 1)
+	assert.Equal(t, "old", statePlan.Evict[0].Name)
+	require.Len(t, statePlan.Replicate, 1)
+	assert.Equal(t, "new", statePlan.Replicate[0].Name)
+
+	// Nothing was deleted, recorded or applied
+	for _, imageName := range []string{"old", "stale"} {
+		_, err := crane.Head(local + "/team/" + imageName + ":v1")
+		assert.NoError(t, err, imageName)
+	}
+	accesses, err := accessLog.LastAccess([]Entity{entities["old"]})
+	require.NoError(t, err)
+	assert.Contains(t, accesses, entityKey(entities["old"]))
+	assert.Equal(t, []Entity{entities["old"], entities["stale"]}, process.stateMap[0].Entities)
+	assert.Empty(t, process.stateMap[0].EvictedEntities)
+}
+
+// pushState pushes a state artifact to the reference
+func pushState(t *testing.T, reference, content string) {
+	t.Helper()
+	img, err := crane.Image(map[string][]byte{stateArtifactFile: []byte(content)})
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(img, reference))
+}
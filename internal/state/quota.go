@@ -0,0 +1,560 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+)
+
+// QuotaConfig holds the storage budget of the entities replicated to the local registry
+type QuotaConfig struct {
+	// Budget is the maximum size in bytes of the replicated entities, the storage is not limited if 0
+	Budget int64
+	// Pinned is the list of <repository>[:<tag>] glob patterns of the entities which are never evicted
+	Pinned []string
+	// AccessTracker orders the entities to evict, the least recently used ones are evicted first
+	AccessTracker AccessTracker
+	// blobCache holds the blobs of the entities held in the local registry, they are listed on every run if nil
+	blobCache *entityBlobCache
+}
+
+func NewQuotaConfig(budget int64, pinned []string, accessTracker AccessTracker) QuotaConfig {
+	return QuotaConfig{
+		Budget:        budget,
+		Pinned:        pinned,
+		AccessTracker: accessTracker,
+		blobCache:     &entityBlobCache{entries: make(map[string]cachedEntityBlobs)},
+	}
+}
+
+// entityBlobCache holds the blobs of the entities held in the local registry, keyed by entityKey along with the
+// digest they were listed for, so that the local registry is not asked for the blobs of every entity on every run
+type entityBlobCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedEntityBlobs
+}
+
+type cachedEntityBlobs struct {
+	digest string
+	blobs  map[string]int64
+}
+
+// cachedDigest returns the digest the entity is held under in the local registry, empty if it is not known
+func cachedDigest(entity Entity) string {
+	if entity.LocalDigest != "" {
+		return entity.LocalDigest
+	}
+	return entity.Digest
+}
+
+// get returns the blobs listed for the entity at the digest it is held under
+func (c *entityBlobCache) get(entity Entity) (map[string]int64, bool) {
+	digest := cachedDigest(entity)
+	if c == nil || digest == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.entries[entityKey(entity)]
+	if !ok || cached.digest != digest {
+		return nil, false
+	}
+	return cached.blobs, true
+}
+
+func (c *entityBlobCache) put(entity Entity, blobs map[string]int64) {
+	digest := cachedDigest(entity)
+	if c == nil || digest == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[entityKey(entity)] = cachedEntityBlobs{digest: digest, blobs: blobs}
+}
+
+// forget drops the entities replicated or removed since their blobs were listed
+func (c *entityBlobCache) forget(entities []Entity) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entity := range entities {
+		delete(c.entries, entityKey(entity))
+	}
+}
+
+// quotaEntity is an entity along with the blobs it is made of, keyed by digest
+type quotaEntity struct {
+	entity Entity
+	blobs  map[string]int64
+}
+
+// blobUsage counts the references to the blobs of the entities held in the local registry
+type blobUsage struct {
+	refs  map[string]int
+	sizes map[string]int64
+	used  int64
+}
+
+func newBlobUsage() *blobUsage {
+	return &blobUsage{refs: make(map[string]int), sizes: make(map[string]int64)}
+}
+
+// added returns the size the blobs would add to the usage
+func (u *blobUsage) added(blobs map[string]int64) int64 {
+	var size int64
+	for digest, blobSize := range blobs {
+		if u.refs[digest] == 0 {
+			size += blobSize
+		}
+	}
+	return size
+}
+
+func (u *blobUsage) add(blobs map[string]int64) {
+	for digest, size := range blobs {
+		if u.refs[digest] == 0 {
+			u.used += size
+			u.sizes[digest] = size
+		}
+		u.refs[digest]++
+	}
+}
+
+// remove releases the blobs and returns the size freed by the blobs no longer referenced
+func (u *blobUsage) remove(blobs map[string]int64) int64 {
+	var freed int64
+	for digest := range blobs {
+		u.refs[digest]--
+		if u.refs[digest] <= 0 {
+			delete(u.refs, digest)
+			freed += u.sizes[digest]
+			u.used -= u.sizes[digest]
+		}
+	}
+	return freed
+}
+
+// EnforceQuota keeps the local registry within the storage budget before the entities of the state at
+// index i are replicated. The size of the entities is estimated from their manifests, the blobs shared with
+// entities already held locally are counted once. When the budget would be exceeded the least recently
+// used entities which are not pinned are evicted, and the entities which still do not fit are deferred to
+// the next run. Entities evicted earlier are replicated again only if they fit without evicting others.
+// It returns the entities to replicate and the deferred ones.
+func (f *FetchAndReplicateStateProcess) EnforceQuota(ctx context.Context, i int, newEntities, replicateEntity []Entity, log *zerolog.Logger) ([]Entity, []Entity) {
+	if f.quotaConf.Budget <= 0 {
+		return replicateEntity, nil
+	}
+
+	evicted := f.evictedEntities()
+	var required, restorable []Entity
+	incoming := make(map[string]bool)
+	for _, entity := range replicateEntity {
+		incoming[entityKey(entity)] = true
+		if evictedEntity, ok := evicted[entityKey(entity)]; ok && evictedEntity.Digest == entity.Digest {
+			restorable = append(restorable, entity)
+			continue
+		}
+		required = append(required, entity)
+	}
+	// The evicted entities are only restored within the transfer windows
+	for _, entity := range f.stateMap[i].EvictedEntities {
+		if !f.replicatorConf.Bandwidth.InTransferWindow(time.Now()) {
+			break
+		}
+		if !incoming[entityKey(entity)] && containsEntity(newEntities, entity) {
+			restorable = append(restorable, entity)
+		}
+	}
+	if len(required) == 0 && len(restorable) == 0 {
+		return nil, nil
+	}
+
+	sourceOptions := registryOptions(ctx, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword)
+	usage := newBlobUsage()
+	local := f.localEntityBlobs(ctx, i, newEntities, incoming, log)
+	for _, entity := range local {
+		usage.add(entity.blobs)
+	}
+	log.Info().Msgf("Local registry uses %d of the %d bytes of the storage quota", usage.used, f.quotaConf.Budget)
+
+	candidates := f.evictionCandidates(local, log)
+	var toReplicate, deferred, toEvict []Entity
+	var evictedSize int64
+	for _, entity := range required {
+		blobs, err := f.entityBlobs(f.authConfig.SourceRegistry, entity, true, f.replicatorConf.Platforms, sourceOptions)
+		if err != nil {
+			// The entity would fail to replicate as well, it is retried in the next run
+			log.Warn().Err(err).Msgf("Failed to estimate the size of %s", entity.String())
+			toReplicate = append(toReplicate, entity)
+			continue
+		}
+		size := usage.added(blobs)
+		if size > f.quotaConf.Budget {
+			log.Warn().Msgf("%s needs %d bytes which exceeds the storage quota", entity.String(), size)
+			deferred = append(deferred, entity)
+			continue
+		}
+		for usage.used+usage.added(blobs) > f.quotaConf.Budget && len(candidates) > 0 {
+			candidate := candidates[0]
+			candidates = candidates[1:]
+			evictedSize += usage.remove(candidate.blobs)
+			toEvict = append(toEvict, candidate.entity)
+		}
+		if usage.used+usage.added(blobs) > f.quotaConf.Budget {
+			deferred = append(deferred, entity)
+			continue
+		}
+		usage.add(blobs)
+		toReplicate = append(toReplicate, entity)
+	}
+
+	restored := make(map[string]bool)
+	for _, entity := range f.sortByLastAccess(restorable, true, log) {
+		blobs, err := f.entityBlobs(f.authConfig.SourceRegistry, entity, true, f.replicatorConf.Platforms, sourceOptions)
+		if err != nil || usage.used+usage.added(blobs) > f.quotaConf.Budget {
+			continue
+		}
+		usage.add(blobs)
+		toReplicate = append(toReplicate, entity)
+		restored[entityKey(entity)] = true
+	}
+
+	// The untagged entities evicted are kept if their digest is still used by the entities held locally
+	evicting := make(map[string]bool)
+	for _, entity := range toEvict {
+		evicting[entityKey(entity)] = true
+	}
+	keptEntities := append([]Entity(nil), toReplicate...)
+	for _, candidate := range local {
+		if !evicting[entityKey(candidate.entity)] {
+			keptEntities = append(keptEntities, candidate.entity)
+		}
+	}
+	f.evict(ctx, i, newEntities, f.RemoveSharedDigests(toEvict, keptEntities, log), evictedSize, log)
+	f.mu.Lock()
+	for j := range f.stateMap {
+		var stillEvicted []Entity
+		for _, entity := range f.stateMap[j].EvictedEntities {
+			if !restored[entityKey(entity)] {
+				stillEvicted = append(stillEvicted, entity)
+			}
+		}
+		f.stateMap[j].EvictedEntities = stillEvicted
+	}
+	f.mu.Unlock()
+
+	if len(deferred) > 0 {
+		var details []string
+		for _, entity := range deferred {
+			details = append(details, entity.String())
+		}
+		f.notify(notifier.WarningLevel, fmt.Sprintf("%d entities do not fit within the storage quota of %d bytes, they would be retried in the next run", len(deferred), f.quotaConf.Budget), details, log)
+	}
+	return toReplicate, deferred
+}
+
+// localEntityBlobs returns the entities held in the local registry along with their blobs. These are the applied
+// entities of all the states, with the new entities for the state at index i, except the ones evicted, failed or
+// about to be replicated. The blobs of an entity are listed once per digest.
+func (f *FetchAndReplicateStateProcess) localEntityBlobs(ctx context.Context, i int, newEntities []Entity, incoming map[string]bool, log *zerolog.Logger) []quotaEntity {
+	options := registryOptions(ctx, f.authConfig.RemoteRegistryUserName, f.authConfig.RemoteRegistryPassword)
+	evicted := f.evictedEntities()
+	var local []quotaEntity
+	seen := make(map[string]bool)
+	for _, entity := range f.appliedEntities(i, newEntities) {
+		key := entityKey(entity)
+		if seen[key] || incoming[key] {
+			continue
+		}
+		seen[key] = true
+		if _, ok := evicted[key]; ok {
+			continue
+		}
+		blobs, ok := f.quotaConf.blobCache.get(entity)
+		if !ok {
+			var err error
+			blobs, err = f.entityBlobs(f.authConfig.RemoteRegistryURL, entity, false, nil, options)
+			if err != nil {
+				log.Debug().Err(err).Msgf("Skipping %s while listing the blobs of the local registry", entity.String())
+				continue
+			}
+			f.quotaConf.blobCache.put(entity, blobs)
+		}
+		local = append(local, quotaEntity{entity: entity, blobs: blobs})
+	}
+	return local
+}
+
+// evict deletes the entities from the local registry and marks them as evicted in every state holding them,
+// the new entities are the ones of the state at index i
+func (f *FetchAndReplicateStateProcess) evict(ctx context.Context, i int, newEntities, entities []Entity, size int64, log *zerolog.Logger) {
+	if len(entities) == 0 {
+		return
+	}
+	var deleted []Entity
+	var details []string
+	for _, entity := range entities {
+		if err := f.Replicator.DeleteReplicationEntity(ctx, []Entity{entity}); err != nil {
+			log.Error().Err(err).Msgf("Error evicting %s", entity.String())
+			continue
+		}
+		deleted = append(deleted, entity)
+		details = append(details, entity.String())
+	}
+	f.forgetAccess(deleted, log)
+
+	f.mu.Lock()
+	for j := range f.stateMap {
+		stateEntities := f.stateMap[j].Entities
+		if j == i {
+			stateEntities = newEntities
+		}
+		for _, entity := range deleted {
+			if containsEntity(stateEntities, entity) && !containsEntity(f.stateMap[j].EvictedEntities, entity) {
+				f.stateMap[j].EvictedEntities = append(f.stateMap[j].EvictedEntities, entity)
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	f.notify(notifier.WarningLevel, fmt.Sprintf("Evicted %d entities, about %d bytes, to stay within the storage quota of %d bytes", len(deleted), size, f.quotaConf.Budget), details, log)
+}
+
+// evictionCandidates returns the entities which are not pinned, least recently used first
+func (f *FetchAndReplicateStateProcess) evictionCandidates(local []quotaEntity, log *zerolog.Logger) []quotaEntity {
+	byKey := make(map[string]quotaEntity)
+	var entities []Entity
+	for _, candidate := range local {
+		if f.isPinned(candidate.entity) {
+			continue
+		}
+		byKey[entityKey(candidate.entity)] = candidate
+		entities = append(entities, candidate.entity)
+	}
+	var candidates []quotaEntity
+	for _, entity := range f.sortByLastAccess(entities, false, log) {
+		candidates = append(candidates, byKey[entityKey(entity)])
+	}
+	return candidates
+}
+
+// sortByLastAccess sorts the entities by their last access time, entities never accessed are the least recent
+func (f *FetchAndReplicateStateProcess) sortByLastAccess(entities []Entity, mostRecentFirst bool, log *zerolog.Logger) []Entity {
+	accesses := make(map[string]time.Time)
+	if f.quotaConf.AccessTracker != nil {
+		var err error
+		if accesses, err = f.quotaConf.AccessTracker.LastAccess(entities); err != nil {
+			log.Warn().Err(err).Msg("Error reading the access log, the entities are evicted in state order")
+			accesses = make(map[string]time.Time)
+		}
+	}
+	sorted := append([]Entity(nil), entities...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		accessA, accessB := accesses[entityKey(sorted[a])], accesses[entityKey(sorted[b])]
+		if mostRecentFirst {
+			return accessA.After(accessB)
+		}
+		return accessA.Before(accessB)
+	})
+	return sorted
+}
+
+// isPinned returns true if the entity matches one of the pinned patterns, either by repository or by repository and tag
+func (f *FetchAndReplicateStateProcess) isPinned(entity Entity) bool {
+	repository := entityRepository(entity)
+	for _, pattern := range f.quotaConf.Pinned {
+		if matched, _ := path.Match(pattern, repository); matched {
+			return true
+		}
+		if entity.Tag == "" {
+			continue
+		}
+		if matched, _ := path.Match(pattern, repository+":"+entity.Tag); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// appliedEntities returns the entities of the states as applied, with the new entities for the state at index i
+func (f *FetchAndReplicateStateProcess) appliedEntities(i int, newEntities []Entity) []Entity {
+	entities := append([]Entity(nil), newEntities...)
+	for j := range f.stateMap {
+		if j == i {
+			continue
+		}
+		for _, entity := range f.stateMap[j].Entities {
+			if !containsEntity(f.stateMap[j].FailedEntities, entity) {
+				entities = append(entities, entity)
+			}
+		}
+	}
+	var applied []Entity
+	for _, entity := range entities {
+		if !containsEntity(f.stateMap[i].FailedEntities, entity) {
+			applied = append(applied, entity)
+		}
+	}
+	return applied
+}
+
+// evictedEntities returns the entities evicted from the local registry keyed by entityKey
+func (f *FetchAndReplicateStateProcess) evictedEntities() map[string]Entity {
+	evicted := make(map[string]Entity)
+	for _, stateMap := range f.stateMap {
+		for _, entity := range stateMap.EvictedEntities {
+			evicted[entityKey(entity)] = entity
+		}
+	}
+	return evicted
+}
+
+// RemoveEvictedEntities removes the evicted entities from the deletion list, as they are no longer in the local registry
+func (f *FetchAndReplicateStateProcess) RemoveEvictedEntities(deleteEntity []Entity) []Entity {
+	evicted := f.evictedEntities()
+	var entities []Entity
+	for _, entity := range deleteEntity {
+		if _, ok := evicted[entityKey(entity)]; !ok {
+			entities = append(entities, entity)
+		}
+	}
+	return entities
+}
+
+// entityBlobs returns the blobs of the entity in the registry. At the source registry the digest is used when
+// known and the platform filter is applied, as only the matching images would be copied.
+func (f *FetchAndReplicateStateProcess) entityBlobs(registry string, entity Entity, source bool, platforms []v1.Platform, options []remote.Option) (map[string]int64, error) {
+	var nameOptions []name.Option
+	if f.authConfig.UseUnsecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+	reference := entity.Reference(registry)
+	if source && entity.Digest != "" {
+		reference = fmt.Sprintf("%s/%s@%s", registry, entityRepository(entity), entity.Digest)
+	}
+	ref, err := name.ParseReference(reference, nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]int64)
+	if err := collectBlobs(ref, platforms, options, blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// collectBlobs adds the manifests, configs and layers of an image, or of the images of an index matching
+// the platforms, to the blobs
+func collectBlobs(ref name.Reference, platforms []v1.Platform, options []remote.Option, blobs map[string]int64) error {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return err
+	}
+	blobs[desc.Digest.String()] = desc.Size
+	switch {
+	case desc.MediaType.IsIndex():
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		if len(platforms) > 0 {
+			idx, err = filterPlatforms(idx, platforms)
+			if err != nil {
+				return err
+			}
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, child := range manifest.Manifests {
+			if err := collectBlobs(ref.Context().Digest(child.Digest.String()), nil, options, blobs); err != nil {
+				return err
+			}
+		}
+	case desc.MediaType.IsImage():
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+		manifest, err := img.Manifest()
+		if err != nil {
+			return err
+		}
+		blobs[manifest.Config.Digest.String()] = manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			blobs[layer.Digest.String()] = layer.Size
+		}
+	}
+	return nil
+}
+
+func registryOptions(ctx context.Context, username, password string) []remote.Option {
+	return []remote.Option{
+		remote.WithAuth(authn.FromConfig(authn.AuthConfig{Username: username, Password: password})),
+		remote.WithContext(ctx),
+		remote.WithUserAgent(satelliteUserAgent),
+	}
+}
+
+// keepEntities returns the entities which are part of the kept ones
+func keepEntities(entities, kept []Entity) []Entity {
+	var result []Entity
+	for _, entity := range entities {
+		if containsEntity(kept, entity) {
+			result = append(result, entity)
+		}
+	}
+	return result
+}
+
+// recordAccess marks the entities replicated as accessed, so that they are not the first ones evicted, and drops
+// the blobs listed for them before
+func (f *FetchAndReplicateStateProcess) recordAccess(entities []Entity, log *zerolog.Logger) {
+	f.quotaConf.blobCache.forget(entities)
+	if f.quotaConf.AccessTracker == nil {
+		return
+	}
+	if err := f.quotaConf.AccessTracker.Record(entities); err != nil {
+		log.Warn().Err(err).Msg("Error updating the access log")
+	}
+}
+
+// forgetAccess drops the access times and the blobs of the entities removed from the local registry
+func (f *FetchAndReplicateStateProcess) forgetAccess(entities []Entity, log *zerolog.Logger) {
+	f.quotaConf.blobCache.forget(entities)
+	if f.quotaConf.AccessTracker == nil {
+		return
+	}
+	if err := f.quotaConf.AccessTracker.Forget(entities); err != nil {
+		log.Warn().Err(err).Msg("Error updating the access log")
+	}
+}
+
+func containsEntity(entities []Entity, entity Entity) bool {
+	key := entityKey(entity)
+	for _, e := range entities {
+		if entityKey(e) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FetchAndReplicateStateProcess) notify(level notifier.Level, message string, details []string, log *zerolog.Logger) {
+	if err := f.notifier.Notify(notifier.Notification{Source: f.name, Level: level, Message: message, Details: details}); err != nil {
+		log.Error().Err(err).Msg("Error sending notification")
+	}
+}
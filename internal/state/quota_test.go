@@ -0,0 +1,289 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/container-registry/harbor-satellite/internal/storage"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnforceQuotaEvictsLeastRecentlyUsed(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	// Four images of about 4KiB each, the first three are already replicated
+	names := []string{"old", "recent", "pinned", "new"}
+	var entities []Entity
+	var imageSize int64
+	for _, imageName := range names {
+		img, err := random.Image(1024, 4)
+		require.NoError(t, err)
+		require.NoError(t, crane.Push(img, source+"/team/"+imageName+":v1"))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		if imageName != "new" {
+			require.NoError(t, crane.Push(img, local+"/team/"+imageName+":v1"))
+		}
+		manifest, err := img.RawManifest()
+		require.NoError(t, err)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		imageSize = int64(len(manifest))
+		for _, layer := range layers {
+			size, err := layer.Size()
+			require.NoError(t, err)
+			imageSize += size
+		}
+		entities = append(entities, Entity{Name: imageName, Repository: "team", Tag: "v1", Digest: digest.String()})
+	}
+
+	accessLog := NewFileAccessLog(filepath.Join(t.TempDir(), "access.json"), nil)
+	require.NoError(t, accessLog.Record(entities[:1]))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, accessLog.Record(entities[1:3]))
+
+	// The budget fits three images, replicating the new one evicts the least recently used image which is not pinned
+	process := &FetchAndReplicateStateProcess{
+		mu:       &sync.Mutex{},
+		notifier: notifier.NewSimpleNotifier(ctx),
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry:    source,
+			RemoteRegistryURL: local,
+			UseUnsecure:       true,
+		},
+		Replicator: NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+		quotaConf:  NewQuotaConfig(3*imageSize+imageSize/2, []string{"team/pinned"}, accessLog),
+		stateMap:   []StateMap{{url: "group", Entities: entities[:3]}},
+	}
+	replicate, deferred := process.EnforceQuota(ctx, 0, entities, entities[3:], &nop)
+	assert.Empty(t, deferred)
+	require.Len(t, replicate, 1)
+	assert.Equal(t, "new", replicate[0].Name)
+
+	_, err := crane.Head(local + "/team/old:v1")
+	assert.Error(t, err)
+	for _, imageName := range []string{"recent", "pinned"} {
+		_, err := crane.Head(local + "/team/" + imageName + ":v1")
+		assert.NoError(t, err, imageName)
+	}
+	require.Len(t, process.stateMap[0].EvictedEntities, 1)
+	assert.Equal(t, "old", process.stateMap[0].EvictedEntities[0].Name)
+	assert.Empty(t, process.RemoveEvictedEntities(entities[:1]))
+}
+
+func TestEnforceQuotaRestoresEvictedEntities(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	// held is in the local registry, evicted was evicted by an earlier run
+	held, imageSize := pushQuotaImage(t, source, local, "held")
+	evicted, _ := pushQuotaImage(t, source, "", "evicted")
+	newProcess := func(budget int64) *FetchAndReplicateStateProcess {
+		return &FetchAndReplicateStateProcess{
+			mu:       &sync.Mutex{},
+			notifier: notifier.NewSimpleNotifier(ctx),
+			authConfig: FetchAndReplicateAuthConfig{
+				SourceRegistry:    source,
+				RemoteRegistryURL: local,
+				UseUnsecure:       true,
+			},
+			Replicator: NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+			quotaConf:  NewQuotaConfig(budget, nil, NewFileAccessLog(filepath.Join(t.TempDir(), "access.json"), nil)),
+			stateMap: []StateMap{{
+				url:             "group",
+				Entities:        []Entity{held, evicted},
+				EvictedEntities: []Entity{evicted},
+			}},
+		}
+	}
+
+	// The evicted entity is not restored at the expense of the held one
+	process := newProcess(imageSize + imageSize/2)
+	replicate, deferred := process.EnforceQuota(ctx, 0, []Entity{held, evicted}, nil, &nop)
+	assert.Empty(t, replicate)
+	assert.Empty(t, deferred)
+	assert.Equal(t, []Entity{evicted}, process.stateMap[0].EvictedEntities)
+	_, err := crane.Head(local + "/team/held:v1")
+	assert.NoError(t, err)
+
+	// It is restored once it fits
+	process = newProcess(2*imageSize + imageSize/2)
+	replicate, deferred = process.EnforceQuota(ctx, 0, []Entity{held, evicted}, nil, &nop)
+	assert.Equal(t, []Entity{evicted}, replicate)
+	assert.Empty(t, deferred)
+	assert.Empty(t, process.stateMap[0].EvictedEntities)
+}
+
+func TestEnforceQuotaDefersWhenOnlyPinnedEntitiesAreHeld(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	first, imageSize := pushQuotaImage(t, source, local, "first")
+	second, _ := pushQuotaImage(t, source, local, "second")
+	incoming, _ := pushQuotaImage(t, source, "", "new")
+
+	process := &FetchAndReplicateStateProcess{
+		mu:       &sync.Mutex{},
+		notifier: notifier.NewSimpleNotifier(ctx),
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry:    source,
+			RemoteRegistryURL: local,
+			UseUnsecure:       true,
+		},
+		Replicator: NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+		quotaConf:  NewQuotaConfig(2*imageSize+imageSize/2, []string{"team/*"}, nil),
+		stateMap:   []StateMap{{url: "group", Entities: []Entity{first, second}}},
+	}
+	replicate, deferred := process.EnforceQuota(ctx, 0, []Entity{first, second, incoming}, []Entity{incoming}, &nop)
+	assert.Empty(t, replicate)
+	assert.Equal(t, []Entity{incoming}, deferred)
+	assert.Empty(t, process.stateMap[0].EvictedEntities)
+	for _, imageName := range []string{"first", "second"} {
+		_, err := crane.Head(local + "/team/" + imageName + ":v1")
+		assert.NoError(t, err, imageName)
+	}
+}
+
+func TestEnforceQuotaEvictsByPullsAcrossGroups(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	// shared is held by both groups, it is counted once against the budget
+	shared, imageSize := pushQuotaImage(t, source, local, "shared")
+	old, _ := pushQuotaImage(t, source, local, "old")
+	incoming, _ := pushQuotaImage(t, source, "", "new")
+
+	// Both were replicated at the same time, shared was pulled by digest since while old was only read by the
+	// satellite itself
+	dir := t.TempDir()
+	accessLog := NewFileAccessLog(filepath.Join(dir, "access.json"), storage.NewRegistryLog(filepath.Join(dir, "registry.log"), filepath.Join(dir, "registry.offset")))
+	require.NoError(t, accessLog.Record([]Entity{old, shared}))
+	pulled := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	registryLog := fmt.Sprintf(`{"level":"info","clientIP":"10.0.0.2","method":"GET","path":"/v2/team/old/manifests/v1","statusCode":200,"headers":{"User-Agent":["%s go-containerregistry"]},"time":%q,"message":"HTTP API"}
+{"level":"info","clientIP":"10.0.0.3","method":"HEAD","path":"/v2/team/shared/manifests/%s","statusCode":200,"headers":{"User-Agent":["containerd/1.7"]},"time":%q,"message":"HTTP API"}
+{"level":"info","clientIP":"10.0.0.3","method":"GET","path":"/v2/team/old/manifests/v1","statusCode":404,"headers":{"User-Agent":["containerd/1.7"]},"time":%q,"message":"HTTP API"}
+`, satelliteUserAgent, pulled, shared.Digest, pulled, pulled)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "registry.log"), []byte(registryLog), 0600))
+
+	process := &FetchAndReplicateStateProcess{
+		mu:       &sync.Mutex{},
+		notifier: notifier.NewSimpleNotifier(ctx),
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry:    source,
+			RemoteRegistryURL: local,
+			UseUnsecure:       true,
+		},
+		Replicator: NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+		quotaConf:  NewQuotaConfig(2*imageSize+imageSize/2, nil, accessLog),
+		stateMap: []StateMap{
+			{url: "first", Entities: []Entity{shared, old}},
+			{url: "second", Entities: []Entity{shared}},
+		},
+	}
+	replicate, deferred := process.EnforceQuota(ctx, 1, []Entity{shared, incoming}, []Entity{incoming}, &nop)
+	assert.Empty(t, deferred)
+	assert.Equal(t, []Entity{incoming}, replicate)
+
+	_, err := crane.Head(local + "/team/old:v1")
+	assert.Error(t, err)
+	_, err = crane.Head(local + "/team/shared:v1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Entity{old}, process.stateMap[0].EvictedEntities)
+	assert.Empty(t, process.stateMap[1].EvictedEntities)
+}
+
+// pushQuotaImage pushes a random image of about 4KiB as team/<imageName>:v1 to the source registry, and to the
+// local registry unless empty. It returns its entity and its size in the registry.
+func pushQuotaImage(t *testing.T, source, local, imageName string) (Entity, int64) {
+	t.Helper()
+	img, err := random.Image(1024, 4)
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(img, source+"/team/"+imageName+":v1"))
+	if local != "" {
+		require.NoError(t, crane.Push(img, local+"/team/"+imageName+":v1"))
+	}
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	manifest, err := img.RawManifest()
+	require.NoError(t, err)
+	size := int64(len(manifest))
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	for _, layer := range layers {
+		layerSize, err := layer.Size()
+		require.NoError(t, err)
+		size += layerSize
+	}
+	return Entity{Name: imageName, Repository: "team", Tag: "v1", Digest: digest.String()}, size
+}
+
+func TestLocalEntityBlobsListedOncePerDigest(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+
+	// The local registry counts the manifests it serves
+	var manifestRequests atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			manifestRequests.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	local := strings.TrimPrefix(server.URL, "http://")
+
+	first, _ := pushQuotaImage(t, source, local, "first")
+	second, _ := pushQuotaImage(t, source, local, "second")
+	process := &FetchAndReplicateStateProcess{
+		mu: &sync.Mutex{},
+		authConfig: FetchAndReplicateAuthConfig{
+			RemoteRegistryURL: local,
+			UseUnsecure:       true,
+		},
+		quotaConf: NewQuotaConfig(0, nil, nil),
+		stateMap:  []StateMap{{url: "group", Entities: []Entity{first, second}}},
+	}
+
+	manifestRequests.Store(0)
+	require.Len(t, process.localEntityBlobs(ctx, 0, []Entity{first, second}, nil, &nop), 2)
+	listed := manifestRequests.Load()
+	assert.Positive(t, listed)
+	held := process.localEntityBlobs(ctx, 0, []Entity{first, second}, nil, &nop)
+	require.Len(t, held, 2)
+	assert.Equal(t, listed, manifestRequests.Load(), "the blobs are listed once per digest")
+	assert.NotEmpty(t, held[0].blobs)
+
+	// A replicated entity is listed again
+	process.recordAccess([]Entity{first}, &nop)
+	require.Len(t, process.localEntityBlobs(ctx, 0, []Entity{first, second}, nil, &nop), 2)
+	assert.Equal(t, listed+listed/2, manifestRequests.Load())
+}
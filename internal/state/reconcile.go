@@ -0,0 +1,189 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+)
+
+// isFullResyncDue returns true if the states should be reconciled against the contents of the local registry
+// in this run, which happens on the first run and then once per configured interval
+func (f *FetchAndReplicateStateProcess) isFullResyncDue() bool {
+	interval := config.GetFullResyncInterval()
+	if interval <= 0 {
+		return false
+	}
+	return f.lastFullResync.IsZero() || time.Since(f.lastFullResync) >= interval
+}
+
+// GetChangesFromRegistry computes the entities to delete and replicate by comparing the new state with the
+// contents of the local registry instead of the previously applied state. Tags of the repositories owned by
+// the state which are not part of the new state are deleted, unless they are part of another state.
+// This brings the local registry back in sync after manual deletions, disk corruption or failed pushes.
+func (f *FetchAndReplicateStateProcess) GetChangesFromRegistry(ctx context.Context, newState StateReader, oldEntities, otherEntities []Entity, log *zerolog.Logger) ([]Entity, []Entity, StateReader, error) {
+	log.Info().Msg("Getting changes from the contents of the local registry")
+	newState = f.RemoveNullTagArtifacts(newState)
+	newEntities := FetchEntitiesFromState(newState)
+
+	// The repositories owned by the state are the ones it lists now and the ones it listed before
+	repositories := make(map[string]bool)
+	for _, entity := range append(newEntities, oldEntities...) {
+		repositories[entityRepository(entity)] = true
+	}
+	var untagged []Entity
+	for _, entity := range newEntities {
+		if entity.IsUntagged() {
+			untagged = append(untagged, entity)
+		}
+	}
+	local, err := f.listLocalEntities(ctx, repositories, untagged)
+	if err != nil {
+		return nil, nil, newState, err
+	}
+
+	// An index reduced to the configured platforms is stored under another digest than the source one
+	newEntities = localDigests(newEntities, nil, oldEntities)
+	var entityToDelete []Entity
+	var entityToReplicate []Entity
+	wanted := make(map[string]bool)
+	for _, entity := range newEntities {
+		key := entityKey(entity)
+		wanted[key] = true
+		expected := entity.Digest
+		if entity.LocalDigest != "" {
+			expected = entity.LocalDigest
+		}
+		localEntity, exists := local[key]
+		if !exists || (expected != "" && localEntity.Digest != expected) {
+			entityToReplicate = append(entityToReplicate, entity)
+		}
+	}
+	kept := make(map[string]bool)
+	for _, entity := range otherEntities {
+		kept[entityKey(entity)] = true
+	}
+	for key, entity := range local {
+		if !wanted[key] && !kept[key] {
+			entityToDelete = append(entityToDelete, entity)
+		}
+	}
+
+	log.Info().Msgf("Local registry holds %d tags for the state, %d missing or outdated, %d not expected", len(local), len(entityToReplicate), len(entityToDelete))
+	return entityToDelete, entityToReplicate, newState, nil
+}
+
+// localDigests returns the entities with the local digest of the ones replicated in this run or, if their digest
+// did not change, the local digest recorded in a previous run
+func localDigests(entities, replicated, previous []Entity) []Entity {
+	known := make(map[string]Entity, len(replicated)+len(previous))
+	for _, entity := range previous {
+		known[entityKey(entity)] = entity
+	}
+	for _, entity := range replicated {
+		known[entityKey(entity)] = entity
+	}
+	result := make([]Entity, 0, len(entities))
+	for _, entity := range entities {
+		if other, ok := known[entityKey(entity)]; ok && other.Digest == entity.Digest {
+			entity.LocalDigest = other.LocalDigest
+		}
+		result = append(result, entity)
+	}
+	return result
+}
+
+// listLocalEntities lists the tags, with their digest, of the given repositories in the local registry
+// using the distribution API. Repositories missing from the catalog are skipped. As untagged manifests
+// can not be listed, the untagged entities are looked up by digest. The entities are keyed by entityKey.
+func (f *FetchAndReplicateStateProcess) listLocalEntities(ctx context.Context, repositories map[string]bool, untagged []Entity) (map[string]Entity, error) {
+	options := []remote.Option{
+		remote.WithAuth(authn.FromConfig(authn.AuthConfig{
+			Username: f.authConfig.RemoteRegistryUserName,
+			Password: f.authConfig.RemoteRegistryPassword,
+		})),
+		remote.WithContext(ctx),
+		remote.WithUserAgent(satelliteUserAgent),
+	}
+	var nameOptions []name.Option
+	if f.authConfig.UseUnsecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+	registry, err := name.NewRegistry(f.authConfig.RemoteRegistryURL, nameOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local registry: %w", err)
+	}
+	catalog, err := remote.Catalog(ctx, registry, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the repositories of the local registry: %w", err)
+	}
+
+	local := make(map[string]Entity)
+	for _, repository := range catalog {
+		if !repositories[repository] {
+			continue
+		}
+		repo := registry.Repo(repository)
+		tags, err := remote.List(repo, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the tags of %s: %w", repository, err)
+		}
+		repositoryName, imageName := splitRepository(repository)
+		for _, tag := range tags {
+			// The signatures, SBOMs and attestations are replicated along with the images they are attached to
+			if isLegacyReferrerTag(tag) {
+				continue
+			}
+			desc, err := remote.Head(repo.Tag(tag), options...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the digest of %s:%s: %w", repository, tag, err)
+			}
+			entity := Entity{
+				Name:       imageName,
+				Repository: repositoryName,
+				Tag:        tag,
+				Digest:     desc.Digest.String(),
+			}
+			local[entityKey(entity)] = entity
+		}
+	}
+	for _, entity := range untagged {
+		if _, err := remote.Head(registry.Repo(entityRepository(entity)).Digest(entity.Digest), options...); err == nil {
+			local[entityKey(entity)] = entity
+		}
+	}
+	return local, nil
+}
+
+// entityRepository returns the path of the repository of the entity in the registry
+func entityRepository(entity Entity) string {
+	return entity.GetRepository() + "/" + entity.GetName()
+}
+
+// splitRepository splits a repository path into the repository and the image name of an entity
+func splitRepository(repository string) (string, string) {
+	i := strings.LastIndex(repository, "/")
+	if i < 0 {
+		return "", repository
+	}
+	return repository[:i], repository[i+1:]
+}
+
+// isLegacyReferrerTag returns true if the tag is used by cosign to attach an artifact to an image
+func isLegacyReferrerTag(tag string) bool {
+	if !strings.HasPrefix(tag, "sha256-") {
+		return false
+	}
+	for _, suffix := range legacyReferrerSuffixes {
+		if strings.HasSuffix(tag, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFullResyncKeepsPlatformFilteredIndexes(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	var idx v1.ImageIndex = empty.Index
+	for _, platform := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		img, err := random.Image(256, 1)
+		require.NoError(t, err)
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+	}
+	ref, err := name.ParseReference(source + "/team/server:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, idx))
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+
+	replicatorConfig, err := NewReplicatorConfig(1, 1, []string{"linux/amd64"}, false)
+	require.NoError(t, err)
+	replicator := NewBasicReplicator("", "", source, local, "", "", true, replicatorConfig)
+	entity := Entity{Repository: "team", Name: "server", Tag: "v1", Digest: digest.String()}
+	result, err := replicator.Replicate(ctx, []Entity{entity})
+	require.NoError(t, err)
+	require.Len(t, result.Replicated, 1)
+	localDigest, err := crane.Digest(local + "/team/server:v1")
+	require.NoError(t, err)
+	assert.NotEqual(t, digest.String(), localDigest)
+	assert.Equal(t, localDigest, result.Replicated[0].LocalDigest)
+
+	var state StateReader = &State{Registry: source}
+	state.SetArtifacts([]ArtifactReader{NewArtifact(false, "team/server", []string{"v1"}, digest.String(), "IMAGE")})
+	processed, err := ProcessState(&state)
+	require.NoError(t, err)
+	applied := localDigests(FetchEntitiesFromState(*processed), result.Replicated, nil)
+
+	process := &FetchAndReplicateStateProcess{
+		authConfig: FetchAndReplicateAuthConfig{RemoteRegistryURL: local, UseUnsecure: true},
+	}
+	deleteEntity, replicateEntity, _, err := process.GetChangesFromRegistry(ctx, *processed, applied, nil, &nop)
+	require.NoError(t, err)
+	assert.Empty(t, deleteEntity)
+	assert.Empty(t, replicateEntity, "the filtered index is not replicated again")
+}
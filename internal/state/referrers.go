@@ -0,0 +1,93 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// legacyReferrerSuffixes are the suffixes of the tags used by cosign to attach signatures,
+// attestations and SBOMs to an image before the OCI referrers API was available
+var legacyReferrerSuffixes = []string{"sig", "att", "sbom"}
+
+// copyReferrers copies the manifests referring to the digest, such as signatures, SBOMs and attestations,
+// from the source repository to the destination repository. Both the OCI referrers API and the legacy
+// sha256-<digest>.<suffix> tags are looked up. Referrers of referrers, e.g. the signature of an SBOM,
+// are copied as well, visited keeps track of the digests already processed.
+func (r *BasicReplicator) copyReferrers(ctx context.Context, src, dst name.Repository, digest v1.Hash, pullOptions, pushOptions []remote.Option, visited map[v1.Hash]bool) error {
+	log := logger.FromContext(ctx)
+	if visited[digest] {
+		return nil
+	}
+	visited[digest] = true
+
+	referrers, err := remote.Referrers(src.Digest(digest.String()), pullOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to list referrers of %s: %w", digest.String(), err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, referrer := range manifest.Manifests {
+		log.Info().Msgf("Copying referrer %s of type %s attached to %s", referrer.Digest.String(), referrer.ArtifactType, digest.String())
+		desc, err := remote.Get(src.Digest(referrer.Digest.String()), pullOptions...)
+		if err != nil {
+			return err
+		}
+		if err := r.copyReferrer(ctx, desc, dst.Digest(referrer.Digest.String()), pushOptions); err != nil {
+			return err
+		}
+		if err := r.copyReferrers(ctx, src, dst, referrer.Digest, pullOptions, pushOptions, visited); err != nil {
+			return err
+		}
+	}
+
+	for _, suffix := range legacyReferrerSuffixes {
+		tag := fmt.Sprintf("%s-%s.%s", digest.Algorithm, digest.Hex, suffix)
+		desc, err := remote.Get(src.Tag(tag), pullOptions...)
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return err
+		}
+		log.Info().Msgf("Copying legacy referrer %s attached to %s", tag, digest.String())
+		if err := r.copyReferrer(ctx, desc, dst.Tag(tag), pushOptions); err != nil {
+			return err
+		}
+		if err := r.copyReferrers(ctx, src, dst, desc.Digest, pullOptions, pushOptions, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyReferrer copies a single referrer manifest as is, the platform filter is not applied to referrers.
+// Referrers are small and their blobs are not staged.
+func (r *BasicReplicator) copyReferrer(ctx context.Context, desc *remote.Descriptor, dst name.Reference, options []remote.Option) error {
+	switch {
+	case desc.MediaType.IsIndex():
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return err
+		}
+		return r.copyIndex(ctx, idx, dst, nil, options)
+	case desc.MediaType.IsImage():
+		img, err := desc.Image()
+		if err != nil {
+			return err
+		}
+		return r.copyImage(ctx, img, dst, nil, options)
+	default:
+		return fmt.Errorf("unsupported media type %s for referrer %s", desc.MediaType, desc.Digest.String())
+	}
+}
@@ -1,12 +1,15 @@
 package state
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
 	"github.com/container-registry/harbor-satellite/internal/logger"
@@ -61,7 +64,11 @@ func (z *ZtrProcess) Execute(ctx context.Context) error {
 
 	// Register the satellite
 	stateConfig, err := RegisterSatellite(config.GetGroundControlURL(), ZeroTouchRegistrationRoute, config.GetToken(), ctx)
-	if err != nil {
+	if errors.Is(err, ErrCertificateNotSaved) {
+		// The registration went through, the state config is applied although Ground Control rejects the robot
+		// account until the certificate expires
+		log.Error().Err(err).Msg("Satellite registered without its certificate, delete it in Ground Control and register it again with a new token")
+	} else if err != nil {
 		log.Error().Msgf("Failed to register satellite: %v", err)
 		return err
 	}
@@ -176,27 +183,57 @@ func (z *ZtrProcess) loadConfig() ([]error, []config.Warning) {
 	return config.InitConfig(config.DefaultConfigPath)
 }
 
+// RegisterSatellite exchanges the token for the state config of the satellite along with a client certificate
+// for the key generated by the satellite, saved in the identity directory
 func RegisterSatellite(groundControlURL, path, token string, ctx context.Context) (config.StateConfig, error) {
-	ztrURL := fmt.Sprintf("%s/%s/%s", groundControlURL, path, token)
+	ztrURL := fmt.Sprintf("%s/%s", groundControlURL, path)
+	identity := NewIdentity(config.GetIdentityDir())
+	csr, err := identity.CertificateRequest()
+	if err != nil {
+		return config.StateConfig{}, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"token": token, "csr": csr})
+	if err != nil {
+		return config.StateConfig{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
 	client := &http.Client{}
 
 	// Create a new request for the Zero Touch Registration of satellite
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ztrURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ztrURL, bytes.NewReader(body))
 	if err != nil {
 		return config.StateConfig{}, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	response, err := client.Do(req)
 	if err != nil {
 		return config.StateConfig{}, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusOK {
 		return config.StateConfig{}, fmt.Errorf("failed to register satellite: %s", response.Status)
 	}
 
-	var authResponse config.StateConfig
+	var authResponse struct {
+		config.StateConfig
+		Certificate string `json:"certificate"`
+	}
 	if err := json.NewDecoder(response.Body).Decode(&authResponse); err != nil {
 		return config.StateConfig{}, fmt.Errorf("failed to decode response: %w", err)
 	}
+	// Ground Control only issues a certificate if it has a CA for the satellites. The token is spent, so the
+	// certificate is saved before anything else and the state config is returned even if it could not be.
+	if authResponse.Certificate != "" {
+		var saveErr error
+		for attempt := 0; attempt < certificateSaveAttempts; attempt++ {
+			if saveErr = identity.SaveCertificate(authResponse.Certificate); saveErr == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+		if saveErr != nil {
+			return authResponse.StateConfig, fmt.Errorf("%w: %w", ErrCertificateNotSaved, saveErr)
+		}
+	}
 
-	return authResponse, nil
+	return authResponse.StateConfig, nil
 }
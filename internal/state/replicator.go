@@ -2,22 +2,76 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/container-registry/harbor-satellite/internal/logger"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 type Replicator interface {
 	// Replicate copies images from the source registry to the local registry.
-	Replicate(ctx context.Context, replicationEntities []Entity) error
+	// It returns a result listing the entities that were replicated and the ones that failed.
+	Replicate(ctx context.Context, replicationEntities []Entity) (ReplicationResult, error)
 	// DeleteReplicationEntity deletes the image from the local registry.
 	DeleteReplicationEntity(ctx context.Context, replicationEntity []Entity) error
 }
 
+// ReplicatorConfig holds the tunables of the replicator
+type ReplicatorConfig struct {
+	// MaxConcurrency is the maximum number of entities replicated at the same time
+	MaxConcurrency int
+	// MaxConcurrencyPerRegistry is the maximum number of entities replicated at the same time from a single registry
+	MaxConcurrencyPerRegistry int
+	// Platforms is the list of platforms kept when replicating multi-arch images, all platforms are kept if empty
+	Platforms []v1.Platform
+	// ReplicateReferrers enables the replication of the signatures, SBOMs and attestations of the images
+	ReplicateReferrers bool
+	// Bandwidth limits the bandwidth of the pulls and the time of day of the large ones
+	Bandwidth BandwidthConfig
+	// StagingDir is the directory the layers are downloaded to before being pushed, so that interrupted
+	// downloads are resumed. Layers are streamed from the source registry if empty.
+	StagingDir string
+	// DownloadRetries is the number of times an interrupted layer download is resumed before the entity fails
+	DownloadRetries int
+	// limiter is created along with the config, so that the replicators rebuilt when the credentials change keep
+	// counting the transfers still running against the same registries
+	limiter *registryLimiter
+}
+
+// NewReplicatorConfig creates the replicator config, platforms are expected in the os/arch[/variant] format
+func NewReplicatorConfig(maxConcurrency, maxConcurrencyPerRegistry int, platforms []string, replicateReferrers bool) (ReplicatorConfig, error) {
+	replicatorConfig := ReplicatorConfig{
+		MaxConcurrency:            maxConcurrency,
+		MaxConcurrencyPerRegistry: maxConcurrencyPerRegistry,
+		ReplicateReferrers:        replicateReferrers,
+	}
+	for _, p := range platforms {
+		platform, err := v1.ParsePlatform(p)
+		if err != nil {
+			return ReplicatorConfig{}, fmt.Errorf("invalid platform %s: %w", p, err)
+		}
+		replicatorConfig.Platforms = append(replicatorConfig.Platforms, *platform)
+	}
+	replicatorConfig.limiter = newRegistryLimiter(replicatorConfig.perRegistryLimit())
+	return replicatorConfig, nil
+}
+
+// perRegistryLimit returns the maximum number of entities replicated at the same time from a single registry,
+// which is never above the global limit
+func (c ReplicatorConfig) perRegistryLimit() int {
+	limit := max(c.MaxConcurrency, 1)
+	if c.MaxConcurrencyPerRegistry > 0 && c.MaxConcurrencyPerRegistry < limit {
+		limit = c.MaxConcurrencyPerRegistry
+	}
+	return limit
+}
+
 type BasicReplicator struct {
 	useUnsecure       bool
 	sourceUsername    string
@@ -26,9 +80,21 @@ type BasicReplicator struct {
 	remoteRegistryURL string
 	remoteUsername    string
 	remotePassword    string
+	config            ReplicatorConfig
+	blobs             *blobLocator
+	// bandwidth is shared by all the pulls, nil if the bandwidth is not limited
+	bandwidth *bandwidthLimiter
+	// stager stages the layers on disk, nil if the layers are streamed
+	stager *blobStager
 }
 
-func NewBasicReplicator(sourceUsername, sourcePassword, sourceRegistry, remoteURL, remoteUsername, remotePassword string, useUnsecure bool) Replicator {
+func NewBasicReplicator(sourceUsername, sourcePassword, sourceRegistry, remoteURL, remoteUsername, remotePassword string, useUnsecure bool, replicatorConfig ReplicatorConfig) Replicator {
+	if replicatorConfig.MaxConcurrency <= 0 {
+		replicatorConfig.MaxConcurrency = 1
+	}
+	if replicatorConfig.limiter == nil {
+		replicatorConfig.limiter = newRegistryLimiter(replicatorConfig.perRegistryLimit())
+	}
 	return &BasicReplicator{
 		sourceUsername:    sourceUsername,
 		sourcePassword:    sourcePassword,
@@ -37,15 +103,22 @@ func NewBasicReplicator(sourceUsername, sourcePassword, sourceRegistry, remoteUR
 		sourceRegistry:    sourceRegistry,
 		remoteUsername:    remoteUsername,
 		remotePassword:    remotePassword,
+		config:            replicatorConfig,
+		blobs:             newBlobLocator(),
+		bandwidth:         newBandwidthLimiter(replicatorConfig.Bandwidth.Limit),
+		stager:            newBlobStager(replicatorConfig.StagingDir, replicatorConfig.DownloadRetries),
 	}
 }
 
 // Entity represents an image or artifact which needs to be handled by the replicator
 type Entity struct {
-	Name       string
-	Repository string
-	Tag        string
-	Digest     string
+	Name       string `json:"name"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest,omitempty"`
+	// LocalDigest is the digest of the manifest in the local registry when it differs from the source one,
+	// i.e. for an index reduced to the configured platforms
+	LocalDigest string `json:"local_digest,omitempty"`
 }
 
 func (e Entity) GetName() string {
@@ -60,9 +133,80 @@ func (e Entity) GetTag() string {
 	return e.Tag
 }
 
+// IsUntagged returns true if the entity is only referenced by its digest
+func (e Entity) IsUntagged() bool {
+	return e.Tag == ""
+}
+
+// Reference returns the reference of the entity in the registry, registry/repository/name:tag or
+// registry/repository/name@digest for untagged entities
+func (e Entity) Reference(registry string) string {
+	if e.IsUntagged() {
+		return fmt.Sprintf("%s/%s/%s@%s", registry, e.Repository, e.Name, e.Digest)
+	}
+	return fmt.Sprintf("%s/%s/%s:%s", registry, e.Repository, e.Name, e.Tag)
+}
+
+// String returns repository/name:tag or repository/name@digest for untagged entities
+func (e Entity) String() string {
+	if e.IsUntagged() {
+		return fmt.Sprintf("%s/%s@%s", e.Repository, e.Name, e.Digest)
+	}
+	return fmt.Sprintf("%s/%s:%s", e.Repository, e.Name, e.Tag)
+}
+
+// FailedEntity is an entity which could not be replicated along with the reason of the failure
+type FailedEntity struct {
+	Entity Entity
+	Err    error
+}
+
+// ReplicationResult is the summary of a replication run
+type ReplicationResult struct {
+	// Replicated is the list of entities successfully replicated
+	Replicated []Entity
+	// Failed is the list of entities which could not be replicated
+	Failed []FailedEntity
+}
+
+// HasFailures returns true if any of the entities failed to replicate
+func (r ReplicationResult) HasFailures() bool {
+	return len(r.Failed) > 0
+}
+
+// FailedEntities returns the entities which could not be replicated
+func (r ReplicationResult) FailedEntities() []Entity {
+	var entities []Entity
+	for _, failed := range r.Failed {
+		entities = append(entities, failed.Entity)
+	}
+	return entities
+}
+
+// Err joins the errors of all the failed entities, returns nil if there are no failures
+func (r ReplicationResult) Err() error {
+	var errs []error
+	for _, failed := range r.Failed {
+		errs = append(errs, fmt.Errorf("%s: %w", failed.Entity.String(), failed.Err))
+	}
+	return errors.Join(errs...)
+}
+
+type replicationOutcome struct {
+	entity Entity
+	err    error
+}
+
 // Replicate replicates images from the source registry to the Zot registry.
-func (r *BasicReplicator) Replicate(ctx context.Context, replicationEntities []Entity) error {
+// The entities are replicated by a pool of workers bounded by the configured concurrency, and a failure
+// of one entity does not stop the replication of the others.
+func (r *BasicReplicator) Replicate(ctx context.Context, replicationEntities []Entity) (ReplicationResult, error) {
 	log := logger.FromContext(ctx)
+	var result ReplicationResult
+	if len(replicationEntities) == 0 {
+		return result, nil
+	}
+
 	pullAuthConfig := authn.FromConfig(authn.AuthConfig{
 		Username: r.sourceUsername,
 		Password: r.sourcePassword,
@@ -72,37 +216,132 @@ func (r *BasicReplicator) Replicate(ctx context.Context, replicationEntities []E
 		Password: r.remotePassword,
 	})
 
-	pullOptions := []crane.Option{crane.WithAuth(pullAuthConfig), crane.WithContext(ctx)}
-	pushOptions := []crane.Option{crane.WithAuth(pushAuthConfig), crane.WithContext(ctx)}
+	pullOptions := []crane.Option{crane.WithAuth(pullAuthConfig), crane.WithContext(ctx), crane.WithUserAgent(satelliteUserAgent)}
+	pushOptions := []crane.Option{crane.WithAuth(pushAuthConfig), crane.WithContext(ctx), crane.WithUserAgent(satelliteUserAgent)}
 
 	if r.useUnsecure {
 		pullOptions = append(pullOptions, crane.Insecure)
 		pushOptions = append(pushOptions, crane.Insecure)
 	}
 
-	for _, replicationEntity := range replicationEntities {
+	r.stager.prune(ctx)
+	workers := min(r.config.MaxConcurrency, len(replicationEntities))
+	log.Info().Msgf("Replicating %d entities with %d workers", len(replicationEntities), workers)
 
-		log.Info().Msgf("Pulling image %s from repository %s at registry %s with tag %s", replicationEntity.GetName(), replicationEntity.GetRepository(), r.sourceRegistry, replicationEntity.GetTag())
-		// Pull the image from the source registry
-		srcImage, err := crane.Pull(fmt.Sprintf("%s/%s/%s:%s", r.sourceRegistry, replicationEntity.GetRepository(), replicationEntity.GetName(), replicationEntity.GetTag()), pullOptions...)
-		if err != nil {
-			log.Error().Msgf("Failed to pull image: %v", err)
-			return err
+	jobs := make(chan Entity)
+	outcomes := make(chan replicationOutcome, len(replicationEntities))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entity := range jobs {
+				replicated, err := r.replicateEntity(ctx, entity, pullAuthConfig, pullOptions, pushOptions)
+				outcomes <- replicationOutcome{entity: replicated, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, entity := range replicationEntities {
+			select {
+			case jobs <- entity:
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
 
-		// Convert Docker manifest to OCI manifest
-		ociImage := mutate.MediaType(srcImage, types.OCIManifestSchema1)
+	wg.Wait()
+	close(outcomes)
 
-		// Push the converted OCI image to the Zot registry
-		err = crane.Push(ociImage, fmt.Sprintf("%s/%s/%s:%s", r.remoteRegistryURL, replicationEntity.GetRepository(), replicationEntity.GetName(), replicationEntity.GetTag()), pushOptions...)
-		if err != nil {
-			log.Error().Msgf("Failed to push image: %v", err)
-			return err
+	processed := make(map[string]bool, len(replicationEntities))
+	for outcome := range outcomes {
+		processed[entityKey(outcome.entity)] = true
+		if outcome.err != nil {
+			result.Failed = append(result.Failed, FailedEntity{Entity: outcome.entity, Err: outcome.err})
+			continue
+		}
+		result.Replicated = append(result.Replicated, outcome.entity)
+	}
+	// Entities never handed to a worker because the context was cancelled are reported as failed
+	for _, entity := range replicationEntities {
+		if !processed[entityKey(entity)] {
+			result.Failed = append(result.Failed, FailedEntity{Entity: entity, Err: ctx.Err()})
 		}
-		log.Info().Msgf("Image %s pushed successfully", replicationEntity.GetName())
+	}
 
+	log.Info().Msgf("Replication finished: %d replicated, %d failed", len(result.Replicated), len(result.Failed))
+	return result, result.Err()
+}
+
+// replicateEntity pulls a single entity from the source registry and pushes it to the local registry. It returns
+// the entity along with the digest of the manifest pushed if it differs from the source one.
+func (r *BasicReplicator) replicateEntity(ctx context.Context, replicationEntity Entity, pullAuth authn.Authenticator, pullOptions, pushOptions []crane.Option) (Entity, error) {
+	log := logger.FromContext(ctx)
+	pull := crane.GetOptions(pullOptions...)
+	push := crane.GetOptions(pushOptions...)
+	// The pulls share the global bandwidth limit and each transfer is limited on top of it
+	pullTransport := newMeteredTransport(newThrottledTransport(pull.Transport, r.bandwidth, newBandwidthLimiter(r.config.Bandwidth.PerTransferLimit)))
+	pull.Remote = append(pull.Remote, remote.WithTransport(pullTransport))
+	// When the state pins a digest the source is fetched by digest, so that the exact manifest listed
+	// in the state is replicated even if the tag was moved in the meantime
+	source := replicationEntity.Reference(r.sourceRegistry)
+	if replicationEntity.Digest != "" {
+		source = fmt.Sprintf("%s/%s/%s@%s", r.sourceRegistry, replicationEntity.GetRepository(), replicationEntity.GetName(), replicationEntity.Digest)
 	}
-	return nil
+	srcRef, err := name.ParseReference(source, pull.Name...)
+	if err != nil {
+		return replicationEntity, fmt.Errorf("invalid source reference: %w", err)
+	}
+	registry := srcRef.Context().RegistryStr()
+	if err := r.config.limiter.acquire(ctx, registry); err != nil {
+		return replicationEntity, err
+	}
+	defer r.config.limiter.release(registry)
+	// Untagged entities are pushed by digest so that digest pinned pulls work against the local registry
+	dstRef, err := name.ParseReference(replicationEntity.Reference(r.remoteRegistryURL), push.Name...)
+	if err != nil {
+		return replicationEntity, fmt.Errorf("invalid destination reference: %w", err)
+	}
+
+	// The layers are staged on disk before being pushed, the staged layers are kept for the next run if the entity fails
+	stagingSource, err := r.stager.newSource(ctx, srcRef.Context(), pullAuth, pullTransport)
+	if err != nil {
+		return replicationEntity, err
+	}
+
+	log.Info().Msgf("Fetching %s from registry %s", replicationEntity.String(), r.sourceRegistry)
+	// Only the manifest is fetched here, layers are pulled lazily when they are missing at the destination
+	desc, err := remote.Get(srcRef, pull.Remote...)
+	if err != nil {
+		log.Error().Msgf("Failed to pull image: %v", err)
+		return replicationEntity, err
+	}
+
+	// Copy the manifest as is to the Zot registry, preserving its media type and digest
+	pushed, digests, err := r.copyDescriptor(ctx, desc, dstRef, stagingSource, push.Remote)
+	if err != nil {
+		log.Error().Msgf("Failed to push image: %v", err)
+		return replicationEntity, err
+	}
+
+	// Copy the signatures, SBOMs and attestations attached to the copied manifests
+	if r.config.ReplicateReferrers {
+		for _, digest := range digests {
+			if err := r.copyReferrers(ctx, srcRef.Context(), dstRef.Context(), digest, pull.Remote, push.Remote, map[v1.Hash]bool{}); err != nil {
+				log.Error().Msgf("Failed to replicate referrers of %s: %v", digest.String(), err)
+				return replicationEntity, err
+			}
+		}
+	}
+	r.stager.release(stagingSource)
+	log.Info().Msgf("Image %s pushed successfully with digest %s", replicationEntity.GetName(), pushed.String())
+	if pushed != desc.Digest {
+		replicationEntity.LocalDigest = pushed.String()
+	}
+	return replicationEntity, nil
 }
 
 func (r *BasicReplicator) DeleteReplicationEntity(ctx context.Context, replicationEntity []Entity) error {
@@ -112,15 +351,15 @@ func (r *BasicReplicator) DeleteReplicationEntity(ctx context.Context, replicati
 		Password: r.remotePassword,
 	})
 
-	options := []crane.Option{crane.WithAuth(auth), crane.WithContext(ctx)}
+	options := []crane.Option{crane.WithAuth(auth), crane.WithContext(ctx), crane.WithUserAgent(satelliteUserAgent)}
 	if r.useUnsecure {
 		options = append(options, crane.Insecure)
 	}
 
 	for _, entity := range replicationEntity {
-		log.Info().Msgf("Deleting image %s from repository %s at registry %s with tag %s", entity.GetName(), entity.GetRepository(), r.remoteRegistryURL, entity.GetTag())
+		log.Info().Msgf("Deleting %s from registry %s", entity.String(), r.remoteRegistryURL)
 
-		err := crane.Delete(fmt.Sprintf("%s/%s/%s:%s", r.remoteRegistryURL, entity.GetRepository(), entity.GetName(), entity.GetTag()), options...)
+		err := crane.Delete(entity.Reference(r.remoteRegistryURL), options...)
 		if err != nil {
 			log.Error().Msgf("Failed to delete image: %v", err)
 			return err
@@ -130,3 +369,12 @@ func (r *BasicReplicator) DeleteReplicationEntity(ctx context.Context, replicati
 
 	return nil
 }
+
+// entityKey returns the key identifying an entity in the state, the repository is part of the key as
+// images with the same name may live in different repositories. Untagged entities are keyed by digest.
+func entityKey(entity Entity) string {
+	if entity.IsUntagged() {
+		return entity.Repository + "/" + entity.Name + "@" + entity.Digest
+	}
+	return entity.Repository + "/" + entity.Name + "|" + entity.Tag
+}
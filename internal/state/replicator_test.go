@@ -0,0 +1,193 @@
+package state
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRegistry starts an in-process registry and returns its host
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestReplicateNestedRepositories(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	// Images with the same name in repositories of different depths
+	repositories := []string{
+		"team/server",
+		"team/platform/server",
+		"team/platform/api/server",
+	}
+	digests := make(map[string]string)
+	for _, repository := range repositories {
+		img, err := random.Image(256, 2)
+		require.NoError(t, err)
+		require.NoError(t, crane.Push(img, source+"/"+repository+":v1"))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		digests[repository] = digest.String()
+	}
+
+	var state StateReader = &State{Registry: source}
+	var artifacts []ArtifactReader
+	for _, repository := range repositories {
+		artifacts = append(artifacts, NewArtifact(false, repository, []string{"v1"}, digests[repository], "IMAGE"))
+	}
+	state.SetArtifacts(artifacts)
+	processed, err := ProcessState(&state)
+	require.NoError(t, err)
+
+	entities := FetchEntitiesFromState(*processed)
+	require.Len(t, entities, len(repositories))
+
+	replicator := NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 2})
+	result, err := replicator.Replicate(ctx, entities)
+	require.NoError(t, err)
+	assert.Len(t, result.Replicated, len(repositories))
+
+	for _, repository := range repositories {
+		digest, err := crane.Digest(local + "/" + repository + ":v1")
+		require.NoError(t, err, repository)
+		assert.Equal(t, digests[repository], digest, repository)
+	}
+
+	// Removing one of the images from the state only deletes that image
+	process := &FetchAndReplicateStateProcess{}
+	var newState StateReader = &State{Registry: source}
+	newState.SetArtifacts([]ArtifactReader{
+		NewArtifact(false, "team/server", []string{"v1"}, digests["team/server"], "IMAGE"),
+		NewArtifact(false, "team/platform/server", []string{"v1"}, digests["team/platform/server"], "IMAGE"),
+	})
+	newProcessed, err := ProcessState(&newState)
+	require.NoError(t, err)
+	deleteEntity, replicateEntity, _ := process.GetChanges(*newProcessed, &nop, entities)
+	assert.Empty(t, replicateEntity)
+	require.Len(t, deleteEntity, 1)
+	assert.Equal(t, "team/platform/api", deleteEntity[0].Repository)
+	assert.Equal(t, "server", deleteEntity[0].Name)
+
+	require.NoError(t, replicator.DeleteReplicationEntity(ctx, deleteEntity))
+	_, err = crane.Digest(local + "/team/platform/api/server:v1")
+	assert.Error(t, err)
+	_, err = crane.Digest(local + "/team/platform/server:v1")
+	assert.NoError(t, err)
+}
+
+func TestReplicateUntaggedArtifacts(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+	local := newTestRegistry(t)
+
+	img, err := random.Image(256, 2)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(img, source+"/team/platform/server@"+digest.String()))
+
+	process := &FetchAndReplicateStateProcess{}
+	var state StateReader = &State{Registry: source}
+	state.SetArtifacts([]ArtifactReader{
+		NewArtifact(false, "team/platform/server", nil, digest.String(), "IMAGE"),
+		NewArtifact(false, "team/platform/empty", nil, "", "IMAGE"),
+	})
+	processed, err := ProcessState(&state)
+	require.NoError(t, err)
+	deleteEntity, replicateEntity, newState := process.GetChanges(*processed, &nop, nil)
+	assert.Empty(t, deleteEntity)
+	require.Len(t, replicateEntity, 1)
+	assert.True(t, replicateEntity[0].IsUntagged())
+	assert.Equal(t, "team/platform/server@"+digest.String(), replicateEntity[0].String())
+
+	replicator := NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1})
+	_, err = replicator.Replicate(ctx, replicateEntity)
+	require.NoError(t, err)
+	_, err = crane.Head(local + "/team/platform/server@" + digest.String())
+	require.NoError(t, err)
+
+	// The untagged entity is tracked by digest and deleted by digest once removed from the state
+	var emptyState StateReader = &State{Registry: source}
+	deleteEntity, replicateEntity, _ = process.GetChanges(emptyState, &nop, FetchEntitiesFromState(newState))
+	assert.Empty(t, replicateEntity)
+	require.Len(t, deleteEntity, 1)
+	require.NoError(t, replicator.DeleteReplicationEntity(ctx, deleteEntity))
+	_, err = crane.Head(local + "/team/platform/server@" + digest.String())
+	assert.Error(t, err)
+}
+
+func TestReplicateLimitsTransfersPerRegistry(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	// The source registry records the highest number of manifests fetched at the same time
+	var inFlight, highest atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/manifests/") {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				seen := highest.Load()
+				if current <= seen || highest.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	source := strings.TrimPrefix(server.URL, "http://")
+	local := newTestRegistry(t)
+
+	var entities []Entity
+	for _, tag := range []string{"v1", "v2", "v3", "v4", "v5", "v6"} {
+		img, err := random.Image(64, 1)
+		require.NoError(t, err)
+		require.NoError(t, crane.Push(img, source+"/team/server:"+tag))
+		entities = append(entities, Entity{Repository: "team", Name: "server", Tag: tag})
+	}
+	highest.Store(0)
+
+	replicatorConfig, err := NewReplicatorConfig(4, 2, nil, false)
+	require.NoError(t, err)
+	// The replicators rebuilt with new credentials share the limit of the config
+	replicators := []Replicator{
+		NewBasicReplicator("", "", source, local, "", "", true, replicatorConfig),
+		NewBasicReplicator("", "", source, local, "", "", true, replicatorConfig),
+	}
+	var wg sync.WaitGroup
+	for i, replicator := range replicators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := replicator.Replicate(ctx, entities[i*3:(i+1)*3])
+			assert.NoError(t, err)
+			assert.Len(t, result.Replicated, 3)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), highest.Load())
+}
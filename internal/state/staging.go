@@ -0,0 +1,351 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// defaultDownloadRetries is the number of times a blob download is retried before the entity fails
+	defaultDownloadRetries = 5
+	// stagingRetention is the age after which a staged blob nobody used is deleted
+	stagingRetention = 7 * 24 * time.Hour
+	// Bounds of the exponential backoff between the attempts to download a blob
+	initialDownloadBackoff = time.Second
+	maxDownloadBackoff     = time.Minute
+	partialSuffix          = ".partial"
+)
+
+var errDigestMismatch = errors.New("digest mismatch")
+
+// blobStager downloads the layers to the local disk before they are pushed to the local registry. An interrupted
+// download resumes from the bytes already staged with a range request, in the same run after a backoff or in
+// the next run, instead of starting over.
+type blobStager struct {
+	dir     string
+	retries int
+	// backoff is the delay before the first retry, doubled on each attempt up to maxDownloadBackoff
+	backoff time.Duration
+	// locks is a map of blob digest to the mutex serializing its download
+	locks map[v1.Hash]*sync.Mutex
+	// users is a map of blob digest to the number of entities being replicated with the staged blob, the blob is
+	// deleted once none uses it
+	users map[v1.Hash]int
+	mu    sync.Mutex
+}
+
+// newBlobStager returns a stager keeping the blobs in dir, nil if dir is empty and the blobs are not staged
+func newBlobStager(dir string, retries int) *blobStager {
+	if dir == "" {
+		return nil
+	}
+	if retries <= 0 {
+		retries = defaultDownloadRetries
+	}
+	return &blobStager{
+		dir:     dir,
+		retries: retries,
+		backoff: initialDownloadBackoff,
+		locks:   make(map[v1.Hash]*sync.Mutex),
+		users:   make(map[v1.Hash]int),
+	}
+}
+
+// blobSource is the source repository the blobs of an entity are downloaded from
+type blobSource struct {
+	repo      name.Repository
+	transport http.RoundTripper
+	// staged lists the blobs staged for the entity, deleted once it is replicated
+	staged []v1.Hash
+	mu     sync.Mutex
+}
+
+// newSource returns the source to download the blobs of the repository from, authenticated for pulls
+func (s *blobStager) newSource(ctx context.Context, repo name.Repository, auth authn.Authenticator, base http.RoundTripper) (*blobSource, error) {
+	if s == nil {
+		return nil, nil
+	}
+	t, err := transport.NewWithContext(ctx, repo.Registry, auth, base, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate against %s: %w", repo.String(), err)
+	}
+	return &blobSource{repo: repo, transport: t}, nil
+}
+
+// stagedLayer is a layer read from the staging directory, it is downloaded on the first read
+type stagedLayer struct {
+	v1.Layer
+	ctx    context.Context
+	stager *blobStager
+	source *blobSource
+}
+
+func (l *stagedLayer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	path, err := l.stager.stage(l.ctx, l.source, digest, size)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *blobStager) path(digest v1.Hash) string {
+	return filepath.Join(s.dir, digest.Algorithm, digest.Hex)
+}
+
+func (s *blobStager) lock(digest v1.Hash) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[digest]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[digest] = lock
+	}
+	return lock
+}
+
+// stage downloads the blob to the staging directory unless it is already staged and returns its path.
+// Failed attempts are retried with an exponential backoff, keeping the bytes already downloaded.
+func (s *blobStager) stage(ctx context.Context, source *blobSource, digest v1.Hash, size int64) (string, error) {
+	log := logger.FromContext(ctx)
+	lock := s.lock(digest)
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := s.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		s.use(source, digest)
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create the staging directory: %w", err)
+	}
+	partial := path + partialSuffix
+	for attempt := 0; ; attempt++ {
+		err := s.download(ctx, source, digest, size, partial)
+		if err == nil {
+			err = verifyStagedBlob(partial, digest)
+			if errors.Is(err, errDigestMismatch) {
+				// The staged bytes are unusable, the next attempt starts over
+				_ = os.Remove(partial)
+			}
+		}
+		if err == nil {
+			break
+		}
+		if !isRetryableDownloadError(ctx, err) || attempt >= s.retries {
+			return "", fmt.Errorf("failed to download blob %s: %w", digest.String(), err)
+		}
+		delay := s.backoffDelay(attempt)
+		log.Warn().Err(err).Msgf("Download of blob %s interrupted, resuming in %s", digest.String(), delay.Round(time.Millisecond))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return "", fmt.Errorf("failed to stage blob %s: %w", digest.String(), err)
+	}
+	s.use(source, digest)
+	return path, nil
+}
+
+// download appends the missing bytes of the blob to the partial file. The whole blob is downloaded again
+// if the registry ignores the range request.
+func (s *blobStager) download(ctx context.Context, source *blobSource, digest v1.Hash, size int64, partial string) error {
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size > 0 && offset >= size {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", source.repo.Scheme(), source.repo.RegistryStr(), source.repo.RepositoryStr(), digest.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if size > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, size-1))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+	resp, err := (&http.Client{Transport: source.transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("unexpected content range %q when resuming at %d", resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusOK:
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file already holds the whole blob, the verification tells
+		return nil
+	default:
+		return transport.CheckError(resp, http.StatusOK, http.StatusPartialContent)
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// backoffDelay returns the delay before the retry following the attempt, with a random jitter so that the
+// satellites sharing a link do not retry in lockstep
+func (s *blobStager) backoffDelay(attempt int) time.Duration {
+	delay := min(s.backoff<<attempt, maxDownloadBackoff)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// use records that the entity of the source uses the staged blob, it is called with the lock of the blob held
+func (s *blobStager) use(source *blobSource, digest v1.Hash) {
+	if !source.record(digest) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[digest]++
+}
+
+// release deletes the blobs staged for the entity once it is in the local registry, unless other entities being
+// replicated still use them
+func (s *blobStager) release(source *blobSource) {
+	if s == nil || source == nil {
+		return
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	for _, digest := range source.staged {
+		lock := s.lock(digest)
+		lock.Lock()
+		s.mu.Lock()
+		s.users[digest]--
+		unused := s.users[digest] <= 0
+		if unused {
+			delete(s.users, digest)
+		}
+		s.mu.Unlock()
+		if unused {
+			_ = os.Remove(s.path(digest))
+		}
+		lock.Unlock()
+	}
+	source.staged = nil
+}
+
+// prune deletes the staged and partial blobs untouched for longer than the retention, such as the blobs of
+// entities removed from the state before they could be replicated
+func (s *blobStager) prune(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	log := logger.FromContext(ctx)
+	cutoff := time.Now().Add(-stagingRetention)
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Msgf("Failed to prune the staging directory %s", s.dir)
+	}
+}
+
+// record adds the blob to the ones staged for the entity, it returns false if it was already recorded
+func (b *blobSource) record(digest v1.Hash) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if slices.Contains(b.staged, digest) {
+		return false
+	}
+	b.staged = append(b.staged, digest)
+	return true
+}
+
+// verifyStagedBlob checks the content of the staged blob against its digest, only sha256 digests are verified
+func verifyStagedBlob(path string, digest v1.Hash) error {
+	if digest.Algorithm != "sha256" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	actual, _, err := v1.SHA256(file)
+	if err != nil {
+		return err
+	}
+	if actual != digest {
+		return fmt.Errorf("%w: got %s", errDigestMismatch, actual.String())
+	}
+	return nil
+}
+
+// isRetryableDownloadError returns false for the errors which would not go away by retrying, such as a
+// missing blob or a denied access
+func isRetryableDownloadError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.Temporary() || transportErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyWriter aborts the response once the limit is written, as a link dropping in the middle of a download
+type flakyWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		_, _ = w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestReplicateResumesInterruptedDownloads(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	local := newTestRegistry(t)
+
+	// The first download of every blob is cut after 16KiB, the next attempts must resume with a range request
+	var mu sync.Mutex
+	interrupted := make(map[string]bool)
+	var ranges []string
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/blobs/") {
+			mu.Lock()
+			first := !interrupted[r.URL.Path]
+			interrupted[r.URL.Path] = true
+			if r.Header.Get("Range") != "" {
+				ranges = append(ranges, r.Header.Get("Range"))
+			}
+			mu.Unlock()
+			if first {
+				w = &flakyWriter{ResponseWriter: w, limit: 16 * 1024}
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	source := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(64*1024, 2)
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(img, source+"/team/server:v1"))
+	digest, err := img.Digest()
+	require.NoError(t, err)
+
+	stagingDir := t.TempDir()
+	replicator := NewBasicReplicator("", "", source, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1, StagingDir: stagingDir})
+	replicator.(*BasicReplicator).stager.backoff = time.Millisecond
+	entity := Entity{Name: "server", Repository: "team", Tag: "v1", Digest: digest.String()}
+	result, err := replicator.Replicate(ctx, []Entity{entity})
+	require.NoError(t, err)
+	assert.Len(t, result.Replicated, 1)
+
+	localDigest, err := crane.Digest(local + "/team/server:v1")
+	require.NoError(t, err)
+	assert.Equal(t, digest.String(), localDigest)
+	// One resumed download per layer, starting where the interrupted one stopped
+	require.Len(t, ranges, 2)
+	for _, r := range ranges {
+		assert.True(t, strings.HasPrefix(r, "bytes=16384-"), r)
+	}
+
+	// The staged blobs are deleted once the entity is replicated
+	var staged []string
+	require.NoError(t, filepath.WalkDir(stagingDir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			staged = append(staged, path)
+		}
+		return err
+	}))
+	assert.Empty(t, staged)
+}
+
+func TestReleaseKeepsBlobsUsedByOtherEntities(t *testing.T) {
+	stager := newBlobStager(t.TempDir(), 0)
+	layer, err := random.Layer(1024, types.DockerLayer)
+	require.NoError(t, err)
+	digest, err := layer.Digest()
+	require.NoError(t, err)
+	path := stager.path(digest)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("blob"), 0o644))
+
+	// Two entities being replicated share the staged blob, it is deleted once both are replicated
+	first, second := &blobSource{}, &blobSource{}
+	stager.use(first, digest)
+	stager.use(first, digest)
+	stager.use(second, digest)
+	stager.release(first)
+	assert.FileExists(t, path)
+	stager.release(second)
+	assert.NoFileExists(t, path)
+}
@@ -17,15 +17,23 @@ type StateReader interface {
 	GetArtifactByNameAndTag(name, tag string) ArtifactReader
 	// SetArtifacts sets the artifacts in the state
 	SetArtifacts(artifacts []ArtifactReader)
+	// GetVersion returns the version of the state, which increases each time Ground Control updates the state
+	GetVersion() int64
+	// GetDigest returns the digest of the state artifact the state was fetched from, empty if unknown
+	GetDigest() string
 }
 
 type State struct {
 	Registry  string     `json:"registry"`
 	Artifacts []Artifact `json:"artifacts"`
+	Version   int64      `json:"version,omitempty"`
+	// Digest is the digest of the state artifact, set when the state is fetched from a registry
+	Digest string `json:"-"`
 }
 
 type SatelliteState struct {
-	States []string
+	States  []string `json:"states"`
+	Version int64    `json:"version,omitempty"`
 }
 
 func NewState() StateReader {
@@ -40,6 +48,14 @@ func (a *State) GetRegistryURL() string {
 	return registry
 }
 
+func (a *State) GetVersion() int64 {
+	return a.Version
+}
+
+func (a *State) GetDigest() string {
+	return a.Digest
+}
+
 func (a *State) GetArtifacts() []ArtifactReader {
 	var artifacts_reader []ArtifactReader
 	for i := range a.Artifacts {
@@ -2,15 +2,19 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/container-registry/harbor-satellite/internal/config"
 	"github.com/container-registry/harbor-satellite/internal/logger"
 	"github.com/container-registry/harbor-satellite/internal/notifier"
 	"github.com/container-registry/harbor-satellite/internal/scheduler"
 	"github.com/container-registry/harbor-satellite/internal/utils"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog"
 )
@@ -37,12 +41,45 @@ type FetchAndReplicateStateProcess struct {
 	authConfig     FetchAndReplicateAuthConfig
 	eventBroker    *scheduler.EventBroker
 	Replicator     Replicator
+	replicatorConf ReplicatorConfig
+	// verificationConf holds the signature verification done before the entities are replicated
+	verificationConf VerificationConfig
+	// satelliteStateVersion is the version of the last satellite state fetched
+	satelliteStateVersion int64
+	// store persists the state map so that the reconciliation stays incremental across restarts
+	store StateStore
+	// lastFullResync is the time the states were last reconciled against the contents of the local registry
+	lastFullResync time.Time
+	// quotaConf holds the storage budget enforced before the entities are replicated
+	quotaConf QuotaConfig
+	// execMu is held while the process executes, so that maintenance tasks on the local registry do not run concurrently
+	// and the state map and replicator are only replaced between two runs
+	execMu sync.Mutex
+	// createdAt and lastCompleted are the times the process was created and last went through all the states
+	createdAt     time.Time
+	lastCompleted time.Time
+	// pendingAuth holds the robot secret rotated by ground control, the process keeps using the previous secret
+	// until the registry accepts the new one at the end of the grace period
+	pendingAuth *config.Auth
+	// orphanedEntities are the entities of the group states no longer assigned to the satellite, they are deleted
+	// at the end of the next run unless one of the remaining states references them
+	orphanedEntities []Entity
 }
 
 type StateMap struct {
 	url      string
 	State    StateReader
 	Entities []Entity
+	// FailedEntities are the entities which failed to replicate in the last run and are retried in the next one
+	FailedEntities []Entity
+	// Version is the version of the last state applied, states with an older version are rejected
+	Version int64
+	// Digest is the digest of the last state artifact applied
+	Digest string
+	// EvictedEntities are the entities of the state evicted from the local registry to stay within the storage quota
+	EvictedEntities []Entity
+	// LastSync is the outcome of the last reconciliation of the state, nil until the state is reconciled
+	LastSync *SyncResult
 }
 
 type RegistryConfig struct {
@@ -67,7 +104,7 @@ func NewRegistryConfig(url, username, password string) RegistryConfig {
 	}
 }
 
-func NewFetchAndReplicateStateProcess(cronExpr string, notifier notifier.Notifier, sourceRegistryCredentials RegistryConfig, remoteRegistryCredentials RegistryConfig, useUnsecure bool, state string) *FetchAndReplicateStateProcess {
+func NewFetchAndReplicateStateProcess(cronExpr string, notifier notifier.Notifier, sourceRegistryCredentials RegistryConfig, remoteRegistryCredentials RegistryConfig, useUnsecure bool, state string, replicatorConfig ReplicatorConfig, verificationConfig VerificationConfig, store StateStore, quotaConfig QuotaConfig) *FetchAndReplicateStateProcess {
 	sourceURL := utils.FormatRegistryURL(sourceRegistryCredentials.URL)
 	remoteURL := utils.FormatRegistryURL(remoteRegistryCredentials.URL)
 	return &FetchAndReplicateStateProcess{
@@ -86,11 +123,20 @@ func NewFetchAndReplicateStateProcess(cronExpr string, notifier notifier.Notifie
 			RemoteRegistryUserName: remoteRegistryCredentials.Username,
 			RemoteRegistryPassword: remoteRegistryCredentials.Password,
 		},
-		Replicator: NewBasicReplicator(sourceRegistryCredentials.Username, sourceRegistryCredentials.Password, sourceURL, remoteURL, remoteRegistryCredentials.Username, remoteRegistryCredentials.Password, useUnsecure),
+		Replicator:       NewBasicReplicator(sourceRegistryCredentials.Username, sourceRegistryCredentials.Password, sourceURL, remoteURL, remoteRegistryCredentials.Username, remoteRegistryCredentials.Password, useUnsecure, replicatorConfig),
+		replicatorConf:   replicatorConfig,
+		verificationConf: verificationConfig,
+		store:            store,
+		quotaConf:        quotaConfig,
+		createdAt:        time.Now(),
+		// A secret rotated before a restart is still tried until the registry accepts it
+		pendingAuth: config.GetPendingAuth(),
 	}
 }
 
 func (f *FetchAndReplicateStateProcess) Execute(ctx context.Context) error {
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
 	defer f.stop()
 
 	log := logger.FromContext(ctx)
@@ -114,59 +160,395 @@ func (f *FetchAndReplicateStateProcess) Execute(ctx context.Context) error {
 	}
 
 	// Update stateMap
+	f.mu.Lock()
+	f.satelliteStateVersion = satelliteState.Version
 	f.updateStateMap(satelliteState.States)
+	f.saveState(log)
+	f.mu.Unlock()
+
+	// Periodically compare the states with what the local registry actually holds instead of the last applied state
+	fullResync := f.isFullResyncDue()
+	if fullResync {
+		log.Info().Msg("Performing a full resync against the local registry")
+	}
 
 	// Loop through each state and reconcile the satellite
 	for i := range f.stateMap {
-		log.Info().Msgf("Processing state for %s", f.stateMap[i].url)
-		groupStateFetcher, err := getStateFetcherForInput(f.stateMap[i].url, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, log)
+		f.mu.Lock()
+		url := f.stateMap[i].url
+		f.mu.Unlock()
+		log.Info().Msgf("Processing state for %s", url)
+		groupStateFetcher, err := getStateFetcherForInput(url, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, log)
 		if err != nil {
 			log.Error().Err(err).Msg("Error processing input")
+			f.recordSyncError(i, err)
 			return err
 		}
+		start := time.Now()
 		newStateFetched, err := f.FetchAndProcessState(ctx, groupStateFetcher, log)
+		stateFetchDuration.WithLabelValues(url).Observe(time.Since(start).Seconds())
 		if err != nil {
 			log.Error().Err(err).Msg("Error fetching state")
+			f.recordSyncError(i, err)
 			return err
 		}
-		log.Info().Msgf("State fetched successfully for %s", f.stateMap[i].url)
-		deleteEntity, replicateEntity, newState := f.GetChanges(*newStateFetched, log, f.stateMap[i].Entities)
-		f.LogChanges(deleteEntity, replicateEntity, log)
-		if err := f.notifier.Notify(); err != nil {
+		log.Info().Msgf("State fetched successfully for %s", url)
+		// A state older than the one already applied is a replay, acting on it could delete or pull arbitrary images
+		if err := checkStateVersion(url, (*newStateFetched).GetVersion(), f.stateMap[i].Version); err != nil {
+			log.Error().Err(err).Msg("Rejecting state")
+			f.notifyRejectedState(err, log)
+			f.recordSyncError(i, err)
+			continue
+		}
+		changes, err := f.ComputeChanges(ctx, i, *newStateFetched, fullResync, log)
+		if err != nil {
+			log.Error().Err(err).Msg("Error listing the contents of the local registry")
+			f.recordSyncError(i, err)
+			return err
+		}
+		newState, deleteEntity, replicateEntity := changes.NewState, changes.Delete, changes.Replicate
+		if err := f.notifier.Notify(notifier.Notification{
+			Source:  f.name,
+			Level:   notifier.InfoLevel,
+			Message: fmt.Sprintf("Reconciling %s: %d entities to delete, %d entities to replicate", url, len(deleteEntity), len(replicateEntity)),
+		}); err != nil {
 			log.Error().Err(err).Msg("Error sending notification")
 		}
 		// Delete the entities from the remote registry
 		if err := f.Replicator.DeleteReplicationEntity(ctx, deleteEntity); err != nil {
 			log.Error().Err(err).Msg("Error deleting entities")
+			f.recordSyncError(i, err)
 			return err
 		}
-		// Replicate the entities to the remote registry
-		if err := f.Replicator.Replicate(ctx, replicateEntity); err != nil {
-			log.Error().Err(err).Msg("Error replicating state")
-			return err
+		f.forgetAccess(deleteEntity, log)
+		entitiesDeleted.WithLabelValues(url).Add(float64(len(deleteEntity)))
+		// Replicate the entities to the remote registry, the entities which fail are retried in the next run
+		result, err := f.Replicator.Replicate(ctx, replicateEntity)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to replicate %d entities for %s, they would be retried in the next run", len(result.Failed), url)
 		}
+		f.recordAccess(result.Replicated, log)
 		// Update the state directly in the slice
+		f.mu.Lock()
+		previousFailed := f.stateMap[i].FailedEntities
 		f.stateMap[i].State = newState
-		f.stateMap[i].Entities = FetchEntitiesFromState(newState)
+		f.stateMap[i].Entities = replaceEntities(localDigests(FetchEntitiesFromState(newState), result.Replicated, f.stateMap[i].Entities), changes.Kept)
+		f.stateMap[i].FailedEntities = append(append(result.FailedEntities(), changes.Rejected...), changes.Deferred...)
+		f.stateMap[i].Version = newState.GetVersion()
+		f.stateMap[i].Digest = (*newStateFetched).GetDigest()
+		f.stateMap[i].EvictedEntities = keepEntities(f.stateMap[i].EvictedEntities, f.stateMap[i].Entities)
+		f.stateMap[i].LastSync = &SyncResult{
+			Time:       time.Now(),
+			Success:    len(f.stateMap[i].FailedEntities) == 0,
+			Replicated: len(result.Replicated),
+			Deleted:    len(deleteEntity),
+			Failed:     len(f.stateMap[i].FailedEntities),
+		}
+		if err != nil {
+			f.stateMap[i].LastSync.Error = err.Error()
+		}
+		synced := f.stateMap[i].LastSync.Success
+		f.saveState(log)
+		f.mu.Unlock()
+		entitiesReplicated.WithLabelValues(url).Add(float64(len(result.Replicated)))
+		// The entities still failing since the previous run were already counted
+		entitiesFailed.WithLabelValues(url, failureReplication).Add(float64(countNewEntities(result.FailedEntities(), previousFailed)))
+		entitiesFailed.WithLabelValues(url, failureVerification).Add(float64(countNewEntities(changes.Rejected, previousFailed)))
+		entitiesFailed.WithLabelValues(url, failureDeferral).Add(float64(countNewEntities(changes.Deferred, previousFailed)))
+		if synced {
+			lastSuccessfulSync.WithLabelValues(url).SetToCurrentTime()
+		}
 	}
+	// All the states are reconciled, the entities they reference are known even for the newly assigned states
+	f.DeleteOrphanedEntities(ctx, log)
+	f.mu.Lock()
+	if fullResync {
+		f.lastFullResync = time.Now()
+	}
+	f.lastCompleted = time.Now()
+	f.mu.Unlock()
 	return nil
 }
 
+// StateChanges are the changes to apply to the local registry for a state
+type StateChanges struct {
+	NewState StateReader
+	// Delete are the entities to delete from the local registry
+	Delete []Entity
+	// Replicate are the entities to replicate to the local registry
+	Replicate []Entity
+	// Rejected are the entities whose signature could not be verified
+	Rejected []Entity
+	// Kept are the previous versions of the rejected entities, kept in the local registry
+	Kept []Entity
+	// Deferred are the entities deferred to the next transfer window or to a run where they fit within the storage quota
+	Deferred []Entity
+}
+
+// ComputeChanges runs the state at index i through the reconciliation pipeline up to the replication: the changes
+// are computed against the applied state, or the contents of the local registry for a full resync, the failed
+// entities are retried, the signatures are verified, the large transfers are deferred outside of the transfer
+// windows and the storage quota is enforced. The local registry is only modified by the evictions of the quota.
+func (f *FetchAndReplicateStateProcess) ComputeChanges(ctx context.Context, i int, newStateFetched StateReader, fullResync bool, log *zerolog.Logger) (StateChanges, error) {
+	var changes StateChanges
+	if fullResync {
+		var err error
+		changes.Delete, changes.Replicate, changes.NewState, err = f.GetChangesFromRegistry(ctx, newStateFetched, f.stateMap[i].Entities, f.otherEntities(i), log)
+		if err != nil {
+			return changes, err
+		}
+	} else {
+		changes.Delete, changes.Replicate, changes.NewState = f.GetChanges(newStateFetched, log, f.stateMap[i].Entities)
+	}
+	newEntities := FetchEntitiesFromState(changes.NewState)
+	changes.Replicate = f.AddFailedEntitiesForRetry(changes.Replicate, f.stateMap[i].FailedEntities, newEntities, log)
+	f.LogChanges(changes.Delete, changes.Replicate, log)
+	// Verify the signatures before anything lands in the local registry, the rejected entities are retried in the next run
+	changes.Replicate, changes.Rejected = f.VerifyEntities(ctx, changes.Replicate, log)
+	// The evicted entities are already gone and the verified image stays in place until its update has a trusted signature
+	changes.Delete = f.RemoveSharedDigests(changes.Delete, append(newEntities, f.otherEntities(i)...), log)
+	changes.Delete = f.RemoveEvictedEntities(changes.Delete)
+	changes.Delete, changes.Kept = f.KeepRejectedEntities(changes.Delete, changes.Rejected, log)
+	// Outside of the transfer windows only the small transfers are done, the others are retried in the next run
+	replicateEntity, outOfWindowEntity := f.DeferLargeTransfers(ctx, i, newEntities, changes.Replicate, log)
+	// Make room for the entities within the storage quota, the entities which do not fit are retried in the next run
+	changes.Replicate, changes.Deferred = f.EnforceQuota(ctx, i, newEntities, replicateEntity, log)
+	changes.Deferred = append(changes.Deferred, outOfWindowEntity...)
+	return changes, nil
+}
+
+// RunExclusive runs fn while no reconciliation is in progress, a reconciliation due in the meantime waits for fn to return
+func (f *FetchAndReplicateStateProcess) RunExclusive(fn func() error) error {
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
+	return fn()
+}
+
+// RemoveSharedDigests removes the untagged entities from the deletion list when their digest is still used
+// by an entity kept in the same repository, as deleting a manifest by digest also removes the tags pointing to it
+func (f *FetchAndReplicateStateProcess) RemoveSharedDigests(deleteEntity, keptEntities []Entity, log *zerolog.Logger) []Entity {
+	inUse := make(map[string]bool)
+	for _, entity := range keptEntities {
+		if entity.Digest != "" {
+			inUse[entityRepository(entity)+"@"+entity.Digest] = true
+		}
+	}
+	var entities []Entity
+	for _, entity := range deleteEntity {
+		if entity.IsUntagged() && inUse[entityRepository(entity)+"@"+entity.Digest] {
+			log.Info().Msgf("Keeping %s as its digest is still referenced", entity.String())
+			continue
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// otherEntities returns the entities of all the states except the one at index i
+func (f *FetchAndReplicateStateProcess) otherEntities(i int) []Entity {
+	var entities []Entity
+	for j := range f.stateMap {
+		if j != i {
+			entities = append(entities, f.stateMap[j].Entities...)
+		}
+	}
+	return entities
+}
+
+// DeleteOrphanedEntities deletes the entities of the removed group states from the local registry, the entities
+// still referenced by the remaining states are kept. The entities which could not be deleted are retried in the next run.
+func (f *FetchAndReplicateStateProcess) DeleteOrphanedEntities(ctx context.Context, log *zerolog.Logger) {
+	f.mu.Lock()
+	orphaned := len(f.orphanedEntities)
+	deleteEntity := f.orphansToDelete(log)
+	f.mu.Unlock()
+	if orphaned == 0 {
+		return
+	}
+
+	log.Info().Msgf("Deleting %d entities of removed group states", len(deleteEntity))
+	var deleted, remaining []Entity
+	for _, entity := range deleteEntity {
+		// An entity which is already gone, e.g. because it never replicated, needs no retry
+		if err := f.Replicator.DeleteReplicationEntity(ctx, []Entity{entity}); err != nil && !isNotFound(err) {
+			log.Error().Err(err).Msgf("Error deleting %s, it would be retried in the next run", entity.String())
+			remaining = append(remaining, entity)
+			continue
+		}
+		deleted = append(deleted, entity)
+	}
+	f.forgetAccess(deleted, log)
+
+	f.mu.Lock()
+	f.orphanedEntities = remaining
+	f.saveState(log)
+	f.mu.Unlock()
+}
+
+// orphansToDelete returns the entities of the removed group states which none of the remaining states references
+func (f *FetchAndReplicateStateProcess) orphansToDelete(log *zerolog.Logger) []Entity {
+	if len(f.orphanedEntities) == 0 {
+		return nil
+	}
+	var referenced []Entity
+	for _, stateMap := range f.stateMap {
+		referenced = append(referenced, stateMap.Entities...)
+	}
+	var deleteEntity []Entity
+	for _, entity := range f.orphanedEntities {
+		if !containsEntity(referenced, entity) {
+			deleteEntity = append(deleteEntity, entity)
+		}
+	}
+	return f.RemoveSharedDigests(deleteEntity, referenced, log)
+}
+
+// RestoreState loads the state map saved by a previous run, so that the first reconciliation after a
+// restart only applies the changes made in the meantime instead of replicating the complete state
+func (f *FetchAndReplicateStateProcess) RestoreState(ctx context.Context) error {
+	log := logger.FromContext(ctx)
+	saved, err := f.store.Load()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.satelliteStateVersion = saved.SatelliteStateVersion
+	f.orphanedEntities = saved.OrphanedEntities
+	f.stateMap = nil
+	for _, applied := range saved.States {
+		f.stateMap = append(f.stateMap, StateMap{
+			url:             applied.URL,
+			Entities:        applied.Entities,
+			FailedEntities:  applied.FailedEntities,
+			Version:         applied.Version,
+			Digest:          applied.Digest,
+			EvictedEntities: applied.EvictedEntities,
+			LastSync:        applied.LastSync,
+		})
+	}
+	log.Info().Msgf("Restored the reconciliation state of %d groups", len(f.stateMap))
+	return nil
+}
+
+// saveState persists the state map, a failure is only logged as the satellite keeps working from memory
+func (f *FetchAndReplicateStateProcess) saveState(log *zerolog.Logger) {
+	saved := &ReconciliationState{SatelliteStateVersion: f.satelliteStateVersion, OrphanedEntities: f.orphanedEntities}
+	for _, stateMap := range f.stateMap {
+		saved.States = append(saved.States, AppliedState{
+			URL:             stateMap.url,
+			Version:         stateMap.Version,
+			Digest:          stateMap.Digest,
+			Entities:        stateMap.Entities,
+			FailedEntities:  stateMap.FailedEntities,
+			EvictedEntities: stateMap.EvictedEntities,
+			LastSync:        stateMap.LastSync,
+		})
+	}
+	if err := f.store.Save(saved); err != nil {
+		log.Error().Err(err).Msg("Error saving the reconciliation state")
+	}
+}
+
 func (f *FetchAndReplicateStateProcess) fetchSatelliteState(ctx context.Context, log *zerolog.Logger) (*SatelliteState, error) {
-	satelliteStateFetcher, err := getStateFetcherForInput(f.satelliteState, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, log)
+	satelliteState, err := f.fetchSatelliteStateWithRotatedSecret(ctx, log)
+	if satelliteState == nil && err == nil {
+		satelliteState, err = f.pullSatelliteState(ctx, f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, log)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error fetching state artifact from url: %s", f.satelliteState)
+		return nil, err
+	}
+	if err := checkStateVersion(f.satelliteState, satelliteState.Version, f.satelliteStateVersion); err != nil {
+		log.Error().Err(err).Msg("Rejecting satellite state")
+		f.notifyRejectedState(err, log)
+		return nil, err
+	}
+	return satelliteState, nil
+}
+
+// fetchSatelliteStateWithRotatedSecret tries the robot secret rotated by ground control and switches the process
+// to it once the registry accepts it. It returns a nil state without error if there is no rotated secret or the
+// registry still only accepts the previous one.
+func (f *FetchAndReplicateStateProcess) fetchSatelliteStateWithRotatedSecret(ctx context.Context, log *zerolog.Logger) (*SatelliteState, error) {
+	f.mu.Lock()
+	pending := f.pendingAuth
+	f.mu.Unlock()
+	if pending == nil {
+		return nil, nil
+	}
+
+	satelliteState, err := f.pullSatelliteState(ctx, pending.SourceUsername, pending.SourcePassword, log)
+	if isUnauthorized(err) {
+		log.Info().Msg("Rotated robot secret not accepted by the registry yet, using the previous secret")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	// The payloads from ground control may have replaced the rotated secret in the meantime
+	if f.pendingAuth == pending {
+		f.UpdateFetchProcessConfigFromZtr(pending.SourceUsername, pending.SourcePassword, pending.Registry)
+		f.pendingAuth = nil
+		log.Info().Msgf("Process %s switched to the rotated robot secret", f.name)
+		if err := config.UpdateStateAuthConfig(pending.SourceUsername, pending.Registry, pending.SourcePassword, config.GetState()); err != nil {
+			log.Error().Err(err).Msg("Failed to persist the rotated robot secret")
+		}
+	}
+	f.mu.Unlock()
+	return satelliteState, nil
+}
+
+func (f *FetchAndReplicateStateProcess) pullSatelliteState(ctx context.Context, username, password string, log *zerolog.Logger) (*SatelliteState, error) {
+	satelliteStateFetcher, err := getStateFetcherForInput(f.satelliteState, username, password, log)
 	if err != nil {
 		log.Error().Err(err).Msg("Error processing satellite state")
 		return nil, err
 	}
 
 	satelliteState := &SatelliteState{}
-	if err := satelliteStateFetcher.FetchStateArtifact(ctx, satelliteState, log); err != nil {
-		log.Error().Err(err).Msgf("Error fetching state artifact from url: %s", f.satelliteState)
+	start := time.Now()
+	err = satelliteStateFetcher.FetchStateArtifact(ctx, satelliteState, log)
+	stateFetchDuration.WithLabelValues(f.satelliteState).Observe(time.Since(start).Seconds())
+	if err != nil {
 		return nil, err
 	}
 	return satelliteState, nil
 }
 
+// isUnauthorized returns true if the registry rejected the credentials
+func isUnauthorized(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusUnauthorized
+}
+
+// isNotFound returns true if the registry does not know the artifact
+func isNotFound(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound
+}
+
+// checkStateVersion returns an error if the version of the fetched state is older than the version last applied
+func checkStateVersion(url string, version, lastVersion int64) error {
+	if version < lastVersion {
+		return fmt.Errorf("state %s has version %d older than the applied version %d", url, version, lastVersion)
+	}
+	return nil
+}
+
+func (f *FetchAndReplicateStateProcess) notifyRejectedState(err error, log *zerolog.Logger) {
+	if err := f.notifier.Notify(notifier.Notification{
+		Source:  f.name,
+		Level:   notifier.ErrorLevel,
+		Message: "State rejected",
+		Details: []string{err.Error()},
+	}); err != nil {
+		log.Error().Err(err).Msg("Error sending notification")
+	}
+}
+
 func (f *FetchAndReplicateStateProcess) updateStateMap(states []string) {
 	var newStates []string
 	for _, state := range states {
@@ -182,11 +564,18 @@ func (f *FetchAndReplicateStateProcess) updateStateMap(states []string) {
 		}
 	}
 
-	// Remove states that are no longer needed
+	// Remove states that are no longer needed, their entities are deleted once the remaining states are reconciled
 	var updatedStateMap []StateMap
 	for _, stateMap := range f.stateMap {
 		if contains(states, stateMap.url) {
 			updatedStateMap = append(updatedStateMap, stateMap)
+			continue
+		}
+		for _, entity := range stateMap.Entities {
+			// The evicted entities are already gone from the local registry
+			if !containsEntity(stateMap.EvictedEntities, entity) && !containsEntity(f.orphanedEntities, entity) {
+				f.orphanedEntities = append(f.orphanedEntities, entity)
+			}
 		}
 	}
 
@@ -211,13 +600,13 @@ func (f *FetchAndReplicateStateProcess) GetChanges(newState StateReader, log *ze
 	// Create maps for quick lookups
 	oldEntityMap := make(map[string]Entity)
 	for _, oldEntity := range oldEntites {
-		oldEntityMap[oldEntity.Name+"|"+oldEntity.Tag] = oldEntity
+		oldEntityMap[entityKey(oldEntity)] = oldEntity
 	}
 
 	// Check new artifacts and update lists
 	for _, newEntity := range newEntites {
-		nameTagKey := newEntity.Name + "|" + newEntity.Tag
-		oldEntity, exists := oldEntityMap[nameTagKey]
+		key := entityKey(newEntity)
+		oldEntity, exists := oldEntityMap[key]
 
 		if !exists {
 			// New artifact doesn't exist in old state, add to replication list
@@ -229,7 +618,7 @@ func (f *FetchAndReplicateStateProcess) GetChanges(newState StateReader, log *ze
 		}
 
 		// Remove processed old artifact from map
-		delete(oldEntityMap, nameTagKey)
+		delete(oldEntityMap, key)
 	}
 
 	// Remaining artifacts in oldArtifactsMap should be deleted
@@ -282,6 +671,9 @@ func (f *FetchAndReplicateStateProcess) CanExecute(ctx context.Context) (bool, s
 		return false, fmt.Sprintf("missing %s", strings.Join(missingFields, ", "))
 	}
 
+	if !f.replicatorConf.Bandwidth.InTransferWindow(time.Now()) {
+		return true, fmt.Sprintf("Process %s can execute: outside of the transfer windows, only deletions and small updates are applied", f.name)
+	}
 	return true, fmt.Sprintf("Process %s can execute: all conditions fulfilled", f.name)
 }
 
@@ -302,10 +694,12 @@ func (f *FetchAndReplicateStateProcess) stop() {
 	f.isRunning = false
 }
 
+// RemoveNullTagArtifacts removes the artifacts which can not be referenced, i.e. the ones without tags and
+// without digest. Untagged artifacts with a digest are kept and replicated by digest.
 func (f *FetchAndReplicateStateProcess) RemoveNullTagArtifacts(state StateReader) StateReader {
 	var artifactsWithoutNullTags []ArtifactReader
 	for _, artifact := range state.GetArtifacts() {
-		if artifact.GetTags() != nil && len(artifact.GetTags()) != 0 {
+		if len(artifact.GetTags()) != 0 || artifact.GetDigest() != "" {
 			artifactsWithoutNullTags = append(artifactsWithoutNullTags, artifact)
 		}
 	}
@@ -337,6 +731,35 @@ func (f *FetchAndReplicateStateProcess) FetchAndProcessState(ctx context.Context
 	return ProcessState(&state)
 }
 
+// AddFailedEntitiesForRetry appends the entities which failed in the previous run to the replication list,
+// as long as they are still part of the new state and are not already scheduled for replication
+func (f *FetchAndReplicateStateProcess) AddFailedEntitiesForRetry(replicateEntity, failedEntities, newEntities []Entity, log *zerolog.Logger) []Entity {
+	if len(failedEntities) == 0 {
+		return replicateEntity
+	}
+	newEntityMap := make(map[string]Entity)
+	for _, entity := range newEntities {
+		newEntityMap[entityKey(entity)] = entity
+	}
+	scheduled := make(map[string]bool)
+	for _, entity := range replicateEntity {
+		scheduled[entityKey(entity)] = true
+	}
+	retried := 0
+	for _, failed := range failedEntities {
+		key := entityKey(failed)
+		entity, exists := newEntityMap[key]
+		if !exists || scheduled[key] {
+			continue
+		}
+		replicateEntity = append(replicateEntity, entity)
+		scheduled[key] = true
+		retried++
+	}
+	log.Info().Msgf("Retrying %d entities which failed in the previous run", retried)
+	return replicateEntity
+}
+
 func (f *FetchAndReplicateStateProcess) LogChanges(deleteEntity, replicateEntity []Entity, log *zerolog.Logger) {
 	log.Warn().Msgf("Total artifacts to delete: %d", len(deleteEntity))
 	log.Warn().Msgf("Total artifacts to replicate: %d", len(replicateEntity))
@@ -345,6 +768,14 @@ func (f *FetchAndReplicateStateProcess) LogChanges(deleteEntity, replicateEntity
 func FetchEntitiesFromState(state StateReader) []Entity {
 	var entities []Entity
 	for _, artifact := range state.GetArtifacts() {
+		if len(artifact.GetTags()) == 0 && artifact.GetDigest() != "" {
+			entities = append(entities, Entity{
+				Name:       artifact.GetName(),
+				Repository: artifact.GetRepository(),
+				Digest:     artifact.GetDigest(),
+			})
+			continue
+		}
 		for _, tag := range artifact.GetTags() {
 			entities = append(entities, Entity{
 				Name:       artifact.GetName(),
@@ -379,7 +810,7 @@ func (f *FetchAndReplicateStateProcess) ListenForUpdatedConfig(ctx context.Conte
 		case <-ctx.Done():
 			return
 		case event := <-fetchConfigCh:
-			log.Info().Msgf("Received updated config from ground control from source %s", event.Source)
+			f.HandlePayloadFromGroundControl(event, log)
 		case event := <-zeroTouchRegistrationCh:
 			f.HandelPayloadFromZTR(event, log)
 		}
@@ -393,14 +824,54 @@ func (f *FetchAndReplicateStateProcess) HandelPayloadFromZTR(event scheduler.Eve
 		log.Error().Msgf("Received invalid payload from %s, for process %s", event.Source, ZeroTouchRegistrationEventName)
 		return
 	}
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.UpdateFetchProcessConfigFromZtr(payload.StateConfig.Auth.SourceUsername, payload.StateConfig.Auth.SourcePassword, payload.StateConfig.Auth.Registry)
 }
 
+// HandlePayloadFromGroundControl applies the group states and credentials fetched from ground control
+func (f *FetchAndReplicateStateProcess) HandlePayloadFromGroundControl(event scheduler.Event, log *zerolog.Logger) {
+	log.Info().Msgf("Received %s event with source %s", event.Name, event.Source)
+	payload, ok := event.Payload.(GroundControlPayload)
+	if !ok {
+		log.Error().Msgf("Received invalid payload from %s, for process %s", event.Source, FetchConfigFromGroundControlEventName)
+		return
+	}
+	// A run in progress indexes the state map and uses the replicator, the payload is applied once it is over
+	f.execMu.Lock()
+	defer f.execMu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	auth := payload.Auth
+	if auth.SourceUsername != "" && auth.SourcePassword != "" && auth.Registry != "" {
+		sameAccount := auth.SourceUsername == f.authConfig.SourceRegistryUserName &&
+			utils.FormatRegistryURL(auth.Registry) == f.authConfig.SourceRegistry
+		switch {
+		case !sameAccount:
+			log.Info().Msgf("Updating source registry credentials for process %s", f.name)
+			f.UpdateFetchProcessConfigFromZtr(auth.SourceUsername, auth.SourcePassword, auth.Registry)
+			f.pendingAuth = nil
+		case auth.SourcePassword != f.authConfig.SourceRegistryPassword:
+			// The secret was rotated, the registry may still only accept the previous one during the grace period
+			if f.pendingAuth == nil || f.pendingAuth.SourcePassword != auth.SourcePassword {
+				log.Info().Msgf("Received a rotated robot secret, process %s switches to it once the registry accepts it", f.name)
+			}
+			f.pendingAuth = &auth
+		default:
+			f.pendingAuth = nil
+		}
+	}
+	f.updateStateMap(payload.States)
+	log.Info().Msgf("Process %s is now tracking %d group states", f.name, len(f.stateMap))
+}
+
 func (f *FetchAndReplicateStateProcess) UpdateFetchProcessConfigFromZtr(username, password, sourceRegistryURL string) {
 	f.authConfig.SourceRegistryUserName = username
 	f.authConfig.SourceRegistryPassword = password
 	f.authConfig.SourceRegistry = utils.FormatRegistryURL(sourceRegistryURL)
-	f.Replicator = NewBasicReplicator(f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, f.authConfig.SourceRegistry, f.authConfig.RemoteRegistryURL, f.authConfig.RemoteRegistryUserName, f.authConfig.RemoteRegistryPassword, f.authConfig.UseUnsecure)
+	f.Replicator = NewBasicReplicator(f.authConfig.SourceRegistryUserName, f.authConfig.SourceRegistryPassword, f.authConfig.SourceRegistry, f.authConfig.RemoteRegistryURL, f.authConfig.RemoteRegistryUserName, f.authConfig.RemoteRegistryPassword, f.authConfig.UseUnsecure, f.replicatorConf)
 }
 
 // contains takes in a slice and checks if the item is in the slice if preset it returns true else false
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/container-registry/harbor-satellite/internal/config"
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/scheduler"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatedRobotSecret(t *testing.T) {
+	// The accepted secret is written to the default config path
+	t.Chdir(t.TempDir())
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{"environment_variables": {}}`), 0600))
+	errs, _ := config.InitConfig(configPath)
+	require.Empty(t, errs)
+
+	// The registry accepts a single secret for the robot account, as Harbor does
+	var accepted atomic.Value
+	accepted.Store("old")
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "robot" || password != accepted.Load().(string) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := crane.Image(map[string][]byte{stateArtifactFile: []byte(`{"states":["group-state"],"version":1}`)})
+	require.NoError(t, err)
+	auth := crane.WithAuth(authn.FromConfig(authn.AuthConfig{Username: "robot", Password: "old"}))
+	require.NoError(t, crane.Push(img, host+"/satellite/state:latest", auth))
+
+	log := zerolog.Nop()
+	process := &FetchAndReplicateStateProcess{
+		name:           "test",
+		mu:             &sync.Mutex{},
+		satelliteState: server.URL + "/satellite/state:latest",
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry:         host,
+			SourceRegistryUserName: "robot",
+			SourceRegistryPassword: "old",
+		},
+	}
+	require.NoError(t, config.UpdateStateAuthConfig("robot", server.URL, "old", ""))
+	rotate := func(secret string) {
+		// As the config process does for a rotated secret
+		require.NoError(t, config.UpdatePendingAuthConfig(&config.Auth{SourceUsername: "robot", SourcePassword: secret, Registry: server.URL}))
+		process.HandlePayloadFromGroundControl(scheduler.Event{
+			Name: FetchConfigFromGroundControlEventName,
+			Payload: GroundControlPayload{
+				Auth: config.Auth{SourceUsername: "robot", SourcePassword: secret, Registry: server.URL},
+			},
+		}, &log)
+	}
+
+	rotate("new")
+	assert.Equal(t, "old", process.authConfig.SourceRegistryPassword, "the previous secret is kept until the registry accepts the new one")
+	require.NotNil(t, process.pendingAuth)
+
+	state, err := process.fetchSatelliteState(context.Background(), &log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-state"}, state.States)
+	assert.Equal(t, "old", process.authConfig.SourceRegistryPassword)
+
+	assert.Equal(t, "old", config.GetSourceRegistryPassword())
+	require.NotNil(t, config.GetPendingAuth())
+
+	// Ground Control applied the new secret to the registry once the grace period is over
+	accepted.Store("new")
+
+	// Planning uses the new secret without switching the process to it
+	planner, _ := process.planner()
+	state, err = planner.fetchPlannedSatelliteState(context.Background(), &log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-state"}, state.States)
+	assert.Equal(t, "new", planner.authConfig.SourceRegistryPassword)
+	assert.Equal(t, "old", process.authConfig.SourceRegistryPassword)
+	assert.NotNil(t, process.pendingAuth)
+	assert.Equal(t, "old", config.GetSourceRegistryPassword())
+
+	state, err = process.fetchSatelliteState(context.Background(), &log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-state"}, state.States)
+	assert.Equal(t, "new", process.authConfig.SourceRegistryPassword)
+	assert.Nil(t, process.pendingAuth)
+	assert.Equal(t, "new", config.GetSourceRegistryPassword(), "the new secret is persisted once the registry accepts it")
+	assert.Nil(t, config.GetPendingAuth())
+
+	rotate("new")
+	assert.Nil(t, process.pendingAuth, "the secret in use is not pending")
+}
+
+func TestDeleteEntitiesOfRemovedGroups(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	local := newTestRegistry(t)
+
+	entities := map[string]Entity{}
+	for _, imageName := range []string{"shared", "removed"} {
+		img, err := random.Image(256, 1)
+		require.NoError(t, err)
+		require.NoError(t, crane.Push(img, local+"/team/"+imageName+":v1"))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		entities[imageName] = Entity{Repository: "team", Name: imageName, Tag: "v1", Digest: digest.String()}
+	}
+	// Never replicated, deleting it fails with not found
+	missing := Entity{Repository: "team", Name: "missing", Tag: "v1"}
+
+	store := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	process := &FetchAndReplicateStateProcess{
+		mu:         &sync.Mutex{},
+		store:      store,
+		Replicator: NewBasicReplicator("", "", local, local, "", "", true, ReplicatorConfig{MaxConcurrency: 1}),
+		stateMap: []StateMap{
+			{url: "group-a", Entities: []Entity{entities["shared"]}},
+			{url: "group-b", Entities: []Entity{entities["shared"], entities["removed"], missing}},
+		},
+	}
+	process.updateStateMap([]string{"group-a"})
+	require.Len(t, process.stateMap, 1)
+	process.saveState(&nop)
+	saved, err := store.Load()
+	require.NoError(t, err)
+	assert.Len(t, saved.OrphanedEntities, 3, "the entities to delete survive a restart")
+
+	process.DeleteOrphanedEntities(ctx, &nop)
+	_, err = crane.Head(local + "/team/removed:v1")
+	assert.Error(t, err)
+	_, err = crane.Head(local + "/team/shared:v1")
+	assert.NoError(t, err, "the entities referenced by the remaining groups are kept")
+	assert.Empty(t, process.orphanedEntities)
+}
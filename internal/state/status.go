@@ -0,0 +1,105 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// SyncResult is the outcome of the last reconciliation of the state of a group
+type SyncResult struct {
+	Time       time.Time `json:"time"`
+	Success    bool      `json:"success"`
+	Replicated int       `json:"replicated"`
+	Deleted    int       `json:"deleted"`
+	// Failed is the number of entities which failed to replicate, were rejected or deferred to the next run
+	Failed int    `json:"failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GroupStatus is the reconciliation status of the state of a group
+type GroupStatus struct {
+	URL             string      `json:"url"`
+	Version         int64       `json:"version"`
+	Digest          string      `json:"digest,omitempty"`
+	Entities        []Entity    `json:"entities"`
+	FailedEntities  []Entity    `json:"failed_entities,omitempty"`
+	EvictedEntities []Entity    `json:"evicted_entities,omitempty"`
+	LastSync        *SyncResult `json:"last_sync,omitempty"`
+}
+
+// StateStatus is the reconciliation status of the satellite state and of the states of its groups
+type StateStatus struct {
+	SatelliteState        string        `json:"satellite_state"`
+	SatelliteStateVersion int64         `json:"satellite_state_version"`
+	Groups                []GroupStatus `json:"groups"`
+}
+
+// Status returns the states currently applied and the outcome of their last reconciliation
+func (f *FetchAndReplicateStateProcess) Status() StateStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := StateStatus{
+		SatelliteState:        f.satelliteState,
+		SatelliteStateVersion: f.satelliteStateVersion,
+		Groups:                make([]GroupStatus, 0, len(f.stateMap)),
+	}
+	for _, stateMap := range f.stateMap {
+		group := GroupStatus{
+			URL:             stateMap.url,
+			Version:         stateMap.Version,
+			Digest:          stateMap.Digest,
+			Entities:        append([]Entity{}, stateMap.Entities...),
+			FailedEntities:  append([]Entity(nil), stateMap.FailedEntities...),
+			EvictedEntities: append([]Entity(nil), stateMap.EvictedEntities...),
+		}
+		if stateMap.LastSync != nil {
+			lastSync := *stateMap.LastSync
+			group.LastSync = &lastSync
+		}
+		status.Groups = append(status.Groups, group)
+	}
+	return status
+}
+
+// recordSyncError records the error which stopped the reconciliation of the state at index i
+func (f *FetchAndReplicateStateProcess) recordSyncError(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stateMap[i].LastSync = &SyncResult{Time: time.Now(), Error: err.Error()}
+}
+
+// FreshnessCheck returns an error if the process did not go through all the states within maxMissed of its
+// intervals, since it was created or since the last time it did
+func (f *FetchAndReplicateStateProcess) FreshnessCheck(ctx context.Context, maxMissed int) error {
+	interval, err := cronInterval(f.cronExpr)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	completed := f.lastCompleted
+	last := completed
+	if last.IsZero() {
+		last = f.createdAt
+	}
+	f.mu.Unlock()
+	if since := time.Since(last); since > time.Duration(maxMissed)*interval {
+		if completed.IsZero() {
+			return fmt.Errorf("states not replicated since the satellite started %s ago", since.Round(time.Second))
+		}
+		return fmt.Errorf("states last replicated %s ago, more than %d intervals of %s", since.Round(time.Second), maxMissed, interval)
+	}
+	return nil
+}
+
+// cronInterval returns the time between two runs of the schedule
+func cronInterval(expr string) (time.Duration, error) {
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule %s: %w", expr, err)
+	}
+	next := schedule.Next(time.Now())
+	return schedule.Next(next).Sub(next), nil
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StateStore persists the reconciliation state of the satellite so that it survives restarts
+type StateStore interface {
+	// Load returns the last saved reconciliation state, or an empty one if nothing was saved yet
+	Load() (*ReconciliationState, error)
+	// Save replaces the saved reconciliation state
+	Save(state *ReconciliationState) error
+}
+
+// ReconciliationState is the state of the satellite as last applied to the local registry
+type ReconciliationState struct {
+	// SatelliteStateVersion is the version of the last satellite state fetched
+	SatelliteStateVersion int64 `json:"satellite_state_version"`
+	// States is the list of group states applied
+	States []AppliedState `json:"states"`
+	// OrphanedEntities are the entities of the removed group states not deleted from the local registry yet
+	OrphanedEntities []Entity `json:"orphaned_entities,omitempty"`
+}
+
+// AppliedState is a group state as last applied to the local registry
+type AppliedState struct {
+	URL            string   `json:"url"`
+	Version        int64    `json:"version"`
+	Digest         string   `json:"digest,omitempty"`
+	Entities       []Entity `json:"entities"`
+	FailedEntities []Entity `json:"failed_entities,omitempty"`
+	// EvictedEntities are the entities of the state evicted from the local registry to stay within the storage quota
+	EvictedEntities []Entity `json:"evicted_entities,omitempty"`
+	// LastSync is the outcome of the last reconciliation of the state
+	LastSync *SyncResult `json:"last_sync,omitempty"`
+}
+
+// FileStateStore stores the reconciliation state in a JSON file
+type FileStateStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+func (s *FileStateStore) Load() (*ReconciliationState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ReconciliationState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state store %s: %w", s.path, err)
+	}
+	state := &ReconciliationState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state store %s: %w", s.path, err)
+	}
+	return state, nil
+}
+
+// Save writes the state to a temporary file which is then renamed, so that a crash while saving
+// never leaves a truncated state behind
+func (s *FileStateStore) Save(state *ReconciliationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory and renames it to path
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,146 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/container-registry/harbor-satellite/internal/verifier"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+)
+
+// VerificationConfig holds the settings of the signature verification done before replicating the entities
+type VerificationConfig struct {
+	// Verifier checks the signatures of the entities, verification is disabled if nil
+	Verifier verifier.Verifier
+	// Enforce prevents the entities without a trusted signature from being replicated, they are only reported otherwise
+	Enforce bool
+}
+
+func NewVerificationConfig(signatureVerifier verifier.Verifier, enforce bool) VerificationConfig {
+	return VerificationConfig{
+		Verifier: signatureVerifier,
+		Enforce:  enforce,
+	}
+}
+
+// VerifyEntities checks the signatures of the entities at the source registry before they are replicated.
+// It returns the entities allowed to be replicated and the ones rejected. When the verification is not
+// enforced the rejected entities are reported but still allowed.
+func (f *FetchAndReplicateStateProcess) VerifyEntities(ctx context.Context, entities []Entity, log *zerolog.Logger) ([]Entity, []Entity) {
+	if f.verificationConf.Verifier == nil || len(entities) == 0 {
+		return entities, nil
+	}
+
+	options := []remote.Option{
+		remote.WithAuth(authn.FromConfig(authn.AuthConfig{
+			Username: f.authConfig.SourceRegistryUserName,
+			Password: f.authConfig.SourceRegistryPassword,
+		})),
+		remote.WithContext(ctx),
+		remote.WithUserAgent(satelliteUserAgent),
+	}
+	var nameOptions []name.Option
+	if f.authConfig.UseUnsecure {
+		nameOptions = append(nameOptions, name.Insecure)
+	}
+
+	var allowed, rejected []Entity
+	var details []string
+	for _, entity := range entities {
+		digest, err := f.verifyEntity(ctx, entity, nameOptions, options)
+		// The entity is pinned to the digest verified, a tag moved in the meantime is not replicated unverified
+		if digest != "" {
+			entity.Digest = digest
+		}
+		if err == nil {
+			allowed = append(allowed, entity)
+			continue
+		}
+		log.Warn().Err(err).Msgf("Signature verification failed for %s", entity.String())
+		details = append(details, fmt.Sprintf("%s: %v", entity.String(), err))
+		rejected = append(rejected, entity)
+		if !f.verificationConf.Enforce {
+			allowed = append(allowed, entity)
+		}
+	}
+
+	if len(rejected) > 0 {
+		message := fmt.Sprintf("%d of %d entities were not replicated because their signature could not be verified", len(rejected), len(entities))
+		level := notifier.ErrorLevel
+		if !f.verificationConf.Enforce {
+			message = fmt.Sprintf("%d of %d entities have no trusted signature and were replicated anyway", len(rejected), len(entities))
+			level = notifier.WarningLevel
+		}
+		if err := f.notifier.Notify(notifier.Notification{Source: f.name, Level: level, Message: message, Details: details}); err != nil {
+			log.Error().Err(err).Msg("Error sending notification")
+		}
+	}
+	if !f.verificationConf.Enforce {
+		return allowed, nil
+	}
+	return allowed, rejected
+}
+
+// verifyEntity verifies the signature of the manifest the entity refers to and returns its digest, the tag is
+// resolved to its digest at the source registry if the entity does not pin a digest
+func (f *FetchAndReplicateStateProcess) verifyEntity(ctx context.Context, entity Entity, nameOptions []name.Option, options []remote.Option) (string, error) {
+	repository, err := name.NewRepository(fmt.Sprintf("%s/%s/%s", f.authConfig.SourceRegistry, entity.GetRepository(), entity.GetName()), nameOptions...)
+	if err != nil {
+		return "", fmt.Errorf("invalid source repository: %w", err)
+	}
+	digest := entity.Digest
+	if digest == "" {
+		desc, err := remote.Head(repository.Tag(entity.GetTag()), options...)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve tag %s: %w", entity.GetTag(), err)
+		}
+		digest = desc.Digest.String()
+	}
+	return digest, f.verificationConf.Verifier.Verify(ctx, repository.Digest(digest), options...)
+}
+
+// KeepRejectedEntities removes from the deletion list the previous version of the entities rejected by the
+// verification, so that an update without a trusted signature leaves the verified image in place. It returns
+// the entities to delete and the previous versions kept.
+func (f *FetchAndReplicateStateProcess) KeepRejectedEntities(deleteEntity, rejectedEntity []Entity, log *zerolog.Logger) ([]Entity, []Entity) {
+	if len(rejectedEntity) == 0 {
+		return deleteEntity, nil
+	}
+	rejected := make(map[string]bool, len(rejectedEntity))
+	for _, entity := range rejectedEntity {
+		rejected[entityKey(entity)] = true
+	}
+	var entities, kept []Entity
+	for _, entity := range deleteEntity {
+		if rejected[entityKey(entity)] {
+			log.Info().Msgf("Keeping %s as its update was rejected", entity.String())
+			kept = append(kept, entity)
+			continue
+		}
+		entities = append(entities, entity)
+	}
+	return entities, kept
+}
+
+// replaceEntities returns the entities with the ones having the same key as a kept entity replaced by it
+func replaceEntities(entities, kept []Entity) []Entity {
+	if len(kept) == 0 {
+		return entities
+	}
+	keptEntities := make(map[string]Entity, len(kept))
+	for _, entity := range kept {
+		keptEntities[entityKey(entity)] = entity
+	}
+	result := make([]Entity, 0, len(entities))
+	for _, entity := range entities {
+		if previous, ok := keptEntities[entityKey(entity)]; ok {
+			entity = previous
+		}
+		result = append(result, entity)
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/container-registry/harbor-satellite/internal/notifier"
+	"github.com/container-registry/harbor-satellite/internal/verifier"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// digestVerifier trusts a fixed set of digests
+type digestVerifier map[string]bool
+
+func (v digestVerifier) Verify(_ context.Context, digest name.Digest, _ ...remote.Option) error {
+	if !v[digest.DigestStr()] {
+		return verifier.ErrNoTrustedSignature
+	}
+	return nil
+}
+
+func TestVerifyEntitiesKeepsVerifiedImage(t *testing.T) {
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	source := newTestRegistry(t)
+
+	signed, err := random.Image(256, 1)
+	require.NoError(t, err)
+	signedDigest, err := signed.Digest()
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(signed, source+"/team/server:v1"))
+	require.NoError(t, crane.Push(signed, source+"/team/api:v1"))
+
+	process := &FetchAndReplicateStateProcess{
+		mu:       &sync.Mutex{},
+		notifier: notifier.NewSimpleNotifier(ctx),
+		authConfig: FetchAndReplicateAuthConfig{
+			SourceRegistry: source,
+			UseUnsecure:    true,
+		},
+		verificationConf: NewVerificationConfig(digestVerifier{signedDigest.String(): true}, true),
+	}
+
+	// The tag is resolved once, the entity replicated is pinned to the digest verified
+	allowed, rejected := process.VerifyEntities(ctx, []Entity{{Repository: "team", Name: "api", Tag: "v1"}}, &nop)
+	assert.Empty(t, rejected)
+	require.Len(t, allowed, 1)
+	assert.Equal(t, signedDigest.String(), allowed[0].Digest)
+
+	// An update without a trusted signature is rejected and the verified image is not deleted
+	unsigned, err := random.Image(256, 1)
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(unsigned, source+"/team/server:v1"))
+	previous := Entity{Repository: "team", Name: "server", Tag: "v1", Digest: signedDigest.String()}
+	allowed, rejected = process.VerifyEntities(ctx, []Entity{{Repository: "team", Name: "server", Tag: "v1"}}, &nop)
+	assert.Empty(t, allowed)
+	require.Len(t, rejected, 1)
+
+	deleteEntity, kept := process.KeepRejectedEntities([]Entity{previous, {Repository: "team", Name: "old", Tag: "v1"}}, rejected, &nop)
+	require.Len(t, deleteEntity, 1)
+	assert.Equal(t, "old", deleteEntity[0].Name)
+	assert.Equal(t, []Entity{previous}, kept)
+	assert.Equal(t, []Entity{previous}, replaceEntities([]Entity{{Repository: "team", Name: "server", Tag: "v1"}}, kept))
+}
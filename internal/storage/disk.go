@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// DiskUsage is the size of a file system and the space left on it
+type DiskUsage struct {
+	TotalBytes int64 `json:"total_bytes"`
+	FreeBytes  int64 `json:"free_bytes"`
+}
+
+// Usage returns the size of the file system holding path and the bytes available on it
+func Usage(path string) (DiskUsage, error) {
+	total, free, err := diskSpace(path)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("failed to read the free space of %s: %w", path, err)
+	}
+	return DiskUsage{TotalBytes: total, FreeBytes: free}, nil
+}
+
+// DiskHeadroomCheck returns a check failing when the file system holding path has less than minFree bytes available
+func DiskHeadroomCheck(path string, minFree int64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		usage, err := Usage(path)
+		if err != nil {
+			return err
+		}
+		if usage.FreeBytes < minFree {
+			return fmt.Errorf("%d bytes available on %s, below the minimum of %d bytes", usage.FreeBytes, path, minFree)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package storage
+
+import "errors"
+
+func diskSpace(path string) (total, free int64, err error) {
+	return 0, 0, errors.New("free space is not supported on this platform")
+}
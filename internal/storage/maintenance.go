@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messages of the garbage collection and scrub of zot, as written to its log
+const (
+	zotGCCollectedMessage   = "garbage collected blobs"
+	zotGCSucceededMessage   = "GC successfully completed for "
+	zotGCFailedMessage      = "GC unsuccessfully completed for "
+	zotScrubOKMessage       = "blobs/manifest ok"
+	zotScrubAffectedMessage = "blobs/manifest affected"
+)
+
+// GCStatus is the outcome of the garbage collections run by zot since the satellite started
+type GCStatus struct {
+	// LastRun is the time zot last completed the garbage collection of a repository
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// Repositories is the number of repository garbage collections completed
+	Repositories int `json:"repositories"`
+	// DeletedBlobs is the number of unreferenced blobs deleted
+	DeletedBlobs int `json:"deleted_blobs"`
+	// Failed lists the repositories whose last garbage collection failed
+	Failed []string `json:"failed,omitempty"`
+}
+
+// ScrubStatus is the outcome of the scrubs run by zot since the satellite started
+type ScrubStatus struct {
+	// LastRun is the time zot last checked an image
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// Corrupted lists the images, as <repository>:<tag>, whose last check found a missing or corrupted blob
+	Corrupted []string `json:"corrupted,omitempty"`
+}
+
+// zotLogLine holds the fields of the garbage collection and scrub lines of the zot log
+type zotLogLine struct {
+	Message string    `json:"message"`
+	Module  string    `json:"module"`
+	Count   int       `json:"count"`
+	Image   string    `json:"image"`
+	Tag     string    `json:"tag"`
+	Time    time.Time `json:"time"`
+}
+
+// Maintenance reports the garbage collection and scrub zot runs on its own schedule, as read from its log. zot
+// collects the blobs under its own locks and keeps its dedupe cache consistent, the satellite only reports.
+type Maintenance struct {
+	registryLog *RegistryLog
+	mu          sync.Mutex
+	gc          GCStatus
+	gcFailed    map[string]bool
+	lastScrub   *time.Time
+	corrupted   map[string]bool
+}
+
+// NewMaintenance returns a report fed by the lines of the registry log
+func NewMaintenance(registryLog *RegistryLog) *Maintenance {
+	m := &Maintenance{registryLog: registryLog, gcFailed: make(map[string]bool), corrupted: make(map[string]bool)}
+	registryLog.Subscribe(m.read)
+	return m
+}
+
+// GC returns the outcome of the garbage collections, the registry log is read first to include the latest runs
+func (m *Maintenance) GC() (GCStatus, error) {
+	if err := m.registryLog.Read(); err != nil {
+		return GCStatus{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := m.gc
+	status.Failed = slices.Sorted(maps.Keys(m.gcFailed))
+	return status, nil
+}
+
+// Scrub returns the outcome of the scrubs, the registry log is read first to include the latest runs
+func (m *Maintenance) Scrub() (ScrubStatus, error) {
+	if err := m.registryLog.Read(); err != nil {
+		return ScrubStatus{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return ScrubStatus{LastRun: m.lastScrub, Corrupted: slices.Sorted(maps.Keys(m.corrupted))}, nil
+}
+
+// read records the garbage collection and scrub lines of the registry log
+func (m *Maintenance) read(lines [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, data := range lines {
+		var line zotLogLine
+		if err := json.Unmarshal(data, &line); err != nil {
+			continue
+		}
+		switch {
+		case line.Message == zotGCCollectedMessage:
+			m.gc.DeletedBlobs += line.Count
+			deletedBlobs.Add(float64(line.Count))
+		case strings.HasPrefix(line.Message, zotGCSucceededMessage):
+			repository := strings.TrimPrefix(line.Message, zotGCSucceededMessage)
+			delete(m.gcFailed, repository)
+			m.gc.Repositories++
+			m.gc.LastRun = &line.Time
+			collections.WithLabelValues("success").Inc()
+		case strings.HasPrefix(line.Message, zotGCFailedMessage):
+			repository := strings.TrimPrefix(line.Message, zotGCFailedMessage)
+			m.gcFailed[repository] = true
+			m.gc.Repositories++
+			m.gc.LastRun = &line.Time
+			collections.WithLabelValues("failure").Inc()
+		case strings.HasSuffix(line.Message, zotScrubOKMessage):
+			delete(m.corrupted, fmt.Sprintf("%s:%s", line.Image, line.Tag))
+			m.lastScrub = &line.Time
+		case strings.HasSuffix(line.Message, zotScrubAffectedMessage):
+			m.corrupted[fmt.Sprintf("%s:%s", line.Image, line.Tag)] = true
+			m.lastScrub = &line.Time
+		}
+	}
+	corruptedImages.Set(float64(len(m.corrupted)))
+	return nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceReportsZotRuns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zot.log")
+	zotLog := `{"level":"info","module":"gc","time":"2026-10-16T10:00:00Z","message":"executing GC of orphaned blobs for /var/lib/zot/team/app"}
+{"level":"info","module":"gc","repository":"team/app","count":3,"time":"2026-10-16T10:00:01Z","message":"garbage collected blobs"}
+{"level":"info","module":"gc","time":"2026-10-16T10:00:01Z","message":"GC successfully completed for /var/lib/zot/team/app"}
+{"level":"info","module":"gc","time":"2026-10-16T10:00:02Z","message":"GC unsuccessfully completed for /var/lib/zot/team/db"}
+{"level":"info","image":"team/app","tag":"v1","status":"ok","time":"2026-10-16T10:05:00Z","message":"scrub: blobs/manifest ok"}
+{"level":"warn","image":"team/db","tag":"v2","status":"affected","affected blob":"sha256:0f","error":"blob not found","time":"2026-10-16T10:05:01Z","message":"scrub: blobs/manifest affected"}
+{"level":"info","method":"GET","path":"/v2/team/app/manifests/v1","statusCode":200,"message":"HTTP API"}
+`
+	require.NoError(t, os.WriteFile(path, []byte(zotLog), 0600))
+	maintenance := NewMaintenance(NewRegistryLog(path, filepath.Join(dir, "zot.offset.json")))
+
+	gc, err := maintenance.GC()
+	require.NoError(t, err)
+	assert.Equal(t, 2, gc.Repositories)
+	assert.Equal(t, 3, gc.DeletedBlobs)
+	assert.Equal(t, []string{"/var/lib/zot/team/db"}, gc.Failed)
+	require.NotNil(t, gc.LastRun)
+	assert.Equal(t, "2026-10-16T10:00:02Z", gc.LastRun.UTC().Format("2006-01-02T15:04:05Z"))
+
+	scrub, err := maintenance.Scrub()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/db:v2"}, scrub.Corrupted)
+
+	// A later check of the image clears it, and a successful collection clears the failed repository
+	appendLog(t, path, []byte(`{"level":"info","image":"team/db","tag":"v2","status":"ok","time":"2026-10-16T11:05:00Z","message":"scrub: blobs/manifest ok"}
+{"level":"info","module":"gc","time":"2026-10-16T11:00:01Z","message":"GC successfully completed for /var/lib/zot/team/db"}
+`))
+	scrub, err = maintenance.Scrub()
+	require.NoError(t, err)
+	assert.Empty(t, scrub.Corrupted)
+	gc, err = maintenance.GC()
+	require.NoError(t, err)
+	assert.Empty(t, gc.Failed)
+	assert.Equal(t, 3, gc.Repositories)
+}
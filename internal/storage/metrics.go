@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deletedBlobs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "gc",
+		Name:      "deleted_blobs_total",
+		Help:      "Number of unreferenced blobs deleted by the garbage collection of zot",
+	})
+	collections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "satellite",
+		Subsystem: "gc",
+		Name:      "runs_total",
+		Help:      "Number of repository garbage collections completed by zot",
+	}, []string{"result"})
+	corruptedImages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "satellite",
+		Subsystem: "scrub",
+		Name:      "corrupted_images",
+		Help:      "Number of images whose last scrub by zot found a missing or corrupted blob",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(deletedBlobs, collections, corruptedImages)
+}
+
+// diskUsageRefreshInterval bounds how often the storage is walked to compute its size, as scrapes may be frequent
+const diskUsageRefreshInterval = time.Minute
+
+// diskUsage computes the size of the storage of the local registry, cached for the refresh interval
+type diskUsage struct {
+	rootDir string
+	mu      sync.Mutex
+	size    int64
+	updated time.Time
+}
+
+func (d *diskUsage) bytes() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.updated) >= diskUsageRefreshInterval {
+		d.size = dirSize(d.rootDir)
+		d.updated = time.Now()
+	}
+	return float64(d.size)
+}
+
+// RegisterDiskUsage exposes the disk usage of the storage of the local registry rooted at rootDir
+func RegisterDiskUsage(rootDir string) error {
+	usage := &diskUsage{rootDir: rootDir}
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "satellite",
+		Subsystem: "local_registry",
+		Name:      "disk_usage_bytes",
+		Help:      "Size of the storage of the local registry",
+	}, usage.bytes))
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// registryLogTruncateSize is the size past which the registry log is truncated once read. zot appends to its log
+// for as long as it runs and the lines it writes while the log is truncated are lost, so the log is not truncated
+// on every read.
+const registryLogTruncateSize = 1 << 20
+
+// RegistryLogReadInterval is the interval at which the registry log is read when no reader asks for it
+const RegistryLogReadInterval = time.Minute
+
+// RegistryLog reads the JSON log the bundled zot registry writes to a file and hands the new lines to its readers.
+// The position read up to is saved next to the reconciliation state so that a restart does not read the same
+// lines again, and the log is truncated once read so that it does not fill the disk holding the images.
+type RegistryLog struct {
+	path string
+	// offsetPath is the file the position read up to is saved to
+	offsetPath string
+	mu         sync.Mutex
+	offset     int64
+	loaded     bool
+	readers    []func(lines [][]byte) error
+}
+
+// registryLogOffset is the content of the offset file
+type registryLogOffset struct {
+	Offset int64 `json:"offset"`
+}
+
+func NewRegistryLog(path, offsetPath string) *RegistryLog {
+	return &RegistryLog{path: path, offsetPath: offsetPath}
+}
+
+// Subscribe adds a reader called with the lines written since the previous read. The lines are read again if a
+// reader fails, the readers should handle them idempotently.
+func (l *RegistryLog) Subscribe(reader func(lines [][]byte) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.readers = append(l.readers, reader)
+}
+
+// Read hands the complete lines written since the previous read to the readers. The offset is reset when the log
+// shrinks, as it was rotated or truncated by someone else.
+func (l *RegistryLog) Read() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open registry log %s: %w", l.path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read registry log %s: %w", l.path, err)
+	}
+	if info.Size() < l.offset {
+		l.offset = 0
+	}
+	if info.Size() == l.offset {
+		return nil
+	}
+	if _, err := file.Seek(l.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read registry log %s: %w", l.path, err)
+	}
+
+	var lines [][]byte
+	offset := l.offset
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A partially written line is read again on the next call
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read registry log %s: %w", l.path, err)
+		}
+		offset += int64(len(line))
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	for _, read := range l.readers {
+		if err := read(lines); err != nil {
+			return err
+		}
+	}
+	l.offset = offset
+	if err := l.save(); err != nil {
+		return err
+	}
+	return l.truncate()
+}
+
+// Watch reads the log at every interval until the context is done, so that the log is truncated even when no
+// reader asks for it
+func (l *RegistryLog) Watch(ctx context.Context, interval time.Duration, log *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Read(); err != nil {
+				log.Warn().Err(err).Msg("Error reading the registry log")
+			}
+		}
+	}
+}
+
+// truncate empties the log once read up to its end and larger than the truncate size
+func (l *RegistryLog) truncate() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read registry log %s: %w", l.path, err)
+	}
+	if l.offset < registryLogTruncateSize || info.Size() != l.offset {
+		return nil
+	}
+	if err := os.Truncate(l.path, 0); err != nil {
+		return fmt.Errorf("failed to truncate registry log %s: %w", l.path, err)
+	}
+	l.offset = 0
+	return l.save()
+}
+
+// load reads the saved offset on first use, a missing or unreadable file starts from the beginning of the log
+func (l *RegistryLog) load() error {
+	if l.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(l.offsetPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read registry log offset %s: %w", l.offsetPath, err)
+	}
+	var saved registryLogOffset
+	if err == nil && json.Unmarshal(data, &saved) == nil {
+		l.offset = saved.Offset
+	}
+	l.loaded = true
+	return nil
+}
+
+func (l *RegistryLog) save() error {
+	data, err := json.Marshal(registryLogOffset{Offset: l.offset})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.offsetPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write registry log offset %s: %w", l.offsetPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryLogResumesAndTruncates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zot.log")
+	offsetPath := filepath.Join(dir, "zot.offset.json")
+	require.NoError(t, os.WriteFile(path, []byte("{\"message\":\"first\"}\n{\"message\":\"partial"), 0600))
+
+	var read [][]byte
+	newLog := func() *RegistryLog {
+		registryLog := NewRegistryLog(path, offsetPath)
+		registryLog.Subscribe(func(lines [][]byte) error {
+			read = append(read, lines...)
+			return nil
+		})
+		return registryLog
+	}
+	require.NoError(t, newLog().Read())
+	assert.Equal(t, [][]byte{[]byte("{\"message\":\"first\"}\n")}, read)
+
+	// A restart resumes after the lines already read
+	read = nil
+	appendLog(t, path, []byte("\"}\n"))
+	registryLog := newLog()
+	require.NoError(t, registryLog.Read())
+	assert.Equal(t, [][]byte{[]byte("{\"message\":\"partial\"}\n")}, read)
+	require.NoError(t, registryLog.Read())
+	assert.Len(t, read, 1)
+
+	// The log is truncated once read past the truncate size
+	read = nil
+	line := append(bytes.Repeat([]byte("x"), 1023), '\n')
+	appendLog(t, path, bytes.Repeat(line, registryLogTruncateSize/len(line)))
+	require.NoError(t, registryLog.Read())
+	assert.Len(t, read, registryLogTruncateSize/len(line))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	read = nil
+	appendLog(t, path, []byte("{\"message\":\"after\"}\n"))
+	require.NoError(t, newLog().Read())
+	assert.Equal(t, [][]byte{[]byte("{\"message\":\"after\"}\n")}, read)
+}
+
+func appendLog(t *testing.T, path string, data []byte) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
@@ -72,13 +72,15 @@ func HasInvalidPathChars(input string) bool {
 	return strings.ContainsAny(input, "\\:*?\"<>|")
 }
 
+// GetRepositoryAndImageNameFromArtifact splits the repository of an artifact into the repository path and the
+// image name. The repository path may be nested, e.g. team/platform/api/server gives team/platform/api and server.
 func GetRepositoryAndImageNameFromArtifact(repository string) (string, string, error) {
-	parts := strings.Split(repository, "/")
-	if len(parts) < 2 {
+	i := strings.LastIndex(repository, "/")
+	if i <= 0 || i == len(repository)-1 {
 		return "", "", fmt.Errorf("invalid repository format: %s. Expected format: repo/image", repository)
 	}
-	repo := parts[0]
-	image := parts[1]
+	repo := repository[:i]
+	image := repository[i+1:]
 	return repo, image, nil
 }
 
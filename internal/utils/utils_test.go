@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRepositoryAndImageNameFromArtifact(t *testing.T) {
+	tests := []struct {
+		repository string
+		repo       string
+		image      string
+		wantErr    bool
+	}{
+		{repository: "library/nginx", repo: "library", image: "nginx"},
+		{repository: "team/platform/server", repo: "team/platform", image: "server"},
+		{repository: "team/platform/api/server", repo: "team/platform/api", image: "server"},
+		{repository: "nginx", wantErr: true},
+		{repository: "/nginx", wantErr: true},
+		{repository: "library/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repository, func(t *testing.T) {
+			repo, image, err := GetRepositoryAndImageNameFromArtifact(tt.repository)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.repo, repo)
+			assert.Equal(t, tt.image, image)
+		})
+	}
+}
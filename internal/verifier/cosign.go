@@ -0,0 +1,174 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// cosignSignatureAnnotation is the layer annotation holding the base64 encoded signature of the layer
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// cosignSignatureArtifactType is the artifact type of the signatures attached through the OCI referrers API
+	cosignSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+// simpleSigningPayload is the payload signed by cosign, only the fields checked by the satellite are decoded
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifyCosign verifies the cosign signatures attached to the digest, either with the legacy
+// sha256-<digest>.sig tag or through the OCI referrers API
+func (v *SignatureVerifier) verifyCosign(ctx context.Context, digest name.Digest, options []remote.Option) error {
+	log := logger.FromContext(ctx)
+	signatures, err := cosignSignatures(digest, options)
+	if err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return fmt.Errorf("no cosign signature attached")
+	}
+
+	var errs []error
+	for _, signature := range signatures {
+		layers, err := signature.Layers()
+		if err != nil {
+			return err
+		}
+		manifest, err := signature.Manifest()
+		if err != nil {
+			return err
+		}
+		for i, layer := range layers {
+			if err := v.verifyCosignLayer(layer, manifest.Layers[i].Annotations, digest.DigestStr()); err != nil {
+				log.Warn().Msgf("Invalid cosign signature for %s: %v", digest.String(), err)
+				errs = append(errs, err)
+				continue
+			}
+			return nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cosignSignatures returns the signature manifests attached to the digest
+func cosignSignatures(digest name.Digest, options []remote.Option) ([]v1.Image, error) {
+	var signatures []v1.Image
+	hash, err := v1.NewHash(digest.DigestStr())
+	if err != nil {
+		return nil, err
+	}
+
+	tag := digest.Context().Tag(fmt.Sprintf("%s-%s.sig", hash.Algorithm, hash.Hex))
+	img, err := remote.Image(tag, options...)
+	switch {
+	case err == nil:
+		signatures = append(signatures, img)
+	case !isNotFound(err):
+		return nil, fmt.Errorf("failed to fetch %s: %w", tag.String(), err)
+	}
+
+	referrers, err := remote.Referrers(digest, append(options, remote.WithFilter("artifactType", cosignSignatureArtifactType))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of %s: %w", digest.String(), err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range manifest.Manifests {
+		if referrer.ArtifactType != cosignSignatureArtifactType {
+			continue
+		}
+		img, err := remote.Image(digest.Context().Digest(referrer.Digest.String()), options...)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, img)
+	}
+	return signatures, nil
+}
+
+// verifyCosignLayer verifies the signature of a single layer against the trusted keys and checks that
+// the signed payload refers to the expected digest
+func (v *SignatureVerifier) verifyCosignLayer(layer v1.Layer, annotations map[string]string, digest string) error {
+	encoded, ok := annotations[cosignSignatureAnnotation]
+	if !ok {
+		return fmt.Errorf("layer has no %s annotation", cosignSignatureAnnotation)
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	payload, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	verified := false
+	for _, key := range v.cosignKeys {
+		if VerifySignature(key, payload, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return fmt.Errorf("signature does not match any trusted key")
+	}
+
+	var simpleSigning simpleSigningPayload
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s, not %s", simpleSigning.Critical.Image.DockerManifestDigest, digest)
+	}
+	return nil
+}
+
+// VerifySignature verifies the signature of the payload with a public key, using ECDSA or RSA PKCS#1 v1.5
+// over the SHA-256 digest of the payload, or ed25519 over the payload itself
+func VerifySignature(key crypto.PublicKey, payload, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, payload, signature)
+	default:
+		return false
+	}
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
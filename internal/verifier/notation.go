@@ -0,0 +1,225 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// notationSignatureArtifactType is the artifact type of the signatures created by notation
+	notationSignatureArtifactType = "application/vnd.cncf.notary.signature"
+	// notationJWSMediaType is the media type of the JWS signature envelope
+	notationJWSMediaType = "application/jose+json"
+)
+
+// jwsEnvelope is the JWS JSON serialization used by notation
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertificateChain []string `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+// jwsProtectedHeader holds the protected header fields checked by the satellite. The signing time is chosen by
+// the signer and not authenticated, it is not used to validate the certificate chain.
+type jwsProtectedHeader struct {
+	Algorithm string     `json:"alg"`
+	Expiry    *time.Time `json:"io.cncf.notary.expiry"`
+}
+
+// notationPayload is the payload signed by notation
+type notationPayload struct {
+	TargetArtifact struct {
+		Digest string `json:"digest"`
+	} `json:"targetArtifact"`
+}
+
+// verifyNotation verifies the notation signatures attached to the digest through the OCI referrers API.
+// Only JWS envelopes are supported.
+func (v *SignatureVerifier) verifyNotation(ctx context.Context, digest name.Digest, options []remote.Option) error {
+	log := logger.FromContext(ctx)
+	referrers, err := remote.Referrers(digest, append(options, remote.WithFilter("artifactType", notationSignatureArtifactType))...)
+	if err != nil {
+		return fmt.Errorf("failed to list referrers of %s: %w", digest.String(), err)
+	}
+	manifest, err := referrers.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	found := false
+	for _, referrer := range manifest.Manifests {
+		if referrer.ArtifactType != notationSignatureArtifactType {
+			continue
+		}
+		found = true
+		img, err := remote.Image(digest.Context().Digest(referrer.Digest.String()), options...)
+		if err != nil {
+			return err
+		}
+		imgManifest, err := img.Manifest()
+		if err != nil {
+			return err
+		}
+		for _, layer := range imgManifest.Layers {
+			if string(layer.MediaType) != notationJWSMediaType {
+				errs = append(errs, fmt.Errorf("unsupported signature envelope %s", layer.MediaType))
+				continue
+			}
+			blob, err := img.LayerByDigest(layer.Digest)
+			if err != nil {
+				return err
+			}
+			rc, err := blob.Compressed()
+			if err != nil {
+				return err
+			}
+			envelope, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := v.verifyJWS(envelope, digest.DigestStr()); err != nil {
+				log.Warn().Msgf("Invalid notation signature for %s: %v", digest.String(), err)
+				errs = append(errs, err)
+				continue
+			}
+			return nil
+		}
+	}
+	if !found {
+		return fmt.Errorf("no notation signature attached")
+	}
+	return errors.Join(errs...)
+}
+
+// verifyJWS verifies the JWS envelope: the certificate chain must lead to a trusted certificate and be valid now,
+// the signature must be made by the leaf certificate and the payload must refer to the digest. Timestamp
+// countersignatures are not supported, so a signature made by a certificate which has since expired is rejected.
+func (v *SignatureVerifier) verifyJWS(data []byte, digest string) error {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid JWS envelope: %w", err)
+	}
+	protectedData, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header encoding: %w", err)
+	}
+	var protected jwsProtectedHeader
+	if err := json.Unmarshal(protectedData, &protected); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	if protected.Expiry != nil && time.Now().After(*protected.Expiry) {
+		return fmt.Errorf("signature expired at %s", protected.Expiry.String())
+	}
+
+	if len(envelope.Header.CertificateChain) == 0 {
+		return fmt.Errorf("envelope has no certificate chain")
+	}
+	var chain []*x509.Certificate
+	for _, encoded := range envelope.Header.CertificateChain {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid certificate encoding: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifyOptions := x509.VerifyOptions{
+		Roots:         v.notationRoots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	if _, err := chain[0].Verify(verifyOptions); err != nil {
+		return fmt.Errorf("untrusted certificate chain: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	signingInput := []byte(envelope.Protected + "." + envelope.Payload)
+	if err := verifyJWSSignature(protected.Algorithm, chain[0].PublicKey, signingInput, signature); err != nil {
+		return err
+	}
+
+	payloadData, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	var payload notationPayload
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if payload.TargetArtifact.Digest != digest {
+		return fmt.Errorf("signature is for %s, not %s", payload.TargetArtifact.Digest, digest)
+	}
+	return nil
+}
+
+// verifyJWSSignature verifies the signature with the algorithms allowed by the notation specification
+func verifyJWSSignature(algorithm string, key crypto.PublicKey, signingInput, signature []byte) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %s", algorithm)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm[0] != 'P' {
+			return fmt.Errorf("algorithm %s does not match an RSA key", algorithm)
+		}
+		if err := rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if algorithm[0] != 'E' || len(signature)%2 != 0 {
+			return fmt.Errorf("algorithm %s does not match an ECDSA key", algorithm)
+		}
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
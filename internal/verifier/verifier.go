@@ -0,0 +1,128 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ErrNoTrustedSignature is returned when none of the signatures attached to an artifact could be verified
+var ErrNoTrustedSignature = errors.New("no trusted signature found")
+
+type Verifier interface {
+	// Verify checks that the artifact referenced by digest carries at least one signature made by a trusted key
+	Verify(ctx context.Context, digest name.Digest, options ...remote.Option) error
+}
+
+// SignatureVerifier verifies the cosign and notation signatures attached to the artifacts
+type SignatureVerifier struct {
+	// cosignKeys are the public keys trusted for cosign signatures
+	cosignKeys []crypto.PublicKey
+	// notationRoots are the certificates trusted for notation signatures, nil if none is configured
+	notationRoots *x509.CertPool
+}
+
+// NewSignatureVerifier creates a verifier trusting the given cosign public keys and notation certificates.
+// Each key or certificate is either PEM encoded or the path to a PEM encoded file.
+func NewSignatureVerifier(cosignKeys, notationCertificates []string) (*SignatureVerifier, error) {
+	if len(cosignKeys) == 0 && len(notationCertificates) == 0 {
+		return nil, fmt.Errorf("no cosign public key or notation certificate provided")
+	}
+	v := &SignatureVerifier{}
+	for _, key := range cosignKeys {
+		blocks, err := readPEM(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cosign public key: %w", err)
+		}
+		for _, block := range blocks {
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse cosign public key: %w", err)
+			}
+			v.cosignKeys = append(v.cosignKeys, pub)
+		}
+	}
+	for _, certificate := range notationCertificates {
+		blocks, err := readPEM(certificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read notation certificate: %w", err)
+		}
+		for _, block := range blocks {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse notation certificate: %w", err)
+			}
+			if v.notationRoots == nil {
+				v.notationRoots = x509.NewCertPool()
+			}
+			v.notationRoots.AddCert(cert)
+		}
+	}
+	return v, nil
+}
+
+// Verify looks for a valid cosign signature first and falls back to notation signatures
+func (v *SignatureVerifier) Verify(ctx context.Context, digest name.Digest, options ...remote.Option) error {
+	log := logger.FromContext(ctx)
+	var errs []error
+	if len(v.cosignKeys) > 0 {
+		err := v.verifyCosign(ctx, digest, options)
+		if err == nil {
+			log.Info().Msgf("Cosign signature of %s verified", digest.String())
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("cosign: %w", err))
+	}
+	if v.notationRoots != nil {
+		err := v.verifyNotation(ctx, digest, options)
+		if err == nil {
+			log.Info().Msgf("Notation signature of %s verified", digest.String())
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("notation: %w", err))
+	}
+	return fmt.Errorf("%w for %s: %w", ErrNoTrustedSignature, digest.String(), errors.Join(errs...))
+}
+
+// LoadPublicKey reads a PKIX public key, which is either PEM encoded or the path to a PEM encoded file
+func LoadPublicKey(value string) (crypto.PublicKey, error) {
+	blocks, err := readPEM(value)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(blocks[0].Bytes)
+}
+
+// readPEM decodes the PEM blocks of the value, which is either PEM data or a path to a PEM file
+func readPEM(value string) ([]*pem.Block, error) {
+	data := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return blocks, nil
+}
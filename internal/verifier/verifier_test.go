@@ -0,0 +1,261 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-registry/harbor-satellite/internal/logger"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	trustedKey := newKey(t)
+	otherKey := newKey(t)
+	root, rootKey := newCertificate(t, nil, nil, true, time.Now().Add(-72*time.Hour), time.Now().Add(time.Hour))
+	otherRoot, otherRootKey := newCertificate(t, nil, nil, true, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	leaf, leafKey := newCertificate(t, root, rootKey, false, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	untrustedLeaf, untrustedLeafKey := newCertificate(t, otherRoot, otherRootKey, false, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	expiredLeaf, expiredLeafKey := newCertificate(t, root, rootKey, false, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+
+	tests := []struct {
+		name string
+		// sign attaches the signatures to the image, other is the digest of another image of the repository
+		sign    func(t *testing.T, digest, other name.Digest)
+		wantErr bool
+	}{
+		{
+			name: "valid cosign signature",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachCosign(t, digest, trustedKey, digest.DigestStr())
+			},
+		},
+		{
+			name: "valid notation signature",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachNotation(t, digest, leaf, leafKey, digest.DigestStr(), time.Now())
+			},
+		},
+		{
+			name: "cosign signature by another key",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachCosign(t, digest, otherKey, digest.DigestStr())
+			},
+			wantErr: true,
+		},
+		{
+			name: "notation signature with an untrusted chain",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachNotation(t, digest, untrustedLeaf, untrustedLeafKey, digest.DigestStr(), time.Now())
+			},
+			wantErr: true,
+		},
+		{
+			name: "cosign payload for another manifest",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachCosign(t, digest, trustedKey, other.DigestStr())
+			},
+			wantErr: true,
+		},
+		{
+			name: "notation payload for another manifest",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachNotation(t, digest, leaf, leafKey, other.DigestStr(), time.Now())
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired certificate with a back-dated signing time",
+			sign: func(t *testing.T, digest, other name.Digest) {
+				attachNotation(t, digest, expiredLeaf, expiredLeafKey, digest.DigestStr(), time.Now().Add(-36*time.Hour))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no signature",
+			sign:    func(t *testing.T, digest, other name.Digest) {},
+			wantErr: true,
+		},
+	}
+
+	nop := zerolog.Nop()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, &nop)
+	verifier, err := NewSignatureVerifier([]string{publicKeyPEM(t, trustedKey.Public())}, []string{certificatePEM(root)})
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := pushImage(t, newTestRegistry(t))
+			other := pushImage(t, digest.Context().RegistryStr())
+			tt.sign(t, digest, other)
+
+			err := verifier.Verify(ctx, digest)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrNoTrustedSignature)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// pushImage pushes a random image to the team/app repository of the registry and returns its digest
+func pushImage(t *testing.T, host string) name.Digest {
+	t.Helper()
+	img, err := random.Image(256, 1)
+	require.NoError(t, err)
+	hash, err := img.Digest()
+	require.NoError(t, err)
+	digest, err := name.NewDigest(fmt.Sprintf("%s/team/app@%s", host, hash), name.Insecure)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(digest, img))
+	return digest
+}
+
+// attachCosign attaches a cosign signature of the payload referring to signedDigest with the legacy signature tag
+func attachCosign(t *testing.T, digest name.Digest, key *ecdsa.PrivateKey, signedDigest string) {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]string{"docker-reference": digest.Context().String()},
+			"image":    map[string]string{"docker-manifest-digest": signedDigest},
+			"type":     "cosign container image signature",
+		},
+	})
+	require.NoError(t, err)
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature)},
+	})
+	require.NoError(t, err)
+	hex := strings.TrimPrefix(digest.DigestStr(), "sha256:")
+	require.NoError(t, remote.Write(digest.Context().Tag("sha256-"+hex+".sig"), img))
+}
+
+// attachNotation attaches a notation JWS signature of the payload referring to signedDigest through the referrers API
+func attachNotation(t *testing.T, digest name.Digest, leaf *x509.Certificate, key *ecdsa.PrivateKey, signedDigest string, signingTime time.Time) {
+	t.Helper()
+	protected, err := json.Marshal(map[string]any{
+		"alg":                        "ES256",
+		"cty":                        "application/vnd.cncf.notary.payload.v1+json",
+		"io.cncf.notary.signingTime": signingTime.UTC().Format(time.RFC3339),
+	})
+	require.NoError(t, err)
+	payload, err := json.Marshal(map[string]any{
+		"targetArtifact": map[string]any{"mediaType": string(types.OCIManifestSchema1), "digest": signedDigest},
+	})
+	require.NoError(t, err)
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protected)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(encodedProtected + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	var envelope jwsEnvelope
+	envelope.Protected = encodedProtected
+	envelope.Payload = encodedPayload
+	envelope.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	envelope.Header.CertificateChain = []string{base64.StdEncoding.EncodeToString(leaf.Raw)}
+	data, err := json.Marshal(envelope)
+	require.NoError(t, err)
+
+	desc, err := remote.Head(digest)
+	require.NoError(t, err)
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(data, notationJWSMediaType)})
+	require.NoError(t, err)
+	img = mutate.MediaType(img, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, notationSignatureArtifactType)
+	img = mutate.Subject(img, v1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}).(v1.Image)
+	signatureDigest, err := img.Digest()
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(digest.Context().Digest(signatureDigest.String()), img))
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// newCertificate issues a certificate valid between notBefore and notAfter, self-signed if parent is nil. Leaf
+// certificates are issued for code signing.
+func newCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, notBefore, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key := newKey(t)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("test %d", serial)},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if ca {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func certificatePEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
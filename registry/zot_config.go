@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -56,7 +57,8 @@ type ZotHTTPConfig struct {
 }
 
 type ZotLogConfig struct {
-	Level string `json:"level"`
+	Level  string `json:"level"`
+	Output string `json:"output,omitempty"`
 }
 
 func (c *ZotConfig) GetRegistryURL() string {
@@ -97,3 +99,72 @@ func ReadZotConfig(filePath string, zotConfig *ZotConfig) error {
 func (c *ZotConfig) SetZotRemoteURL(url string) {
 	c.RemoteURL = url
 }
+
+// MaintenanceConfig holds the garbage collection, scrub and log settings generated for zot from the satellite config
+type MaintenanceConfig struct {
+	GC         bool
+	GCDelay    string
+	GCInterval string
+	// ScrubInterval is the interval at which zot scrubs the images, the scrub is disabled if empty
+	ScrubInterval string
+	// LogOutput is the file zot logs its requests to, read by the satellite to know when the images were pulled
+	LogOutput string
+}
+
+// WriteMaintenanceConfig writes a copy of the zot config with the gc and scrub settings applied next to it and
+// returns its path. The config is handled as raw JSON so that the settings not modeled by ZotConfig are kept.
+func WriteMaintenanceConfig(zotConfigPath string, maintenance MaintenanceConfig) (string, error) {
+	data, err := os.ReadFile(zotConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read file: %w", err)
+	}
+	var zotConfig map[string]any
+	if err := json.Unmarshal(data, &zotConfig); err != nil {
+		return "", fmt.Errorf("could not unmarshal JSON: %w", err)
+	}
+
+	storage, _ := zotConfig["storage"].(map[string]any)
+	if storage == nil {
+		storage = make(map[string]any)
+	}
+	storage["gc"] = maintenance.GC
+	if maintenance.GC {
+		storage["gcDelay"] = maintenance.GCDelay
+		storage["gcInterval"] = maintenance.GCInterval
+	}
+	zotConfig["storage"] = storage
+
+	extensions, _ := zotConfig["extensions"].(map[string]any)
+	if extensions == nil {
+		extensions = make(map[string]any)
+	}
+	if maintenance.ScrubInterval != "" {
+		extensions["scrub"] = ScrubConfig{Enable: true, Interval: maintenance.ScrubInterval}
+	} else {
+		delete(extensions, "scrub")
+	}
+	if len(extensions) > 0 {
+		zotConfig["extensions"] = extensions
+	} else {
+		delete(zotConfig, "extensions")
+	}
+
+	if maintenance.LogOutput != "" {
+		zotLog, _ := zotConfig["log"].(map[string]any)
+		if zotLog == nil {
+			zotLog = make(map[string]any)
+		}
+		zotLog["output"] = maintenance.LogOutput
+		zotConfig["log"] = zotLog
+	}
+
+	generated, err := json.MarshalIndent(zotConfig, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not marshal JSON: %w", err)
+	}
+	generatedPath := strings.TrimSuffix(zotConfigPath, filepath.Ext(zotConfigPath)) + ".generated.json"
+	if err := os.WriteFile(generatedPath, generated, 0644); err != nil {
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+	return generatedPath, nil
+}